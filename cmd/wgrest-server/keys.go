@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+)
+
+// keysCommand returns the command managing the encryption of private and
+// preshared keys in config files.
+func keysCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "keys",
+		Usage: "Manage encryption of private and preshared keys in config files",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "generate",
+				Usage:  "Print a new random master key",
+				Action: generateMasterKey,
+			},
+			{
+				Name:   "encrypt",
+				Usage:  "Encrypt the plaintext keys of all config files with the master key",
+				Action: encryptKeys,
+			},
+			{
+				Name:   "decrypt",
+				Usage:  "Write the keys of all config files back in plaintext",
+				Action: decryptKeys,
+			},
+		},
+	}
+}
+
+func generateMasterKey(c *cli.Context) error {
+	key, err := wgquick.GenerateMasterKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println(key)
+	return nil
+}
+
+func encryptKeys(c *cli.Context) error {
+	return rewriteKeys(c, (*wgquick.Service).EncryptKeys)
+}
+
+func decryptKeys(c *cli.Context) error {
+	return rewriteKeys(c, (*wgquick.Service).DecryptKeys)
+}
+
+func rewriteKeys(c *cli.Context, rewrite func(*wgquick.Service) ([]wgquick.ConfigFile, error)) error {
+	wgquickSvc, err := newWgquickService(c, nil)
+	if err != nil {
+		return err
+	}
+
+	files, err := rewrite(wgquickSvc)
+	for _, f := range files {
+		fmt.Println(filepath.Join(f.Dir, f.Name+".conf"))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d config files rewritten\n", len(files))
+	return nil
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
+	"slices"
 	"syscall"
 	"time"
 
@@ -16,11 +20,16 @@ import (
 	"golang.org/x/crypto/acme/autocert"
 
 	"github.com/suquant/wgrest/api/docs"
+	"github.com/suquant/wgrest/internal/infrastructure/acme"
+	"github.com/suquant/wgrest/internal/infrastructure/backup"
 	"github.com/suquant/wgrest/internal/infrastructure/dump"
+	"github.com/suquant/wgrest/internal/infrastructure/storage"
+	"github.com/suquant/wgrest/internal/infrastructure/watch"
 	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
 	"github.com/suquant/wgrest/internal/infrastructure/wireguard"
 	httpInterface "github.com/suquant/wgrest/internal/interface/http"
 	"github.com/suquant/wgrest/internal/interface/http/handler"
+	"github.com/suquant/wgrest/internal/interface/http/middleware"
 	"github.com/suquant/wgrest/internal/usecase"
 )
 
@@ -43,6 +52,77 @@ func defaultConfigDirs() []string {
 	}
 }
 
+// toUint32s converts user supplied IDs to uint32.
+func toUint32s(values []int) []uint32 {
+	result := make([]uint32, len(values))
+	for i, v := range values {
+		result[i] = uint32(v)
+	}
+	return result
+}
+
+// newWgquickService creates the wg-quick config service, keeping config
+// revisions and encrypting keys if enabled. keys sets the keys of devices
+// brought up from encrypted configs.
+func newWgquickService(c *cli.Context, keys wgquick.KeySetter) (*wgquick.Service, error) {
+	svc, err := wgquick.NewService(c.StringSlice("config-dir"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create wgquick service: %w", err)
+	}
+
+	if limit := c.Int("config-history-limit"); limit > 0 {
+		svc.SetHistory(wgquick.NewHistory(c.String("config-history-dir"), limit))
+	}
+
+	cipher, err := keyCipher(c)
+	if err != nil {
+		return nil, err
+	}
+	if cipher != nil {
+		svc.SetKeyCipher(cipher, keys)
+	}
+
+	return svc, nil
+}
+
+// keyCipher returns the cipher for the master key given by
+// key-encryption-key, key-encryption-key-file or key-encryption-credential,
+// or nil if key encryption is disabled.
+func keyCipher(c *cli.Context) (*wgquick.KeyCipher, error) {
+	value, file, credential := c.String("key-encryption-key"), c.String("key-encryption-key-file"), c.String("key-encryption-credential")
+
+	var data []byte
+	switch {
+	case value != "" && (file != "" || credential != ""), file != "" && credential != "":
+		return nil, fmt.Errorf("only one of key-encryption-key, key-encryption-key-file and key-encryption-credential can be set")
+	case value != "":
+		data = []byte(value)
+	case file != "":
+		var err error
+		if data, err = os.ReadFile(file); err != nil {
+			return nil, fmt.Errorf("failed to read key-encryption-key-file: %w", err)
+		}
+	case credential != "":
+		// systemd passes credentials loaded with LoadCredential= in this directory
+		dir := os.Getenv("CREDENTIALS_DIRECTORY")
+		if dir == "" {
+			return nil, fmt.Errorf("key-encryption-credential requires CREDENTIALS_DIRECTORY to be set by systemd")
+		}
+		var err error
+		if data, err = os.ReadFile(filepath.Join(dir, credential)); err != nil {
+			return nil, fmt.Errorf("failed to read key-encryption-credential: %w", err)
+		}
+	default:
+		return nil, nil
+	}
+
+	masterKey, err := wgquick.ParseMasterKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key encryption master key: %w", err)
+	}
+	return wgquick.NewKeyCipher(masterKey)
+}
+
 // @title WGRest API
 // @version 1.0
 // @description REST API for managing WireGuard interfaces and peers
@@ -70,15 +150,75 @@ func main() {
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    "listen",
 			Value:   "127.0.0.1:8000",
-			Usage:   "Listen address",
+			Usage:   "Listen address (host:port or unix:/path/to.sock)",
 			EnvVars: []string{"WGREST_LISTEN"},
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "unix-socket-mode",
+			Value:   "0660",
+			Usage:   "Unix socket file mode",
+			EnvVars: []string{"WGREST_UNIX_SOCKET_MODE"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "unix-socket-group",
+			Value:   "",
+			Usage:   "Unix socket group name or GID",
+			EnvVars: []string{"WGREST_UNIX_SOCKET_GROUP"},
+		}),
+		altsrc.NewIntSliceFlag(&cli.IntSliceFlag{
+			Name:    "unix-allow-uid",
+			Value:   cli.NewIntSlice(),
+			Usage:   "Process UIDs authorized over the Unix socket without a token (Linux)",
+			EnvVars: []string{"WGREST_UNIX_ALLOW_UID"},
+		}),
+		altsrc.NewIntSliceFlag(&cli.IntSliceFlag{
+			Name:    "unix-allow-gid",
+			Value:   cli.NewIntSlice(),
+			Usage:   "Process GIDs authorized over the Unix socket without a token (Linux)",
+			EnvVars: []string{"WGREST_UNIX_ALLOW_GID"},
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    "unix-peer-permission",
+			Value:   cli.NewStringSlice(),
+			Usage:   "Extra permissions granted to processes authorized by UID/GID (secrets:read)",
+			EnvVars: []string{"WGREST_UNIX_PEER_PERMISSION"},
+		}),
 		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
 			Name:    "config-dir",
 			Value:   cli.NewStringSlice(defaultConfigDirs()...),
 			Usage:   "WireGuard config directories (wg-quick style, can specify multiple)",
 			EnvVars: []string{"WGREST_CONFIG_DIR"},
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "config-history-dir",
+			Value:   "/var/lib/wgrest/history",
+			Usage:   "Directory keeping revisions of written config files",
+			EnvVars: []string{"WGREST_CONFIG_HISTORY_DIR"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    "config-history-limit",
+			Value:   50,
+			Usage:   "Config revisions kept per device (0 disables history)",
+			EnvVars: []string{"WGREST_CONFIG_HISTORY_LIMIT"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "key-encryption-key-file",
+			Value:   "",
+			Usage:   "File with the base64 master key encrypting private and preshared keys in config files",
+			EnvVars: []string{"WGREST_KEY_ENCRYPTION_KEY_FILE"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "key-encryption-key",
+			Value:   "",
+			Usage:   "Base64 master key encrypting private and preshared keys in config files",
+			EnvVars: []string{"WGREST_KEY_ENCRYPTION_KEY"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "key-encryption-credential",
+			Value:   "",
+			Usage:   "Name of the systemd credential holding the key encryption master key",
+			EnvVars: []string{"WGREST_KEY_ENCRYPTION_CREDENTIAL"},
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    "certs-dir",
 			Value:   "/var/lib/wgrest/certs",
@@ -91,18 +231,180 @@ func main() {
 			Usage:   "Config dump interval",
 			EnvVars: []string{"WGREST_DUMP_INTERVAL"},
 		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    "dump-debounce",
+			Value:   2 * time.Second,
+			Usage:   "Window in which config saves triggered by API changes are batched (0 saves immediately)",
+			EnvVars: []string{"WGREST_DUMP_DEBOUNCE"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    "peer-cache-ttl",
+			Value:   time.Second,
+			Usage:   "How long peers queried from a device are reused for reads (0 disables)",
+			EnvVars: []string{"WGREST_PEER_CACHE_TTL"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "config-watch",
+			Value:   usecase.ConfigWatchIgnore,
+			Usage:   "How edits of config files outside wgrest are handled: apply, drift or ignore",
+			EnvVars: []string{"WGREST_CONFIG_WATCH"},
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    "static-auth-token",
 			Value:   "",
 			Usage:   "Bearer token for authorization",
 			EnvVars: []string{"WGREST_STATIC_AUTH_TOKEN"},
 		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    "static-auth-token-permission",
+			Value:   cli.NewStringSlice(),
+			Usage:   "Extra permissions granted to the static auth token (secrets:read)",
+			EnvVars: []string{"WGREST_STATIC_AUTH_TOKEN_PERMISSION"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    "rate-limit-ip",
+			Value:   0,
+			Usage:   "Max API requests per source IP within rate-limit-window (0 disables)",
+			EnvVars: []string{"WGREST_RATE_LIMIT_IP"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    "rate-limit-token",
+			Value:   0,
+			Usage:   "Max API requests per bearer token within rate-limit-window (0 disables)",
+			EnvVars: []string{"WGREST_RATE_LIMIT_TOKEN"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    "rate-limit-window",
+			Value:   time.Minute,
+			Usage:   "Rate limit sliding window",
+			EnvVars: []string{"WGREST_RATE_LIMIT_WINDOW"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    "auth-lockout-threshold",
+			Value:   10,
+			Usage:   "Failed authorizations before a source IP is locked out (0 disables)",
+			EnvVars: []string{"WGREST_AUTH_LOCKOUT_THRESHOLD"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    "auth-lockout-duration",
+			Value:   15 * time.Minute,
+			Usage:   "How long a source IP stays locked out",
+			EnvVars: []string{"WGREST_AUTH_LOCKOUT_DURATION"},
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    "cors-allow-origin",
+			Value:   cli.NewStringSlice("*"),
+			Usage:   "Origins allowed for cross-origin API requests",
+			EnvVars: []string{"WGREST_CORS_ALLOW_ORIGIN"},
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    "cors-allow-method",
+			Value:   cli.NewStringSlice(httpInterface.DefaultCORSAllowMethods...),
+			Usage:   "Methods allowed for cross-origin API requests",
+			EnvVars: []string{"WGREST_CORS_ALLOW_METHOD"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    "cors-allow-credentials",
+			Value:   false,
+			Usage:   "Allow credentials in cross-origin API requests (requires explicit origins)",
+			EnvVars: []string{"WGREST_CORS_ALLOW_CREDENTIALS"},
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    "allow-cidr",
+			Value:   cli.NewStringSlice(),
+			Usage:   "Client networks allowed to reach the server (all if empty)",
+			EnvVars: []string{"WGREST_ALLOW_CIDR"},
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    "trusted-proxy",
+			Value:   cli.NewStringSlice(),
+			Usage:   "Proxy networks whose X-Forwarded-For header is trusted",
+			EnvVars: []string{"WGREST_TRUSTED_PROXY"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    "idempotency-ttl",
+			Value:   24 * time.Hour,
+			Usage:   "How long Idempotency-Key headers on create requests are remembered (0 disables)",
+			EnvVars: []string{"WGREST_IDEMPOTENCY_TTL"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "idempotency-store",
+			Value:   "/var/lib/wgrest/idempotency",
+			Usage:   "Directory persisting idempotency keys across restarts (empty keeps them in memory)",
+			EnvVars: []string{"WGREST_IDEMPOTENCY_STORE"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    "request-timeout",
+			Value:   time.Minute,
+			Usage:   "Cancel API requests that take longer (0 disables)",
+			EnvVars: []string{"WGREST_REQUEST_TIMEOUT"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "backup-dir",
+			Value:   "/var/lib/wgrest/backups",
+			Usage:   "Directory for scheduled config backups",
+			EnvVars: []string{"WGREST_BACKUP_DIR"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    "backup-interval",
+			Value:   0,
+			Usage:   "Scheduled config backup interval (0 disables)",
+			EnvVars: []string{"WGREST_BACKUP_INTERVAL"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    "backup-retention",
+			Value:   7,
+			Usage:   "Number of scheduled backups to keep (0 keeps all)",
+			EnvVars: []string{"WGREST_BACKUP_RETENTION"},
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    "backup-recipient",
+			Value:   cli.NewStringSlice(),
+			Usage:   "age recipients scheduled backups are encrypted to (unencrypted if empty)",
+			EnvVars: []string{"WGREST_BACKUP_RECIPIENT"},
+		}),
 		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
 			Name:    "tls-domain",
 			Value:   cli.NewStringSlice(),
 			Usage:   "TLS Domains for ACME (Let's Encrypt)",
 			EnvVars: []string{"WGREST_TLS_DOMAIN"},
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "acme-directory-url",
+			Value:   "",
+			Usage:   "ACME directory URL (default: Let's Encrypt production)",
+			EnvVars: []string{"WGREST_ACME_DIRECTORY_URL"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "acme-ca-file",
+			Value:   "",
+			Usage:   "PEM bundle to trust for the ACME directory TLS certificate",
+			EnvVars: []string{"WGREST_ACME_CA_FILE"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "acme-email",
+			Value:   "",
+			Usage:   "Contact email for the ACME account",
+			EnvVars: []string{"WGREST_ACME_EMAIL"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "acme-eab-kid",
+			Value:   "",
+			Usage:   "ACME External Account Binding key ID",
+			EnvVars: []string{"WGREST_ACME_EAB_KID"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "acme-eab-hmac-key",
+			Value:   "",
+			Usage:   "ACME External Account Binding HMAC key (base64url)",
+			EnvVars: []string{"WGREST_ACME_EAB_HMAC_KEY"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "acme-http-listen",
+			Value:   "",
+			Usage:   "Listen address for the ACME HTTP-01 challenge and HTTPS redirect server (disabled if empty)",
+			EnvVars: []string{"WGREST_ACME_HTTP_LISTEN"},
+		}),
 	}
 
 	app := &cli.App{
@@ -110,6 +412,10 @@ func main() {
 		Usage:  "wgrest - REST API for WireGuard",
 		Flags:  flags,
 		Before: altsrc.InitInputSourceWithContext(flags, altsrc.NewTomlSourceFromFlagFunc("conf")),
+		Commands: []*cli.Command{
+			stateCommand(),
+			keysCommand(),
+		},
 		Action: func(c *cli.Context) error {
 			if c.Bool("version") {
 				fmt.Printf("wgrest version: %s\n", appVersion)
@@ -132,38 +438,190 @@ func main() {
 				return fmt.Errorf("failed to create wireguard client: %w", err)
 			}
 			defer wgClient.Close()
+			wgClient.SetPeerCacheTTL(c.Duration("peer-cache-ttl"))
 
 			// Initialize wg-quick config service
 			configDirs := c.StringSlice("config-dir")
-			wgquickSvc, err := wgquick.NewService(configDirs)
+			wgquickSvc, err := newWgquickService(c, wgClient)
 			if err != nil {
-				return fmt.Errorf("failed to create wgquick service: %w", err)
+				return err
 			}
 
 			// Initialize dump service
 			dumpInterval := c.Duration("dump-interval")
-			dumpService := dump.NewService(dumpInterval, wgClient, wgquickSvc)
+			dumpDebounce := c.Duration("dump-debounce")
+			dumpService := dump.NewService(dumpInterval, dumpDebounce, wgClient, wgquickSvc)
 
 			// Start dump service in background
 			go dumpService.Start(ctx)
-			log.Printf("Config dump service started (interval: %s, dirs: %v)", dumpInterval, configDirs)
+			log.Printf("Config dump service started (interval: %s, debounce: %s, dirs: %v)", dumpInterval, dumpDebounce, configDirs)
+
+			// Watch config directories for edits made outside wgrest
+			configWatch := c.String("config-watch")
+			if !slices.Contains(usecase.ConfigWatchPolicies, configWatch) {
+				return fmt.Errorf("invalid config-watch %q: must be one of %v", configWatch, usecase.ConfigWatchPolicies)
+			}
+			if configWatch != usecase.ConfigWatchIgnore {
+				watchUC := usecase.NewConfigWatchUseCase(wgClient, wgquickSvc, dumpService, configWatch)
+				watcher := watch.NewWatcher(configDirs, time.Second, func(dir, name string) {
+					if err := watchUC.Reconcile(ctx, dir, name); err != nil {
+						log.Printf("Failed to reconcile config for %s: %v", name, err)
+					}
+				})
+				go func() {
+					if err := watcher.Start(ctx); err != nil {
+						log.Printf("Config watcher failed: %v", err)
+					}
+				}()
+				log.Printf("Config watcher started (policy: %s)", configWatch)
+			}
 
 			// Initialize use cases
-			deviceUC := usecase.NewDeviceUseCase(wgClient, wgquickSvc)
-			peerUC := usecase.NewPeerUseCase(wgClient, wgquickSvc)
+			deviceUC := usecase.NewDeviceUseCase(wgClient, wgquickSvc, dumpService)
+			peerUC := usecase.NewPeerUseCase(wgClient, wgquickSvc, dumpService)
+			stateUC := usecase.NewStateUseCase(wgClient, wgquickSvc, appVersion)
+			backupUC := usecase.NewBackupUseCase(wgClient, wgquickSvc, appVersion)
+			dumpUC := usecase.NewDumpUseCase(dumpService)
+
+			// Start scheduled backups in background
+			if backupInterval := c.Duration("backup-interval"); backupInterval > 0 {
+				recipients := c.StringSlice("backup-recipient")
+				if _, err := backup.Recipients("", recipients); err != nil {
+					return fmt.Errorf("invalid backup-recipient: %w", err)
+				}
+				backupDir := c.String("backup-dir")
+				scheduler := backup.NewScheduler(backupDir, backupInterval, c.Int("backup-retention"), len(recipients) > 0,
+					func(w io.Writer) error {
+						_, err := backupUC.Backup(w, "", recipients)
+						return err
+					})
+				go scheduler.Start(ctx)
+				log.Printf("Scheduled backups started (interval: %s, dir: %s)", backupInterval, backupDir)
+			}
 
 			// Initialize handlers
 			deviceHandler := handler.NewDeviceHandler(deviceUC)
 			peerHandler := handler.NewPeerHandler(peerUC)
+			stateHandler := handler.NewStateHandler(stateUC)
+			backupHandler := handler.NewBackupHandler(backupUC)
+			dumpHandler := handler.NewDumpHandler(dumpUC)
+
+			// Parse network access settings
+			allowedNets, err := middleware.ParseCIDRs(c.StringSlice("allow-cidr"))
+			if err != nil {
+				return fmt.Errorf("invalid allow-cidr: %w", err)
+			}
+			trustedProxies, err := middleware.ParseCIDRs(c.StringSlice("trusted-proxy"))
+			if err != nil {
+				return fmt.Errorf("invalid trusted-proxy: %w", err)
+			}
+
+			authTokenPermissions := c.StringSlice("static-auth-token-permission")
+			if err := middleware.ValidatePermissions(authTokenPermissions); err != nil {
+				return fmt.Errorf("invalid static-auth-token-permission: %w", err)
+			}
+
+			var peerCred *middleware.PeerCredConfig
+			if uids, gids := c.IntSlice("unix-allow-uid"), c.IntSlice("unix-allow-gid"); len(uids) > 0 || len(gids) > 0 {
+				permissions := c.StringSlice("unix-peer-permission")
+				if err := middleware.ValidatePermissions(permissions); err != nil {
+					return fmt.Errorf("invalid unix-peer-permission: %w", err)
+				}
+				peerCred = &middleware.PeerCredConfig{
+					UIDs:        toUint32s(uids),
+					GIDs:        toUint32s(gids),
+					Permissions: permissions,
+				}
+			}
+
+			corsAllowOrigins := c.StringSlice("cors-allow-origin")
+			if c.Bool("cors-allow-credentials") {
+				for _, origin := range corsAllowOrigins {
+					if origin == "*" {
+						return fmt.Errorf("cors-allow-credentials requires explicit cors-allow-origin values")
+					}
+				}
+			}
+
+			var idempotencyStorage *storage.DirStorage
+			var idempotencyCipher middleware.ResponseCipher
+			if c.Duration("idempotency-ttl") > 0 {
+				idempotencyStorage, err = storage.NewDirStorage(c.String("idempotency-store"))
+				if err != nil {
+					return fmt.Errorf("failed to open idempotency store: %w", err)
+				}
+
+				// Responses carrying keys are stored encrypted with the master key
+				cipher, err := keyCipher(c)
+				if err != nil {
+					return err
+				}
+				if cipher != nil {
+					idempotencyCipher = cipher
+				}
+			}
 
 			// Setup routes
-			httpInterface.SetupRouter(fiberApp, httpInterface.RouterConfig{
-				DeviceHandler: deviceHandler,
-				PeerHandler:   peerHandler,
-				AuthToken:     c.String("static-auth-token"),
-				Version:       appVersion,
-				OpenAPISpec:   docs.OpenAPISpec,
-			})
+			routerConfig := httpInterface.RouterConfig{
+				DeviceHandler:          deviceHandler,
+				PeerHandler:            peerHandler,
+				StateHandler:           stateHandler,
+				BackupHandler:          backupHandler,
+				DumpHandler:            dumpHandler,
+				AuthToken:              c.String("static-auth-token"),
+				Version:                appVersion,
+				OpenAPISpec:            docs.OpenAPISpec,
+				WireGuardModuleVersion: wireguard.ModuleVersion,
+
+				AuthTokenPermissions: authTokenPermissions,
+				PeerCred:             peerCred,
+
+				IPRateLimit:          c.Int("rate-limit-ip"),
+				TokenRateLimit:       c.Int("rate-limit-token"),
+				RateLimitWindow:      c.Duration("rate-limit-window"),
+				AuthLockoutThreshold: c.Int("auth-lockout-threshold"),
+				AuthLockoutDuration:  c.Duration("auth-lockout-duration"),
+
+				CORSAllowOrigins:     corsAllowOrigins,
+				CORSAllowMethods:     c.StringSlice("cors-allow-method"),
+				CORSAllowCredentials: c.Bool("cors-allow-credentials"),
+				AllowedNets:          allowedNets,
+				TrustedProxies:       trustedProxies,
+
+				IdempotencyLifetime: c.Duration("idempotency-ttl"),
+				RequestTimeout:      c.Duration("request-timeout"),
+			}
+			if idempotencyStorage != nil {
+				routerConfig.IdempotencyStorage = idempotencyStorage
+				routerConfig.IdempotencyCipher = idempotencyCipher
+			}
+			httpInterface.SetupRouter(fiberApp, routerConfig)
+
+			// Configure ACME TLS if domains are set
+			listen := c.String("listen")
+			tlsDomains := c.StringSlice("tls-domain")
+
+			var certManager *autocert.Manager
+			var challengeServer *acme.ChallengeServer
+			if len(tlsDomains) > 0 {
+				certManager, err = acme.NewManager(acme.Config{
+					Domains:         tlsDomains,
+					CacheDir:        c.String("certs-dir"),
+					DirectoryURL:    c.String("acme-directory-url"),
+					DirectoryCAFile: c.String("acme-ca-file"),
+					Email:           c.String("acme-email"),
+					EABKeyID:        c.String("acme-eab-kid"),
+					EABHMACKey:      c.String("acme-eab-hmac-key"),
+				})
+				if err != nil {
+					return fmt.Errorf("failed to configure ACME: %w", err)
+				}
+
+				if httpListen := c.String("acme-http-listen"); httpListen != "" {
+					challengeServer = acme.NewChallengeServer(httpListen, certManager)
+					go challengeServer.Start()
+				}
+			}
 
 			// Handle graceful shutdown
 			go func() {
@@ -176,33 +634,44 @@ func main() {
 
 				time.Sleep(500 * time.Millisecond)
 
+				if challengeServer != nil {
+					shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+					if err := challengeServer.Shutdown(shutdownCtx); err != nil {
+						log.Printf("Error shutting down ACME challenge server: %v", err)
+					}
+					shutdownCancel()
+				}
+
 				if err := fiberApp.Shutdown(); err != nil {
 					log.Printf("Error shutting down server: %v", err)
 				}
 			}()
 
 			// Start server
-			listen := c.String("listen")
-			tlsDomains := c.StringSlice("tls-domain")
+			socketMode, err := httpInterface.ParseFileMode(c.String("unix-socket-mode"))
+			if err != nil {
+				return err
+			}
 
-			if len(tlsDomains) > 0 {
-				// ACME TLS with Let's Encrypt
-				certsDir := c.String("certs-dir")
-				certManager := &autocert.Manager{
-					Prompt:     autocert.AcceptTOS,
-					HostPolicy: autocert.HostWhitelist(tlsDomains...),
-					Cache:      autocert.DirCache(certsDir),
-				}
+			ln, err := httpInterface.Listen(httpInterface.ListenConfig{
+				Address:     listen,
+				SocketMode:  socketMode,
+				SocketGroup: c.String("unix-socket-group"),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to listen on %s: %w", listen, err)
+			}
 
-				// Create TLS listener with autocert
-				tlsListener := certManager.Listener()
+			if certManager != nil {
+				// Wrap the listener with autocert TLS
+				ln = tls.NewListener(ln, certManager.TLSConfig())
 
-				log.Printf("Starting wgrest server with ACME TLS for domains: %v", tlsDomains)
-				return fiberApp.Listener(tlsListener)
+				log.Printf("Starting wgrest server on %s with ACME TLS for domains: %v", listen, tlsDomains)
+				return fiberApp.Listener(ln)
 			}
 
 			log.Printf("Starting wgrest server on %s", listen)
-			return fiberApp.Listen(listen)
+			return fiberApp.Listener(ln)
 		},
 	}
 
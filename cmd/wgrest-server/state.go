@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wireguard"
+	"github.com/suquant/wgrest/internal/usecase"
+)
+
+// stateCommand returns the command importing a state exported by GET /v1/state/.
+func stateCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "state",
+		Usage: "Manage exported server state",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "import",
+				Usage:     "Import devices and peers from an exported state file (JSON or YAML)",
+				ArgsUsage: "<file>",
+				Action:    importState,
+			},
+		},
+	}
+}
+
+func importState(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.Exit("usage: wgrest state import <file>", 2)
+	}
+
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// YAML is a superset of JSON, so this reads both formats
+	var state entity.State
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&state); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", c.Args().First(), err)
+	}
+
+	wgClient, err := wireguard.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to create wireguard client: %w", err)
+	}
+	defer wgClient.Close()
+
+	wgquickSvc, err := newWgquickService(c, wgClient)
+	if err != nil {
+		return err
+	}
+
+	result, err := usecase.NewStateUseCase(wgClient, wgquickSvc, appVersion).ImportState(c.Context, state)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range result.Devices {
+		fmt.Printf("%s: %s (%d changes)\n", device.Name, device.Action, len(device.Changes))
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package entity
+
+import "time"
+
+// BackupVersion is the version of backup archives written by this release.
+const BackupVersion = 1
+
+// Restore actions
+const (
+	// RestoreCreate means the config file doesn't exist yet
+	RestoreCreate = "create"
+
+	// RestoreUpdate means the config file differs from the archive
+	RestoreUpdate = "update"
+
+	// RestoreUnchanged means the config file already matches the archive
+	RestoreUnchanged = "unchanged"
+)
+
+// BackupManifest describes the contents of a backup archive. It is stored
+// in the archive as manifest.json.
+type BackupManifest struct {
+	// Version is the archive format version
+	Version int `json:"version"`
+
+	// WgrestVersion is the wgrest version that wrote the archive
+	WgrestVersion string `json:"wgrest_version,omitempty"`
+
+	// CreatedAt is when the archive was written
+	CreatedAt time.Time `json:"created_at"`
+
+	// Files are the config files in the archive
+	Files []BackupFile `json:"files"`
+}
+
+// BackupFile is a config file in a backup archive.
+type BackupFile struct {
+	// Device is the device name
+	Device string `json:"device"`
+
+	// Dir is the config directory the file was read from
+	Dir string `json:"dir"`
+
+	// Path is the file's path in the archive
+	Path string `json:"path"`
+
+	// Size is the file size in bytes
+	Size int64 `json:"size"`
+
+	// SHA256 is the hex encoded SHA-256 of the file
+	SHA256 string `json:"sha256"`
+}
+
+// RestoreResult lists what restoring a backup did to each config file.
+type RestoreResult struct {
+	// CreatedAt is when the restored archive was written
+	CreatedAt time.Time `json:"created_at"`
+
+	// Devices are the config files in the archive
+	Devices []RestoreDevice `json:"devices"`
+
+	// DryRun is set if nothing was restored
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// RestoreDevice is what restoring a backup did to a config file.
+type RestoreDevice struct {
+	// Name is the device name
+	Name string `json:"name"`
+
+	// Dir is the config directory the file is restored to
+	Dir string `json:"dir"`
+
+	// Action is create, update or unchanged
+	Action string `json:"action" enums:"create,update,unchanged"`
+
+	// Running is set if the device is up and uses this config file
+	Running bool `json:"running,omitempty"`
+
+	// Diff is a unified diff of the config file
+	Diff string `json:"diff,omitempty"`
+
+	// Changes are the changes made to the running device
+	Changes []SpecChange `json:"changes,omitempty"`
+}
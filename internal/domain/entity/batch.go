@@ -0,0 +1,81 @@
+package entity
+
+// Peer batch operations
+const (
+	PeerBatchCreate = "create"
+	PeerBatchUpdate = "update"
+	PeerBatchDelete = "delete"
+)
+
+// Peer batch modes
+const (
+	// PeerBatchAtomic applies all operations or none
+	PeerBatchAtomic = "atomic"
+
+	// PeerBatchPerItem applies the valid operations and reports failures per item
+	PeerBatchPerItem = "per_item"
+)
+
+// Peer batch item statuses
+const (
+	PeerBatchApplied = "applied"
+	PeerBatchFailed  = "failed"
+	PeerBatchSkipped = "skipped"
+)
+
+// PeerBatchRequest is a list of peer operations applied to a device at once.
+type PeerBatchRequest struct {
+	// Mode is atomic (default) or per_item
+	Mode string `json:"mode,omitempty" enums:"atomic,per_item"`
+
+	// Operations are applied in a single device configuration
+	Operations []PeerBatchOperation `json:"operations"`
+}
+
+// PeerBatchOperation creates, updates or deletes a single peer.
+type PeerBatchOperation struct {
+	// Op is the operation
+	Op string `json:"op" enums:"create,update,delete"`
+
+	// PublicKey identifies the peer to update or delete (standard or URL-safe base64)
+	PublicKey string `json:"public_key,omitempty"`
+
+	// Peer is the create or update request; null clears a field on update
+	Peer PeerCreateOrUpdateRequest `json:"peer"`
+}
+
+// PeerBatchResponse reports the outcome of a batch.
+type PeerBatchResponse struct {
+	// Mode is the mode the batch ran in
+	Mode string `json:"mode"`
+
+	// Applied is the number of applied operations
+	Applied int `json:"applied"`
+
+	// Failed is the number of failed operations
+	Failed int `json:"failed"`
+
+	// Results has one entry per operation, in request order
+	Results []PeerBatchResult `json:"results"`
+}
+
+// PeerBatchResult is the outcome of a single batch operation.
+type PeerBatchResult struct {
+	// Index is the position of the operation in the request
+	Index int `json:"index"`
+
+	// Op is the operation
+	Op string `json:"op"`
+
+	// Status is applied, failed, or skipped when an atomic batch had failures
+	Status string `json:"status" enums:"applied,failed,skipped"`
+
+	// Peer is the created, updated or deleted peer
+	Peer *Peer `json:"peer,omitempty"`
+
+	// Error describes why the operation failed
+	Error *Error `json:"error,omitempty"`
+
+	// Err is the error behind Error
+	Err error `json:"-"`
+}
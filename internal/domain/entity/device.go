@@ -1,5 +1,7 @@
 package entity
 
+import "slices"
+
 // Device represents a WireGuard device/interface with wg-quick configuration.
 type Device struct {
 	// Name is the WireGuard interface name
@@ -12,6 +14,7 @@ type Device struct {
 	PublicKey string `json:"public_key"`
 
 	// PrivateKey is the device private key (base64) - only returned on create
+	// or with include_secrets by callers holding the secrets:read permission
 	PrivateKey string `json:"private_key,omitempty"`
 
 	// FirewallMark is the device firewall mark
@@ -99,4 +102,12 @@ type DeviceCreateOrUpdateRequest struct {
 
 	// PostDown commands
 	PostDown []string `json:"post_down,omitempty"`
+
+	// NullFields lists the fields set to null in a merge patch
+	NullFields []string `json:"-"`
+}
+
+// IsNull reports whether a merge patch set the field to null.
+func (r DeviceCreateOrUpdateRequest) IsNull(field string) bool {
+	return slices.Contains(r.NullFields, field)
 }
@@ -0,0 +1,80 @@
+package entity
+
+// Peer drift states
+const (
+	// PeerDriftFileOnly is a peer in the config file but not on the interface
+	PeerDriftFileOnly = "file_only"
+
+	// PeerDriftRunningOnly is a peer on the interface but not in the config file
+	PeerDriftRunningOnly = "running_only"
+
+	// PeerDriftChanged is a peer whose settings differ
+	PeerDriftChanged = "changed"
+)
+
+// Sync targets
+const (
+	// SyncToKernel brings the running interface to the config file
+	SyncToKernel = "kernel"
+
+	// SyncToFile writes the running interface to the config file
+	SyncToFile = "file"
+)
+
+// DeviceDrift is the difference between a device's config file and its
+// running interface.
+type DeviceDrift struct {
+	// Device is the device name
+	Device string `json:"device"`
+
+	// InSync is set if the config file matches the running interface
+	InSync bool `json:"in_sync"`
+
+	// Fields are the interface settings that differ
+	Fields []DriftField `json:"fields,omitempty"`
+
+	// Peers are the peers that differ
+	Peers []PeerDrift `json:"peers,omitempty"`
+}
+
+// DriftField is a setting whose value differs between the config file and the
+// running interface.
+type DriftField struct {
+	// Field is the setting name
+	Field string `json:"field"`
+
+	// File is the value in the config file
+	File string `json:"file"`
+
+	// Running is the value on the running interface
+	Running string `json:"running"`
+}
+
+// PeerDrift is a peer that differs between the config file and the running
+// interface.
+type PeerDrift struct {
+	// PublicKey is the peer public key
+	PublicKey string `json:"public_key"`
+
+	// State is file_only, running_only or changed
+	State string `json:"state"`
+
+	// Fields are the settings that differ for a changed peer
+	Fields []DriftField `json:"fields,omitempty"`
+}
+
+// DeviceSyncResult is what syncing a device's config file and running
+// interface did.
+type DeviceSyncResult struct {
+	// To is what was brought in line: kernel or file
+	To string `json:"to"`
+
+	// Drift is the difference before the sync
+	Drift DeviceDrift `json:"drift"`
+
+	// Changes are the changes made to the running interface
+	Changes []SpecChange `json:"changes,omitempty"`
+
+	// DryRun is set if nothing was changed
+	DryRun bool `json:"dry_run,omitempty"`
+}
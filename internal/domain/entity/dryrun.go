@@ -0,0 +1,16 @@
+package entity
+
+// DryRunResult describes what a mutating request would do when run with dry_run=true.
+type DryRunResult struct {
+	// Device is the device as it would be after the request
+	Device *Device `json:"device,omitempty"`
+
+	// Peer is the peer as it would be after the request (or the deleted peer)
+	Peer *Peer `json:"peer,omitempty"`
+
+	// ConfigDiff is a unified diff of the wg-quick config file that would be written
+	ConfigDiff string `json:"config_diff"`
+
+	// Warnings lists side effects such as allowed IPs moving between peers
+	Warnings []string `json:"warnings,omitempty"`
+}
@@ -0,0 +1,28 @@
+package entity
+
+import "time"
+
+// DumpStatus is the state of a device's config dump.
+type DumpStatus struct {
+	// Device is the device name
+	Device string `json:"device"`
+
+	// Hash is the hex encoded canonical hash of the device state last checked
+	Hash string `json:"hash,omitempty"`
+
+	// CheckedAt is when the device state was last compared with its config file
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+
+	// SavedAt is when the config file was last written
+	SavedAt *time.Time `json:"saved_at,omitempty"`
+
+	// Pending is set if a save is waiting for the debounce window to end
+	Pending bool `json:"pending,omitempty"`
+
+	// Drifted is set if the config file was edited outside wgrest and differs
+	// from the running device; periodic dumps leave it alone
+	Drifted bool `json:"drifted,omitempty"`
+
+	// Error is the error of the last save, if it failed
+	Error string `json:"error,omitempty"`
+}
@@ -10,6 +10,21 @@ type Error struct {
 
 	// Detail is the error's detailed description
 	Detail string `json:"detail,omitempty"`
+
+	// Fields lists the invalid request fields on validation errors
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError describes a problem with a single request field.
+type FieldError struct {
+	// Field is the JSON path of the field, e.g. allowed_ips[1]
+	Field string `json:"field"`
+
+	// Code is the field error code
+	Code string `json:"code"`
+
+	// Message is the field error's short description
+	Message string `json:"message"`
 }
 
 // Common error codes
@@ -20,4 +35,39 @@ const (
 	ErrCodeInvalidRequest     = "invalid_request"
 	ErrCodeInternalError      = "internal_error"
 	ErrCodeUnauthorized       = "unauthorized"
+	ErrCodeTooManyRequests    = "too_many_requests"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeInvalidKey         = "invalid_key"
+	ErrCodeValidationFailed   = "validation_failed"
+	ErrCodeConflict           = "conflict"
+	ErrCodePermissionDenied   = "permission_denied"
+	ErrCodeBackendUnavailable = "backend_unavailable"
+	ErrCodeNotSupported       = "not_supported"
+	ErrCodeConfigNotFound     = "config_not_found"
+	ErrCodePeerExists         = "peer_exists"
+	ErrCodeNotFound           = "not_found"
+	ErrCodePreconditionFailed = "precondition_failed"
+	ErrCodeInvalidBackup      = "invalid_backup"
+	ErrCodeRevisionNotFound   = "revision_not_found"
+	ErrCodeConfigModified     = "config_modified"
+	ErrCodeConfigLocked       = "config_locked"
+	ErrCodeEncryptedKey       = "encrypted_key"
+	ErrCodeTimeout            = "timeout"
+	ErrCodeCanceled           = "canceled"
+
+	ErrCodeIdempotencyKeyReused  = "idempotency_key_reused"
+	ErrCodeIdempotencyInProgress = "idempotency_in_progress"
+)
+
+// Field error codes
+const (
+	FieldCodeRequired        = "required"
+	FieldCodeInvalidName     = "invalid_name"
+	FieldCodeInvalidKey      = "invalid_key"
+	FieldCodeInvalidCIDR     = "invalid_cidr"
+	FieldCodeInvalidDuration = "invalid_duration"
+	FieldCodeInvalidEndpoint = "invalid_endpoint"
+	FieldCodeInvalidValue    = "invalid_value"
+	FieldCodeOutOfRange      = "out_of_range"
+	FieldCodeKeyMismatch     = "key_mismatch"
 )
@@ -0,0 +1,51 @@
+package entity
+
+import "time"
+
+// ConfigRevision is a stored version of a device's config file.
+type ConfigRevision struct {
+	// ID identifies the revision; IDs sort chronologically
+	ID string `json:"id"`
+
+	// CreatedAt is when the config was written
+	CreatedAt time.Time `json:"created_at"`
+
+	// Size is the config size in bytes
+	Size int64 `json:"size"`
+
+	// SHA256 is the hex encoded SHA-256 of the config
+	SHA256 string `json:"sha256"`
+
+	// Current is set if the config file matches the revision
+	Current bool `json:"current"`
+}
+
+// ConfigRevisionDiff is a unified diff between two config revisions.
+type ConfigRevisionDiff struct {
+	// From is the old revision ID
+	From string `json:"from"`
+
+	// To is the new revision ID, empty for the current config file
+	To string `json:"to,omitempty"`
+
+	// Diff is the unified diff, empty if the configs are equal
+	Diff string `json:"diff"`
+}
+
+// ConfigRollbackResult is what rolling a device back to a revision did.
+type ConfigRollbackResult struct {
+	// Revision is the restored revision ID
+	Revision string `json:"revision"`
+
+	// Diff is a unified diff from the current config file to the revision
+	Diff string `json:"diff"`
+
+	// Running is set if the running device was brought to the revision
+	Running bool `json:"running,omitempty"`
+
+	// Changes are the changes made to the running device
+	Changes []SpecChange `json:"changes,omitempty"`
+
+	// DryRun is set if nothing was changed
+	DryRun bool `json:"dry_run,omitempty"`
+}
@@ -1,6 +1,9 @@
 package entity
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 // Peer represents a WireGuard peer.
 type Peer struct {
@@ -10,10 +13,12 @@ type Peer struct {
 	// URLSafePublicKey is the URL-safe base64 encoded public key
 	URLSafePublicKey string `json:"url_safe_public_key"`
 
-	// PrivateKey is the base64 encoded private key (stored separately)
+	// PrivateKey is the base64 encoded private key (stored separately) - only
+	// returned on create or with include_secrets
 	PrivateKey string `json:"private_key,omitempty"`
 
-	// PresharedKey is the base64 encoded preshared key
+	// PresharedKey is the base64 encoded preshared key - only returned on create
+	// or with include_secrets
 	PresharedKey string `json:"preshared_key,omitempty"`
 
 	// AllowedIPs are the peer's allowed IPs in CIDR notation
@@ -43,5 +48,13 @@ type PeerCreateOrUpdateRequest struct {
 	AllowedIPs                  []string `json:"allowed_ips,omitempty"`
 	PersistentKeepaliveInterval *string  `json:"persistent_keepalive_interval,omitempty"`
 	Endpoint                    *string  `json:"endpoint,omitempty"`
+
+	// NullFields lists the fields set to null in a merge patch
+	NullFields []string `json:"-"`
+}
+
+// IsNull reports whether a merge patch set the field to null.
+func (r PeerCreateOrUpdateRequest) IsNull(field string) bool {
+	return slices.Contains(r.NullFields, field)
 }
 
@@ -0,0 +1,142 @@
+package entity
+
+// Spec change actions
+const (
+	SpecChangeAdd    = "add"
+	SpecChangeUpdate = "update"
+	SpecChangeRemove = "remove"
+)
+
+// DeviceSpec is the complete desired state of a device, including all its
+// peers. Omitted secrets keep their current values.
+type DeviceSpec struct {
+	// Name is the device name; it must match the URL if set
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+
+	// ListenPort is the WireGuard listen port; omitted keeps the current port
+	ListenPort int32 `json:"listen_port,omitempty" yaml:"listen_port,omitempty"`
+
+	// PrivateKey is the device private key (base64); omitted keeps the current key
+	PrivateKey string `json:"private_key,omitempty" yaml:"private_key,omitempty"`
+
+	// FirewallMark is the device firewall mark
+	FirewallMark int32 `json:"firewall_mark,omitempty" yaml:"firewall_mark,omitempty"`
+
+	// Addresses are IP addresses to assign to the interface (CIDR notation)
+	Addresses []string `json:"addresses,omitempty" yaml:"addresses,omitempty"`
+
+	// DNS servers to configure when interface is up
+	DNS []string `json:"dns,omitempty" yaml:"dns,omitempty"`
+
+	// MTU for the interface
+	MTU int32 `json:"mtu,omitempty" yaml:"mtu,omitempty"`
+
+	// Table is the routing table (auto, off, or table number)
+	Table string `json:"table,omitempty" yaml:"table,omitempty"`
+
+	// PreUp commands to run before interface comes up
+	PreUp []string `json:"pre_up,omitempty" yaml:"pre_up,omitempty"`
+
+	// PostUp commands to run after interface comes up
+	PostUp []string `json:"post_up,omitempty" yaml:"post_up,omitempty"`
+
+	// PreDown commands to run before interface goes down
+	PreDown []string `json:"pre_down,omitempty" yaml:"pre_down,omitempty"`
+
+	// PostDown commands to run after interface goes down
+	PostDown []string `json:"post_down,omitempty" yaml:"post_down,omitempty"`
+
+	// Peers are all peers of the device; peers not listed are removed
+	Peers []PeerSpec `json:"peers" yaml:"peers"`
+}
+
+// PeerSpec is the desired state of a peer.
+type PeerSpec struct {
+	// PublicKey is the base64 encoded public key
+	PublicKey string `json:"public_key" yaml:"public_key"`
+
+	// PresharedKey is the base64 encoded preshared key; omitted keeps the current key
+	PresharedKey string `json:"preshared_key,omitempty" yaml:"preshared_key,omitempty"`
+
+	// AllowedIPs are the peer's allowed IPs in CIDR notation
+	AllowedIPs []string `json:"allowed_ips,omitempty" yaml:"allowed_ips,omitempty"`
+
+	// Endpoint is the peer's endpoint in host:port format; omitted keeps a roaming endpoint
+	Endpoint string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+
+	// PersistentKeepaliveInterval is the keepalive interval, e.g. 25s (omitted disables it)
+	PersistentKeepaliveInterval string `json:"persistent_keepalive_interval,omitempty" yaml:"persistent_keepalive_interval,omitempty"`
+}
+
+// SpecChange is a single change made to reach a device spec.
+type SpecChange struct {
+	// Action is add, update or remove
+	Action string `json:"action" yaml:"action" enums:"add,update,remove"`
+
+	// Peer is the public key of the changed peer, empty for the device itself
+	Peer string `json:"peer,omitempty" yaml:"peer,omitempty"`
+
+	// Fields lists the changed fields of an updated device or peer
+	Fields []string `json:"fields,omitempty" yaml:"fields,omitempty"`
+}
+
+// DeviceSpecResult lists the changes made by applying a device spec.
+type DeviceSpecResult struct {
+	// Changes are the changes made, empty if the device already matched
+	Changes []SpecChange `json:"changes" yaml:"changes"`
+
+	// DryRun is set if the changes were only computed
+	DryRun bool `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`
+}
+
+// DeviceRequest returns the spec's device settings as a request. Zero values
+// are left out.
+func (s DeviceSpec) DeviceRequest() DeviceCreateOrUpdateRequest {
+	req := DeviceCreateOrUpdateRequest{
+		Addresses: s.Addresses,
+		DNS:       s.DNS,
+		PreUp:     s.PreUp,
+		PostUp:    s.PostUp,
+		PreDown:   s.PreDown,
+		PostDown:  s.PostDown,
+	}
+	if s.Name != "" {
+		req.Name = &s.Name
+	}
+	if s.ListenPort != 0 {
+		req.ListenPort = &s.ListenPort
+	}
+	if s.PrivateKey != "" {
+		req.PrivateKey = &s.PrivateKey
+	}
+	if s.FirewallMark != 0 {
+		req.FirewallMark = &s.FirewallMark
+	}
+	if s.MTU != 0 {
+		req.MTU = &s.MTU
+	}
+	if s.Table != "" {
+		req.Table = &s.Table
+	}
+	return req
+}
+
+// PeerRequest returns the peer spec as a request. Zero values are left out.
+func (p PeerSpec) PeerRequest() PeerCreateOrUpdateRequest {
+	req := PeerCreateOrUpdateRequest{
+		AllowedIPs: p.AllowedIPs,
+	}
+	if p.PublicKey != "" {
+		req.PublicKey = &p.PublicKey
+	}
+	if p.PresharedKey != "" {
+		req.PresharedKey = &p.PresharedKey
+	}
+	if p.Endpoint != "" {
+		req.Endpoint = &p.Endpoint
+	}
+	if p.PersistentKeepaliveInterval != "" {
+		req.PersistentKeepaliveInterval = &p.PersistentKeepaliveInterval
+	}
+	return req
+}
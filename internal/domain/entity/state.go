@@ -0,0 +1,58 @@
+package entity
+
+import "time"
+
+// StateVersion is the version of state documents written by this release.
+const StateVersion = 1
+
+// Device import actions
+const (
+	// StateSynced means a running device was brought to its spec
+	StateSynced = "synced"
+
+	// StateWritten means the config file of a stopped device was written
+	StateWritten = "written"
+
+	// StateStarted means the config file was written and the interface brought up
+	StateStarted = "started"
+)
+
+// State is a versioned export of every device and peer managed by wgrest.
+type State struct {
+	// Version is the document format version
+	Version int `json:"version" yaml:"version"`
+
+	// WgrestVersion is the wgrest version that exported the state
+	WgrestVersion string `json:"wgrest_version,omitempty" yaml:"wgrest_version,omitempty"`
+
+	// ExportedAt is when the state was exported
+	ExportedAt time.Time `json:"exported_at,omitempty" yaml:"exported_at,omitempty"`
+
+	// Devices are all devices with their peers
+	Devices []DeviceState `json:"devices" yaml:"devices"`
+}
+
+// DeviceState is the exported state of a device.
+type DeviceState struct {
+	DeviceSpec `yaml:",inline"`
+
+	// Running is set if the interface was up when exported
+	Running bool `json:"running" yaml:"running"`
+}
+
+// StateImportResult reports what importing a state did to each device.
+type StateImportResult struct {
+	Devices []DeviceImportResult `json:"devices" yaml:"devices"`
+}
+
+// DeviceImportResult reports what importing a state did to a device.
+type DeviceImportResult struct {
+	// Name is the device name
+	Name string `json:"name" yaml:"name"`
+
+	// Action is synced, written or started
+	Action string `json:"action" yaml:"action" enums:"synced,written,started"`
+
+	// Changes are the changes made to a synced device
+	Changes []SpecChange `json:"changes,omitempty" yaml:"changes,omitempty"`
+}
@@ -0,0 +1,136 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+// Error kinds. Use errors.Is to check which kind an error belongs to.
+var (
+	// ErrNotFound means the device, peer or config does not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrAlreadyExists means the resource to create exists already
+	ErrAlreadyExists = errors.New("already exists")
+
+	// ErrValidation means the request contains invalid values
+	ErrValidation = errors.New("validation failed")
+
+	// ErrConflict means the request conflicts with the current state
+	ErrConflict = errors.New("conflict")
+
+	// ErrPermission means the process lacks privileges for the operation
+	ErrPermission = errors.New("permission denied")
+
+	// ErrBackendUnavailable means WireGuard or wg-quick could not be reached
+	ErrBackendUnavailable = errors.New("backend unavailable")
+
+	// ErrPreconditionFailed means the resource changed since the client read it
+	ErrPreconditionFailed = errors.New("precondition failed")
+
+	// ErrNotSupported means the operation is not supported on this platform
+	ErrNotSupported = errors.New("not supported")
+)
+
+// Error is a domain error of a given kind with a stable code for API clients.
+type Error struct {
+	// Kind is one of the Err* sentinels
+	Kind error
+
+	// Code is the stable error code (see entity.ErrCode*)
+	Code string
+
+	// Message is the human readable description
+	Message string
+
+	// Err is the underlying cause, if any
+	Err error
+
+	// Fields lists the invalid fields of a validation error
+	Fields []entity.FieldError
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the kind and the cause so errors.Is matches both.
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// NewError creates a domain error. The message is formatted with fmt.Errorf,
+// so a %w verb records the cause.
+func NewError(kind error, code string, format string, args ...any) *Error {
+	err := fmt.Errorf(format, args...)
+	return &Error{
+		Kind:    kind,
+		Code:    code,
+		Message: err.Error(),
+		Err:     errors.Unwrap(err),
+	}
+}
+
+// NotFound creates an ErrNotFound error.
+func NotFound(code string, format string, args ...any) *Error {
+	return NewError(ErrNotFound, code, format, args...)
+}
+
+// AlreadyExists creates an ErrAlreadyExists error.
+func AlreadyExists(code string, format string, args ...any) *Error {
+	return NewError(ErrAlreadyExists, code, format, args...)
+}
+
+// Validation creates an ErrValidation error.
+func Validation(code string, format string, args ...any) *Error {
+	return NewError(ErrValidation, code, format, args...)
+}
+
+// Conflict creates an ErrConflict error.
+func Conflict(code string, format string, args ...any) *Error {
+	return NewError(ErrConflict, code, format, args...)
+}
+
+// Permission creates an ErrPermission error.
+func Permission(code string, format string, args ...any) *Error {
+	return NewError(ErrPermission, code, format, args...)
+}
+
+// BackendUnavailable creates an ErrBackendUnavailable error.
+func BackendUnavailable(code string, format string, args ...any) *Error {
+	return NewError(ErrBackendUnavailable, code, format, args...)
+}
+
+// PreconditionFailed creates an ErrPreconditionFailed error.
+func PreconditionFailed(code string, format string, args ...any) *Error {
+	return NewError(ErrPreconditionFailed, code, format, args...)
+}
+
+// NotSupported creates an ErrNotSupported error.
+func NotSupported(code string, format string, args ...any) *Error {
+	return NewError(ErrNotSupported, code, format, args...)
+}
+
+// Fields returns the field errors of a domain error, or nil for other errors.
+func Fields(err error) []entity.FieldError {
+	var de *Error
+	if errors.As(err, &de) {
+		return de.Fields
+	}
+	return nil
+}
+
+// Code returns the stable error code of a domain error, or "" for other errors.
+func Code(err error) string {
+	var de *Error
+	if errors.As(err, &de) {
+		return de.Code
+	}
+	return ""
+}
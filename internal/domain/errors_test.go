@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestError_Is(t *testing.T) {
+	err := NotFound("device_not_found", "device %s not found: %w", "wg0", os.ErrNotExist)
+
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.False(t, errors.Is(err, ErrValidation))
+	assert.Equal(t, "device wg0 not found: file does not exist", err.Error())
+	assert.Equal(t, os.ErrNotExist, err.Err)
+}
+
+func TestError_WithoutCause(t *testing.T) {
+	err := Validation("validation_failed", "device name is required")
+
+	assert.True(t, errors.Is(err, ErrValidation))
+	assert.Nil(t, err.Err)
+	assert.Equal(t, "device name is required", err.Error())
+}
+
+func TestCode(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", Conflict("conflict", "busy"))
+
+	assert.Equal(t, "conflict", Code(err))
+	assert.Equal(t, "", Code(errors.New("plain")))
+}
@@ -0,0 +1,364 @@
+package domain
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+const (
+	// minMTU is the smallest MTU accepted for an interface (IPv4 minimum)
+	minMTU = 576
+
+	// maxMTU is the largest MTU accepted for an interface
+	maxMTU = 65535
+
+	// maxKeepalive is the largest persistent keepalive interval WireGuard supports
+	maxKeepalive = 65535 * time.Second
+
+	// MaxPeerBatchOperations is the largest number of operations in a peer batch
+	MaxPeerBatchOperations = 10000
+)
+
+var (
+	// interfaceNameRe matches the interface names accepted by wg-quick
+	interfaceNameRe = regexp.MustCompile(`^[a-zA-Z0-9_=+.-]{1,15}$`)
+
+	// tableNameRe matches routing table names from rt_tables
+	tableNameRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+)
+
+// FieldErrors collects field validation problems.
+type FieldErrors []entity.FieldError
+
+// Add records a problem with a field.
+func (f *FieldErrors) Add(field, code, format string, args ...any) {
+	*f = append(*f, entity.FieldError{
+		Field:   field,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Err returns an ErrValidation error listing all problems, or nil if there are none.
+func (f FieldErrors) Err() error {
+	if len(f) == 0 {
+		return nil
+	}
+	msg := f[0].Field + ": " + f[0].Message
+	if len(f) > 1 {
+		msg = fmt.Sprintf("%s (and %d more)", msg, len(f)-1)
+	}
+	err := Validation(entity.ErrCodeValidationFailed, "validation failed: %s", msg)
+	err.Fields = f
+	return err
+}
+
+// ValidateDeviceRequest checks a device request before it reaches WireGuard.
+// The name is required when create is true.
+func ValidateDeviceRequest(req entity.DeviceCreateOrUpdateRequest, create bool) error {
+	var errs FieldErrors
+
+	if req.Name == nil || *req.Name == "" {
+		if create {
+			errs.Add("name", entity.FieldCodeRequired, "device name is required")
+		}
+	} else {
+		validateName(&errs, "name", *req.Name)
+	}
+
+	if req.ListenPort != nil && (*req.ListenPort < 0 || *req.ListenPort > 65535) {
+		errs.Add("listen_port", entity.FieldCodeOutOfRange, "listen port must be between 0 and 65535")
+	}
+	if req.PrivateKey != nil {
+		validateKey(&errs, "private_key", *req.PrivateKey)
+	}
+	if req.FirewallMark != nil && *req.FirewallMark < 0 {
+		errs.Add("firewall_mark", entity.FieldCodeOutOfRange, "firewall mark must not be negative")
+	}
+
+	for i, addr := range req.Addresses {
+		field := fmt.Sprintf("addresses[%d]", i)
+		if _, err := netip.ParsePrefix(addr); err != nil {
+			if _, err := netip.ParseAddr(addr); err != nil {
+				errs.Add(field, entity.FieldCodeInvalidCIDR, "%q is not an IP address or CIDR", addr)
+			}
+		}
+	}
+	for i, dns := range req.DNS {
+		validateLine(&errs, fmt.Sprintf("dns[%d]", i), dns, false)
+	}
+
+	if req.MTU != nil && *req.MTU != 0 && (*req.MTU < minMTU || *req.MTU > maxMTU) {
+		errs.Add("mtu", entity.FieldCodeOutOfRange, "mtu must be 0 (auto) or between %d and %d", minMTU, maxMTU)
+	}
+	if req.Table != nil {
+		validateTable(&errs, "table", *req.Table)
+	}
+
+	validateCommands(&errs, "pre_up", req.PreUp)
+	validateCommands(&errs, "post_up", req.PostUp)
+	validateCommands(&errs, "pre_down", req.PreDown)
+	validateCommands(&errs, "post_down", req.PostDown)
+
+	validateNulls(&errs, req.NullFields, "name", "private_key")
+
+	return errs.Err()
+}
+
+// ValidatePeerRequest checks a peer request before it reaches WireGuard.
+func ValidatePeerRequest(req entity.PeerCreateOrUpdateRequest) error {
+	var errs FieldErrors
+
+	var publicKey, privateKey *wgtypes.Key
+	if req.PublicKey != nil {
+		publicKey = validateKey(&errs, "public_key", *req.PublicKey)
+	}
+	if req.PrivateKey != nil {
+		privateKey = validateKey(&errs, "private_key", *req.PrivateKey)
+	}
+	if publicKey != nil && privateKey != nil && privateKey.PublicKey() != *publicKey {
+		errs.Add("public_key", entity.FieldCodeKeyMismatch, "public key does not match private key")
+	}
+	if req.PresharedKey != nil {
+		validateKey(&errs, "preshared_key", *req.PresharedKey)
+	}
+
+	for i, ip := range req.AllowedIPs {
+		if _, _, err := net.ParseCIDR(ip); err != nil {
+			errs.Add(fmt.Sprintf("allowed_ips[%d]", i), entity.FieldCodeInvalidCIDR, "%q is not a CIDR", ip)
+		}
+	}
+
+	if req.PersistentKeepaliveInterval != nil {
+		d, err := time.ParseDuration(*req.PersistentKeepaliveInterval)
+		switch {
+		case err != nil:
+			errs.Add("persistent_keepalive_interval", entity.FieldCodeInvalidDuration,
+				"%q is not a duration such as 25s", *req.PersistentKeepaliveInterval)
+		case d < 0 || d > maxKeepalive:
+			errs.Add("persistent_keepalive_interval", entity.FieldCodeOutOfRange,
+				"keepalive interval must be between 0s and %s", maxKeepalive)
+		}
+	}
+
+	if req.Endpoint != nil && *req.Endpoint != "" {
+		validateEndpoint(&errs, "endpoint", *req.Endpoint)
+	}
+
+	validateNulls(&errs, req.NullFields, "public_key", "private_key")
+
+	return errs.Err()
+}
+
+// ValidatePeerBatchRequest checks the mode and size of a peer batch. The
+// operations are checked one by one with ValidatePeerBatchOperation.
+func ValidatePeerBatchRequest(req entity.PeerBatchRequest) error {
+	var errs FieldErrors
+
+	if req.Mode != "" && req.Mode != entity.PeerBatchAtomic && req.Mode != entity.PeerBatchPerItem {
+		errs.Add("mode", entity.FieldCodeInvalidValue, "mode must be %s or %s", entity.PeerBatchAtomic, entity.PeerBatchPerItem)
+	}
+
+	switch {
+	case len(req.Operations) == 0:
+		errs.Add("operations", entity.FieldCodeRequired, "at least one operation is required")
+	case len(req.Operations) > MaxPeerBatchOperations:
+		errs.Add("operations", entity.FieldCodeOutOfRange, "at most %d operations are allowed", MaxPeerBatchOperations)
+	}
+
+	return errs.Err()
+}
+
+// ValidatePeerBatchOperation checks a single operation of a peer batch.
+// Fields of the peer request are reported as peer.<field>.
+func ValidatePeerBatchOperation(op entity.PeerBatchOperation) error {
+	var errs FieldErrors
+
+	switch op.Op {
+	case entity.PeerBatchCreate:
+	case entity.PeerBatchUpdate, entity.PeerBatchDelete:
+		if op.PublicKey == "" {
+			errs.Add("public_key", entity.FieldCodeRequired, "public key is required to %s a peer", op.Op)
+		}
+	default:
+		errs.Add("op", entity.FieldCodeInvalidValue, "op must be create, update or delete")
+	}
+
+	if op.Op == entity.PeerBatchCreate || op.Op == entity.PeerBatchUpdate {
+		errs.addPrefixed("peer.", ValidatePeerRequest(op.Peer))
+	}
+
+	return errs.Err()
+}
+
+// ValidateDeviceSpec checks a complete device spec for the device name.
+// Fields of peers are reported as peers[i].<field>.
+func ValidateDeviceSpec(name string, spec entity.DeviceSpec) error {
+	var errs FieldErrors
+
+	if spec.Name != "" && spec.Name != name {
+		errs.Add("name", entity.FieldCodeInvalidValue, "name must match the device %s", name)
+	}
+	spec.Name = ""
+	errs.addPrefixed("", ValidateDeviceRequest(spec.DeviceRequest(), false))
+
+	seen := make(map[wgtypes.Key]int, len(spec.Peers))
+	for i, peer := range spec.Peers {
+		prefix := fmt.Sprintf("peers[%d].", i)
+		if peer.PublicKey == "" {
+			errs.Add(prefix+"public_key", entity.FieldCodeRequired, "public key is required")
+		} else if key, err := wgtypes.ParseKey(peer.PublicKey); err == nil {
+			if first, ok := seen[key]; ok {
+				errs.Add(prefix+"public_key", entity.FieldCodeInvalidValue, "peer is already listed as peers[%d]", first)
+			}
+			seen[key] = i
+		}
+		errs.addPrefixed(prefix, ValidatePeerRequest(peer.PeerRequest()))
+	}
+
+	return errs.Err()
+}
+
+// ValidateState checks a state document before it is imported. Every device
+// needs a name and a private key. Fields of devices are reported as
+// devices[i].<field>.
+func ValidateState(state entity.State) error {
+	var errs FieldErrors
+
+	if state.Version < 1 || state.Version > entity.StateVersion {
+		errs.Add("version", entity.FieldCodeInvalidValue, "unsupported state version %d", state.Version)
+	}
+
+	seen := make(map[string]int, len(state.Devices))
+	for i, device := range state.Devices {
+		prefix := fmt.Sprintf("devices[%d].", i)
+		if device.Name == "" {
+			errs.Add(prefix+"name", entity.FieldCodeRequired, "device name is required")
+		} else {
+			validateName(&errs, prefix+"name", device.Name)
+			if first, ok := seen[device.Name]; ok {
+				errs.Add(prefix+"name", entity.FieldCodeInvalidValue, "device is already listed as devices[%d]", first)
+			}
+			seen[device.Name] = i
+		}
+		if device.PrivateKey == "" {
+			errs.Add(prefix+"private_key", entity.FieldCodeRequired, "private key is required")
+		}
+		errs.addPrefixed(prefix, ValidateDeviceSpec(device.Name, device.DeviceSpec))
+	}
+
+	return errs.Err()
+}
+
+// ValidateBackup checks a backup manifest and the device specs read from its
+// config files, which are in manifest order.
+func ValidateBackup(manifest entity.BackupManifest, specs []entity.DeviceSpec) error {
+	var errs FieldErrors
+
+	if manifest.Version < 1 || manifest.Version > entity.BackupVersion {
+		errs.Add("version", entity.FieldCodeInvalidValue, "unsupported backup version %d", manifest.Version)
+	}
+
+	seen := make(map[string]int, len(manifest.Files))
+	for i, f := range manifest.Files {
+		prefix := fmt.Sprintf("files[%d].", i)
+		validateName(&errs, prefix+"device", f.Device)
+		if f.Dir == "" {
+			errs.Add(prefix+"dir", entity.FieldCodeRequired, "config directory is required")
+		}
+		key := f.Dir + "/" + f.Device
+		if first, ok := seen[key]; ok {
+			errs.Add(prefix+"device", entity.FieldCodeInvalidValue, "config file is already listed as files[%d]", first)
+		}
+		seen[key] = i
+		if i < len(specs) {
+			errs.addPrefixed(prefix, ValidateDeviceSpec(f.Device, specs[i]))
+		}
+	}
+
+	return errs.Err()
+}
+
+// addPrefixed adds the field errors of err with prefix prepended to their fields.
+func (f *FieldErrors) addPrefixed(prefix string, err error) {
+	for _, fe := range Fields(err) {
+		fe.Field = prefix + fe.Field
+		*f = append(*f, fe)
+	}
+}
+
+// validateNulls rejects merge patch nulls for fields that can't be cleared.
+func validateNulls(errs *FieldErrors, nulls []string, required ...string) {
+	for _, field := range nulls {
+		if slices.Contains(required, field) {
+			errs.Add(field, entity.FieldCodeRequired, "%s cannot be cleared", field)
+		}
+	}
+}
+
+func validateName(errs *FieldErrors, field, name string) {
+	if !interfaceNameRe.MatchString(name) {
+		errs.Add(field, entity.FieldCodeInvalidName,
+			"interface name must be 1-15 characters of letters, digits and _=+.-")
+	}
+}
+
+func validateKey(errs *FieldErrors, field, value string) *wgtypes.Key {
+	key, err := wgtypes.ParseKey(value)
+	if err != nil {
+		errs.Add(field, entity.FieldCodeInvalidKey, "key must be 32 bytes of base64")
+		return nil
+	}
+	return &key
+}
+
+func validateTable(errs *FieldErrors, field, table string) {
+	if table == "auto" || table == "off" {
+		return
+	}
+	if _, err := strconv.ParseUint(table, 10, 32); err == nil {
+		return
+	}
+	if !tableNameRe.MatchString(table) {
+		errs.Add(field, entity.FieldCodeInvalidValue, "table must be auto, off, a table number or a table name")
+	}
+}
+
+// validateLine rejects values that would break the wg-quick config format.
+func validateLine(errs *FieldErrors, field, value string, allowSpaces bool) {
+	switch {
+	case strings.TrimSpace(value) == "":
+		errs.Add(field, entity.FieldCodeRequired, "value must not be empty")
+	case strings.ContainsAny(value, "\r\n"):
+		errs.Add(field, entity.FieldCodeInvalidValue, "value must not contain line breaks")
+	case !allowSpaces && strings.ContainsAny(value, " \t,"):
+		errs.Add(field, entity.FieldCodeInvalidValue, "value must not contain spaces or commas")
+	}
+}
+
+func validateCommands(errs *FieldErrors, field string, cmds []string) {
+	for i, cmd := range cmds {
+		validateLine(errs, fmt.Sprintf("%s[%d]", field, i), cmd, true)
+	}
+}
+
+func validateEndpoint(errs *FieldErrors, field, endpoint string) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil || host == "" {
+		errs.Add(field, entity.FieldCodeInvalidEndpoint, "endpoint must be in host:port format")
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		errs.Add(field, entity.FieldCodeInvalidEndpoint, "endpoint port must be between 1 and 65535")
+	}
+}
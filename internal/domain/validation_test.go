@@ -0,0 +1,288 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func fieldCodes(t *testing.T, err error) map[string]string {
+	t.Helper()
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrValidation))
+	assert.Equal(t, entity.ErrCodeValidationFailed, Code(err))
+
+	codes := make(map[string]string)
+	for _, f := range Fields(err) {
+		codes[f.Field] = f.Code
+	}
+	return codes
+}
+
+func TestValidateDeviceRequest_Valid(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	req := entity.DeviceCreateOrUpdateRequest{
+		Name:         ptr("wg0"),
+		ListenPort:   ptr(int32(51820)),
+		PrivateKey:   ptr(key.String()),
+		FirewallMark: ptr(int32(0x51820)),
+		Addresses:    []string{"10.0.0.1/24", "fd00::1/64", "10.0.1.1"},
+		DNS:          []string{"1.1.1.1", "example.internal"},
+		MTU:          ptr(int32(1420)),
+		Table:        ptr("off"),
+		PostUp:       []string{"iptables -A FORWARD -i %i -j ACCEPT"},
+	}
+
+	assert.NoError(t, ValidateDeviceRequest(req, true))
+}
+
+func TestValidateDeviceRequest_CollectsAllErrors(t *testing.T) {
+	req := entity.DeviceCreateOrUpdateRequest{
+		Name:         ptr("wg0; rm -rf /"),
+		ListenPort:   ptr(int32(70000)),
+		PrivateKey:   ptr("not-a-key"),
+		FirewallMark: ptr(int32(-1)),
+		Addresses:    []string{"10.0.0.1/24", "10.0.0.300/24"},
+		DNS:          []string{"1.1.1.1\nPostUp = evil"},
+		MTU:          ptr(int32(-5)),
+		Table:        ptr("main table"),
+		PreUp:        []string{"echo ok", ""},
+	}
+
+	codes := fieldCodes(t, ValidateDeviceRequest(req, false))
+	assert.Equal(t, map[string]string{
+		"name":          entity.FieldCodeInvalidName,
+		"listen_port":   entity.FieldCodeOutOfRange,
+		"private_key":   entity.FieldCodeInvalidKey,
+		"firewall_mark": entity.FieldCodeOutOfRange,
+		"addresses[1]":  entity.FieldCodeInvalidCIDR,
+		"dns[0]":        entity.FieldCodeInvalidValue,
+		"mtu":           entity.FieldCodeOutOfRange,
+		"table":         entity.FieldCodeInvalidValue,
+		"pre_up[1]":     entity.FieldCodeRequired,
+	}, codes)
+}
+
+func TestValidateDeviceRequest_NameRequiredOnCreate(t *testing.T) {
+	codes := fieldCodes(t, ValidateDeviceRequest(entity.DeviceCreateOrUpdateRequest{}, true))
+	assert.Equal(t, entity.FieldCodeRequired, codes["name"])
+
+	assert.NoError(t, ValidateDeviceRequest(entity.DeviceCreateOrUpdateRequest{}, false))
+}
+
+func TestValidateDeviceRequest_NameTooLong(t *testing.T) {
+	req := entity.DeviceCreateOrUpdateRequest{Name: ptr("wireguard-tunnel0")}
+
+	codes := fieldCodes(t, ValidateDeviceRequest(req, true))
+	assert.Equal(t, entity.FieldCodeInvalidName, codes["name"])
+}
+
+func TestValidatePeerRequest_Valid(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	psk, err := wgtypes.GenerateKey()
+	require.NoError(t, err)
+
+	req := entity.PeerCreateOrUpdateRequest{
+		PrivateKey:                  ptr(key.String()),
+		PublicKey:                   ptr(key.PublicKey().String()),
+		PresharedKey:                ptr(psk.String()),
+		AllowedIPs:                  []string{"10.0.0.2/32", "fd00::2/128"},
+		PersistentKeepaliveInterval: ptr("25s"),
+		Endpoint:                    ptr("vpn.example.com:51820"),
+	}
+
+	assert.NoError(t, ValidatePeerRequest(req))
+}
+
+func TestValidatePeerRequest_CollectsAllErrors(t *testing.T) {
+	req := entity.PeerCreateOrUpdateRequest{
+		PublicKey:                   ptr("short"),
+		PresharedKey:                ptr("also-short"),
+		AllowedIPs:                  []string{"10.0.0.2/32", "10.0.0.3", "bogus"},
+		PersistentKeepaliveInterval: ptr("25"),
+		Endpoint:                    ptr("vpn.example.com"),
+	}
+
+	err := ValidatePeerRequest(req)
+	codes := fieldCodes(t, err)
+	assert.Equal(t, map[string]string{
+		"public_key":                    entity.FieldCodeInvalidKey,
+		"preshared_key":                 entity.FieldCodeInvalidKey,
+		"allowed_ips[1]":                entity.FieldCodeInvalidCIDR,
+		"allowed_ips[2]":                entity.FieldCodeInvalidCIDR,
+		"persistent_keepalive_interval": entity.FieldCodeInvalidDuration,
+		"endpoint":                      entity.FieldCodeInvalidEndpoint,
+	}, codes)
+	assert.Contains(t, err.Error(), "(and 5 more)")
+}
+
+func TestValidatePeerRequest_Ranges(t *testing.T) {
+	req := entity.PeerCreateOrUpdateRequest{
+		PersistentKeepaliveInterval: ptr("-1s"),
+		Endpoint:                    ptr("10.0.0.1:0"),
+	}
+
+	codes := fieldCodes(t, ValidatePeerRequest(req))
+	assert.Equal(t, entity.FieldCodeOutOfRange, codes["persistent_keepalive_interval"])
+	assert.Equal(t, entity.FieldCodeInvalidEndpoint, codes["endpoint"])
+}
+
+func TestValidatePeerRequest_KeyMismatch(t *testing.T) {
+	key1, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	key2, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	req := entity.PeerCreateOrUpdateRequest{
+		PrivateKey: ptr(key1.String()),
+		PublicKey:  ptr(key2.PublicKey().String()),
+	}
+
+	codes := fieldCodes(t, ValidatePeerRequest(req))
+	assert.Equal(t, entity.FieldCodeKeyMismatch, codes["public_key"])
+}
+
+func TestValidate_NullRequiredFields(t *testing.T) {
+	codes := fieldCodes(t, ValidateDeviceRequest(entity.DeviceCreateOrUpdateRequest{
+		NullFields: []string{"dns", "private_key"},
+	}, false))
+	assert.Equal(t, map[string]string{"private_key": entity.FieldCodeRequired}, codes)
+
+	codes = fieldCodes(t, ValidatePeerRequest(entity.PeerCreateOrUpdateRequest{
+		NullFields: []string{"endpoint", "public_key"},
+	}))
+	assert.Equal(t, map[string]string{"public_key": entity.FieldCodeRequired}, codes)
+}
+
+func TestValidatePeerBatchRequest(t *testing.T) {
+	codes := fieldCodes(t, ValidatePeerBatchRequest(entity.PeerBatchRequest{Mode: "sometimes"}))
+	assert.Equal(t, map[string]string{
+		"mode":       entity.FieldCodeInvalidValue,
+		"operations": entity.FieldCodeRequired,
+	}, codes)
+
+	codes = fieldCodes(t, ValidatePeerBatchRequest(entity.PeerBatchRequest{
+		Operations: make([]entity.PeerBatchOperation, MaxPeerBatchOperations+1),
+	}))
+	assert.Equal(t, map[string]string{"operations": entity.FieldCodeOutOfRange}, codes)
+
+	assert.NoError(t, ValidatePeerBatchRequest(entity.PeerBatchRequest{
+		Mode:       entity.PeerBatchPerItem,
+		Operations: []entity.PeerBatchOperation{{Op: entity.PeerBatchCreate}},
+	}))
+}
+
+func TestValidatePeerBatchOperation(t *testing.T) {
+	codes := fieldCodes(t, ValidatePeerBatchOperation(entity.PeerBatchOperation{Op: "replace"}))
+	assert.Equal(t, map[string]string{"op": entity.FieldCodeInvalidValue}, codes)
+
+	codes = fieldCodes(t, ValidatePeerBatchOperation(entity.PeerBatchOperation{Op: entity.PeerBatchDelete}))
+	assert.Equal(t, map[string]string{"public_key": entity.FieldCodeRequired}, codes)
+
+	codes = fieldCodes(t, ValidatePeerBatchOperation(entity.PeerBatchOperation{
+		Op:        entity.PeerBatchUpdate,
+		PublicKey: "key",
+		Peer:      entity.PeerCreateOrUpdateRequest{AllowedIPs: []string{"10.0.0.2"}},
+	}))
+	assert.Equal(t, map[string]string{"peer.allowed_ips[0]": entity.FieldCodeInvalidCIDR}, codes)
+
+	assert.NoError(t, ValidatePeerBatchOperation(entity.PeerBatchOperation{
+		Op:   entity.PeerBatchCreate,
+		Peer: entity.PeerCreateOrUpdateRequest{AllowedIPs: []string{"10.0.0.2/32"}},
+	}))
+}
+
+func TestValidateDeviceSpec(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	peer := key.PublicKey().String()
+
+	spec := entity.DeviceSpec{
+		Name: "wg1",
+		MTU:  100,
+		Peers: []entity.PeerSpec{
+			{PublicKey: peer, AllowedIPs: []string{"10.0.0.2/32"}},
+			{AllowedIPs: []string{"10.0.0.3"}},
+			{PublicKey: peer},
+		},
+	}
+
+	codes := fieldCodes(t, ValidateDeviceSpec("wg0", spec))
+	assert.Equal(t, map[string]string{
+		"name":                    entity.FieldCodeInvalidValue,
+		"mtu":                     entity.FieldCodeOutOfRange,
+		"peers[1].public_key":     entity.FieldCodeRequired,
+		"peers[1].allowed_ips[0]": entity.FieldCodeInvalidCIDR,
+		"peers[2].public_key":     entity.FieldCodeInvalidValue,
+	}, codes)
+
+	assert.NoError(t, ValidateDeviceSpec("wg0", entity.DeviceSpec{
+		Peers: []entity.PeerSpec{{PublicKey: peer, PersistentKeepaliveInterval: "25s"}},
+	}))
+}
+
+func TestValidateState(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	state := entity.State{
+		Version: 2,
+		Devices: []entity.DeviceState{
+			{DeviceSpec: entity.DeviceSpec{Name: "wg0", PrivateKey: key.String()}},
+			{DeviceSpec: entity.DeviceSpec{Name: "wg0", PrivateKey: key.String()}},
+			{DeviceSpec: entity.DeviceSpec{
+				Peers: []entity.PeerSpec{{AllowedIPs: []string{"10.0.0.2/32"}}},
+			}},
+		},
+	}
+
+	codes := fieldCodes(t, ValidateState(state))
+	assert.Equal(t, map[string]string{
+		"version":                        entity.FieldCodeInvalidValue,
+		"devices[1].name":                entity.FieldCodeInvalidValue,
+		"devices[2].name":                entity.FieldCodeRequired,
+		"devices[2].private_key":         entity.FieldCodeRequired,
+		"devices[2].peers[0].public_key": entity.FieldCodeRequired,
+	}, codes)
+
+	state.Version = entity.StateVersion
+	state.Devices = state.Devices[:1]
+	assert.NoError(t, ValidateState(state))
+}
+
+func TestValidateBackup(t *testing.T) {
+	manifest := entity.BackupManifest{
+		Version: 2,
+		Files: []entity.BackupFile{
+			{Device: "wg0", Dir: "/etc/wireguard"},
+			{Device: "wg0", Dir: "/etc/wireguard"},
+			{Device: "wg/0"},
+		},
+	}
+	specs := []entity.DeviceSpec{{}, {}, {MTU: 100}}
+
+	codes := fieldCodes(t, ValidateBackup(manifest, specs))
+	assert.Equal(t, map[string]string{
+		"version":         entity.FieldCodeInvalidValue,
+		"files[1].device": entity.FieldCodeInvalidValue,
+		"files[2].device": entity.FieldCodeInvalidName,
+		"files[2].dir":    entity.FieldCodeRequired,
+		"files[2].mtu":    entity.FieldCodeOutOfRange,
+	}, codes)
+
+	manifest.Version = entity.BackupVersion
+	manifest.Files = manifest.Files[:1]
+	assert.NoError(t, ValidateBackup(manifest, specs[:1]))
+}
@@ -0,0 +1,152 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Config contains ACME certificate manager settings.
+type Config struct {
+	// Domains are the host names certificates may be issued for
+	Domains []string
+
+	// CacheDir is the directory where account keys and certificates are stored
+	CacheDir string
+
+	// DirectoryURL is the ACME directory endpoint (Let's Encrypt production if empty)
+	DirectoryURL string
+
+	// DirectoryCAFile is a PEM bundle used to verify the ACME server's TLS
+	// certificate (e.g. the Pebble or internal CA root)
+	DirectoryCAFile string
+
+	// Email is the optional contact address registered with the ACME account
+	Email string
+
+	// EABKeyID is the External Account Binding key identifier
+	EABKeyID string
+
+	// EABHMACKey is the base64url encoded External Account Binding MAC key
+	EABHMACKey string
+}
+
+// NewManager creates an autocert manager from the given configuration.
+func NewManager(cfg Config) (*autocert.Manager, error) {
+	if len(cfg.Domains) == 0 {
+		return nil, fmt.Errorf("at least one TLS domain must be specified")
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.Domains...),
+		Cache:      autocert.DirCache(cfg.CacheDir),
+		Email:      cfg.Email,
+	}
+
+	if cfg.DirectoryURL != "" || cfg.DirectoryCAFile != "" {
+		client := &acme.Client{DirectoryURL: cfg.DirectoryURL}
+		if client.DirectoryURL == "" {
+			client.DirectoryURL = autocert.DefaultACMEDirectory
+		}
+
+		if cfg.DirectoryCAFile != "" {
+			httpClient, err := newHTTPClient(cfg.DirectoryCAFile)
+			if err != nil {
+				return nil, err
+			}
+			client.HTTPClient = httpClient
+		}
+
+		m.Client = client
+	}
+
+	if cfg.EABKeyID != "" || cfg.EABHMACKey != "" {
+		eab, err := ParseExternalAccountBinding(cfg.EABKeyID, cfg.EABHMACKey)
+		if err != nil {
+			return nil, err
+		}
+		m.ExternalAccountBinding = eab
+	}
+
+	return m, nil
+}
+
+// ParseExternalAccountBinding builds EAB credentials from a key ID and a
+// base64url encoded HMAC key, as issued by CAs such as ZeroSSL or step-ca.
+func ParseExternalAccountBinding(keyID, hmacKey string) (*acme.ExternalAccountBinding, error) {
+	if keyID == "" || hmacKey == "" {
+		return nil, fmt.Errorf("both EAB key ID and HMAC key must be specified")
+	}
+
+	// CAs hand out the key with or without padding
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(hmacKey, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid EAB HMAC key: %w", err)
+	}
+
+	return &acme.ExternalAccountBinding{KID: keyID, Key: key}, nil
+}
+
+// newHTTPClient returns an HTTP client trusting the CA certificates in caFile
+// in addition to the system roots.
+func newHTTPClient(caFile string) (*http.Client, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACME directory CA file: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// ChallengeServer serves ACME HTTP-01 challenges and redirects all other
+// plain HTTP requests to HTTPS.
+type ChallengeServer struct {
+	server *http.Server
+}
+
+// NewChallengeServer creates an HTTP-01 challenge server listening on addr.
+func NewChallengeServer(addr string, m *autocert.Manager) *ChallengeServer {
+	return &ChallengeServer{
+		server: &http.Server{
+			Addr:              addr,
+			Handler:           m.HTTPHandler(nil),
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// Start runs the challenge server until it is shut down.
+func (s *ChallengeServer) Start() {
+	log.Printf("Starting ACME HTTP-01 challenge server on %s", s.server.Addr)
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("ACME HTTP-01 challenge server failed: %v", err)
+	}
+}
+
+// Shutdown gracefully stops the challenge server.
+func (s *ChallengeServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
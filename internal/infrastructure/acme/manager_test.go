@@ -0,0 +1,133 @@
+package acme
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestNewManager_Defaults(t *testing.T) {
+	m, err := NewManager(Config{
+		Domains:  []string{"vpn.example.com"},
+		CacheDir: t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	// Let's Encrypt production is used when no client is configured
+	assert.Nil(t, m.Client)
+	assert.Nil(t, m.ExternalAccountBinding)
+}
+
+func TestNewManager_NoDomains(t *testing.T) {
+	_, err := NewManager(Config{CacheDir: t.TempDir()})
+	assert.Error(t, err)
+}
+
+func TestNewManager_CustomDirectory(t *testing.T) {
+	m, err := NewManager(Config{
+		Domains:      []string{"vpn.example.com"},
+		CacheDir:     t.TempDir(),
+		DirectoryURL: "https://localhost:14000/dir",
+		EABKeyID:     "kid-1",
+		EABHMACKey:   "c2VjcmV0LWtleQ",
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, m.Client)
+	assert.Equal(t, "https://localhost:14000/dir", m.Client.DirectoryURL)
+	require.NotNil(t, m.ExternalAccountBinding)
+	assert.Equal(t, "kid-1", m.ExternalAccountBinding.KID)
+	assert.Equal(t, []byte("secret-key"), m.ExternalAccountBinding.Key)
+}
+
+func TestNewManager_DirectoryCAFile(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, certToPEM(srv.Certificate().Raw), 0600))
+
+	m, err := NewManager(Config{
+		Domains:         []string{"vpn.example.com"},
+		CacheDir:        t.TempDir(),
+		DirectoryCAFile: caFile,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, m.Client)
+	assert.Equal(t, autocert.DefaultACMEDirectory, m.Client.DirectoryURL)
+
+	// The configured client must trust the test server's certificate
+	resp, err := m.Client.HTTPClient.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestNewManager_InvalidCAFile(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, []byte("not a certificate"), 0600))
+
+	_, err := NewManager(Config{
+		Domains:         []string{"vpn.example.com"},
+		CacheDir:        t.TempDir(),
+		DirectoryCAFile: caFile,
+	})
+	assert.Error(t, err)
+}
+
+func TestParseExternalAccountBinding(t *testing.T) {
+	testCases := []struct {
+		name    string
+		kid     string
+		key     string
+		want    *acme.ExternalAccountBinding
+		wantErr bool
+	}{
+		{"unpadded", "kid", "c2VjcmV0LWtleQ", &acme.ExternalAccountBinding{KID: "kid", Key: []byte("secret-key")}, false},
+		{"padded", "kid", "c2VjcmV0LWtleQ==", &acme.ExternalAccountBinding{KID: "kid", Key: []byte("secret-key")}, false},
+		{"missing kid", "", "c2VjcmV0LWtleQ", nil, true},
+		{"missing key", "kid", "", nil, true},
+		{"invalid key", "kid", "not base64!", nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			eab, err := ParseExternalAccountBinding(tc.kid, tc.key)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, eab)
+		})
+	}
+}
+
+func TestChallengeServer_RedirectsToHTTPS(t *testing.T) {
+	m, err := NewManager(Config{
+		Domains:  []string{"vpn.example.com"},
+		CacheDir: t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	s := NewChallengeServer(":0", m)
+
+	req := httptest.NewRequest(http.MethodGet, "http://vpn.example.com/v1/devices/", nil)
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "https://vpn.example.com/v1/devices/", rec.Header().Get("Location"))
+}
+
+func certToPEM(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -0,0 +1,288 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"filippo.io/age"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+const (
+	// manifestPath is the archive path of the manifest
+	manifestPath = "manifest.json"
+
+	// maxFileSize is the largest config file accepted in an archive
+	maxFileSize = 1 << 20
+
+	// maxArchiveSize is the largest total size of the files in an archive
+	maxArchiveSize = 64 << 20
+
+	// ageHeader starts every age encrypted file
+	ageHeader = "age-encryption.org/"
+)
+
+// File is a config file in an archive.
+type File struct {
+	entity.BackupFile
+
+	// Data is the file contents
+	Data []byte
+}
+
+// Archive is a backup archive read by Read.
+type Archive struct {
+	Manifest entity.BackupManifest
+
+	// Files are the config files in manifest order
+	Files []File
+}
+
+// FileName returns the file name of an archive written at t.
+func FileName(t time.Time, encrypted bool) string {
+	name := "wgrest-backup-" + t.UTC().Format("20060102T150405Z") + ".tar.gz"
+	if encrypted {
+		name += ".age"
+	}
+	return name
+}
+
+// Write writes files and a manifest as a tar.gz archive to w, encrypted to
+// recipients if there are any. Device, Dir and Data of the files must be set.
+func Write(w io.Writer, files []File, version string, createdAt time.Time, recipients []age.Recipient) (*entity.BackupManifest, error) {
+	manifest := &entity.BackupManifest{
+		Version:       entity.BackupVersion,
+		WgrestVersion: version,
+		CreatedAt:     createdAt.UTC(),
+		Files:         make([]entity.BackupFile, len(files)),
+	}
+
+	// Files are stored per config directory, so shadowed configs of the
+	// same device don't collide
+	dirs := make(map[string]int)
+	for i, f := range files {
+		if _, ok := dirs[f.Dir]; !ok {
+			dirs[f.Dir] = len(dirs)
+		}
+		sum := sha256.Sum256(f.Data)
+		manifest.Files[i] = entity.BackupFile{
+			Device: f.Device,
+			Dir:    f.Dir,
+			Path:   "configs/" + strconv.Itoa(dirs[f.Dir]) + "/" + f.Device + ".conf",
+			Size:   int64(len(f.Data)),
+			SHA256: hex.EncodeToString(sum[:]),
+		}
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	out := w
+	var enc io.WriteCloser
+	if len(recipients) > 0 {
+		enc, err = age.Encrypt(w, recipients...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt archive: %w", err)
+		}
+		out = enc
+	}
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	add := func(path string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     path,
+			Mode:     0600,
+			Size:     int64(len(data)),
+			ModTime:  manifest.CreatedAt,
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	if err := add(manifestPath, manifestData); err != nil {
+		return nil, err
+	}
+	for i, f := range files {
+		if err := add(manifest.Files[i].Path, f.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return manifest, nil
+}
+
+// Read reads and verifies an archive written by Write. Encrypted archives
+// are decrypted with identities.
+func Read(r io.Reader, identities []age.Identity) (*Archive, error) {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(len(ageHeader))
+
+	var in io.Reader = br
+	if string(header) == ageHeader {
+		if len(identities) == 0 {
+			return nil, invalid("archive is encrypted; a passphrase or identity is required")
+		}
+		dec, err := age.Decrypt(br, identities...)
+		if err != nil {
+			return nil, invalid("failed to decrypt archive: %w", err)
+		}
+		in = dec
+	}
+
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, invalid("archive is not gzip compressed: %w", err)
+	}
+	defer gz.Close()
+
+	var manifestData []byte
+	contents := make(map[string][]byte)
+	var total int64
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, invalid("failed to read archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, invalid("unexpected entry %s in archive", hdr.Name)
+		}
+		if hdr.Size > maxFileSize {
+			return nil, invalid("%s is larger than %d bytes", hdr.Name, maxFileSize)
+		}
+		total += hdr.Size
+		if total > maxArchiveSize {
+			return nil, invalid("archive is larger than %d bytes", maxArchiveSize)
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, invalid("failed to read %s: %w", hdr.Name, err)
+		}
+
+		if hdr.Name == manifestPath {
+			manifestData = data
+			continue
+		}
+		if _, ok := contents[hdr.Name]; ok {
+			return nil, invalid("%s is in the archive twice", hdr.Name)
+		}
+		contents[hdr.Name] = data
+	}
+
+	if manifestData == nil {
+		return nil, invalid("archive has no %s", manifestPath)
+	}
+
+	archive := &Archive{}
+	if err := json.Unmarshal(manifestData, &archive.Manifest); err != nil {
+		return nil, invalid("failed to parse %s: %w", manifestPath, err)
+	}
+
+	archive.Files = make([]File, len(archive.Manifest.Files))
+	for i, f := range archive.Manifest.Files {
+		data, ok := contents[f.Path]
+		if !ok {
+			return nil, invalid("%s is missing from the archive", f.Path)
+		}
+		delete(contents, f.Path)
+
+		sum := sha256.Sum256(data)
+		if int64(len(data)) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return nil, invalid("%s doesn't match its checksum", f.Path)
+		}
+		archive.Files[i] = File{BackupFile: f, Data: bytes.Clone(data)}
+	}
+	for path := range contents {
+		return nil, invalid("%s is not listed in %s", path, manifestPath)
+	}
+
+	return archive, nil
+}
+
+// Recipients returns the age recipients for a passphrase or a list of age
+// public keys. A passphrase can't be combined with public keys.
+func Recipients(passphrase string, keys []string) ([]age.Recipient, error) {
+	if passphrase != "" {
+		if len(keys) > 0 {
+			return nil, domain.Validation(entity.ErrCodeInvalidRequest, "a passphrase can't be combined with recipients")
+		}
+		r, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, domain.Validation(entity.ErrCodeInvalidRequest, "invalid passphrase: %w", err)
+		}
+		return []age.Recipient{r}, nil
+	}
+
+	recipients := make([]age.Recipient, len(keys))
+	for i, key := range keys {
+		r, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, domain.Validation(entity.ErrCodeInvalidKey, "invalid recipient %q: %w", key, err)
+		}
+		recipients[i] = r
+	}
+	return recipients, nil
+}
+
+// Identities returns the age identities for a passphrase or a list of age
+// secret keys.
+func Identities(passphrase string, keys []string) ([]age.Identity, error) {
+	var identities []age.Identity
+	if passphrase != "" {
+		id, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, domain.Validation(entity.ErrCodeInvalidRequest, "invalid passphrase: %w", err)
+		}
+		identities = append(identities, id)
+	}
+
+	for _, key := range keys {
+		id, err := age.ParseX25519Identity(key)
+		if err != nil {
+			// Don't echo the secret key
+			return nil, domain.Validation(entity.ErrCodeInvalidKey, "invalid identity: %w", err)
+		}
+		identities = append(identities, id)
+	}
+	return identities, nil
+}
+
+func invalid(format string, args ...any) error {
+	return domain.Validation(entity.ErrCodeInvalidBackup, format, args...)
+}
@@ -0,0 +1,147 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+func testFiles() []File {
+	return []File{
+		{BackupFile: entity.BackupFile{Device: "wg0", Dir: "/etc/wireguard"}, Data: []byte("[Interface]\nListenPort = 51820\n")},
+		{BackupFile: entity.BackupFile{Device: "wg0", Dir: "/usr/local/etc/wireguard"}, Data: []byte("[Interface]\n")},
+		{BackupFile: entity.BackupFile{Device: "wg1", Dir: "/etc/wireguard"}, Data: []byte("[Interface]\nMTU = 1420\n")},
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	createdAt := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	manifest, err := Write(&buf, testFiles(), "1.2.3", createdAt, nil)
+	require.NoError(t, err)
+	assert.Equal(t, entity.BackupVersion, manifest.Version)
+	assert.Equal(t, "configs/0/wg0.conf", manifest.Files[0].Path)
+	assert.Equal(t, "configs/1/wg0.conf", manifest.Files[1].Path)
+	assert.Equal(t, "configs/0/wg1.conf", manifest.Files[2].Path)
+
+	archive, err := Read(&buf, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3", archive.Manifest.WgrestVersion)
+	assert.True(t, createdAt.Equal(archive.Manifest.CreatedAt))
+	require.Len(t, archive.Files, 3)
+	for i, f := range testFiles() {
+		assert.Equal(t, f.Device, archive.Files[i].Device)
+		assert.Equal(t, f.Dir, archive.Files[i].Dir)
+		assert.Equal(t, f.Data, archive.Files[i].Data)
+	}
+}
+
+func TestWriteRead_Passphrase(t *testing.T) {
+	recipients, err := Recipients("correct horse", nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = Write(&buf, testFiles(), "", time.Now(), recipients)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	_, err = Read(bytes.NewReader(data), nil)
+	assert.Equal(t, entity.ErrCodeInvalidBackup, domain.Code(err))
+
+	wrong, err := Identities("battery staple", nil)
+	require.NoError(t, err)
+	_, err = Read(bytes.NewReader(data), wrong)
+	assert.Equal(t, entity.ErrCodeInvalidBackup, domain.Code(err))
+
+	identities, err := Identities("correct horse", nil)
+	require.NoError(t, err)
+	archive, err := Read(bytes.NewReader(data), identities)
+	require.NoError(t, err)
+	assert.Len(t, archive.Files, 3)
+}
+
+func TestWriteRead_Recipient(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	recipients, err := Recipients("", []string{identity.Recipient().String()})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = Write(&buf, testFiles(), "", time.Now(), recipients)
+	require.NoError(t, err)
+
+	identities, err := Identities("", []string{identity.String()})
+	require.NoError(t, err)
+	archive, err := Read(&buf, identities)
+	require.NoError(t, err)
+	assert.Len(t, archive.Files, 3)
+}
+
+func TestRecipients_Invalid(t *testing.T) {
+	_, err := Recipients("secret", []string{"age1abc"})
+	assert.Equal(t, entity.ErrCodeInvalidRequest, domain.Code(err))
+
+	_, err = Recipients("", []string{"age1abc"})
+	assert.Equal(t, entity.ErrCodeInvalidKey, domain.Code(err))
+
+	_, err = Identities("", []string{"AGE-SECRET-KEY-1ABC"})
+	assert.Equal(t, entity.ErrCodeInvalidKey, domain.Code(err))
+}
+
+func TestRead_Invalid(t *testing.T) {
+	_, err := Read(bytes.NewReader([]byte("not an archive")), nil)
+	assert.Equal(t, entity.ErrCodeInvalidBackup, domain.Code(err))
+
+	manifest := `{"version": 1, "files": [{"device": "wg0", "dir": "/etc/wireguard", "path": "configs/0/wg0.conf", "size": 12, "sha256": "00"}]}`
+	testCases := []struct {
+		name     string
+		entries  []string
+		expected string
+	}{
+		{"missing manifest", []string{"configs/0/wg0.conf", "[Interface]\n"}, "no manifest.json"},
+		{"missing file", []string{"manifest.json", manifest}, "missing"},
+		{"checksum mismatch", []string{"manifest.json", manifest, "configs/0/wg0.conf", "[Interface]\n"}, "checksum"},
+		{"unlisted file", []string{"manifest.json", `{"version": 1}`, "configs/0/wg0.conf", "[Interface]\n"}, "not listed"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Read(tarGz(t, tc.entries...), nil)
+			assert.Equal(t, entity.ErrCodeInvalidBackup, domain.Code(err))
+			assert.Contains(t, err.Error(), tc.expected)
+		})
+	}
+}
+
+// tarGz returns a tar.gz archive of path and contents pairs.
+func tarGz(t *testing.T, entries ...string) *bytes.Buffer {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for i := 0; i < len(entries); i += 2 {
+		data := []byte(entries[i+1])
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: entries[i], Mode: 0600, Size: int64(len(data))}))
+		_, err := tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return &buf
+}
+
+func TestFileName(t *testing.T) {
+	at := time.Date(2026, 10, 19, 12, 30, 0, 0, time.UTC)
+	assert.Equal(t, "wgrest-backup-20261019T123000Z.tar.gz", FileName(at, false))
+	assert.Equal(t, "wgrest-backup-20261019T123000Z.tar.gz.age", FileName(at, true))
+}
@@ -0,0 +1,135 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Scheduler writes archives to a local directory periodically and prunes
+// old ones.
+type Scheduler struct {
+	dir       string
+	interval  time.Duration
+	keep      int
+	encrypted bool
+	write     func(io.Writer) error
+	now       func() time.Time
+}
+
+// NewScheduler creates a scheduler writing an archive to dir every interval
+// and keeping the newest keep archives (all if keep is 0). write produces
+// the archive; encrypted selects the file extension.
+func NewScheduler(
+	dir string,
+	interval time.Duration,
+	keep int,
+	encrypted bool,
+	write func(io.Writer) error,
+) *Scheduler {
+	return &Scheduler{
+		dir:       dir,
+		interval:  interval,
+		keep:      keep,
+		encrypted: encrypted,
+		write:     write,
+		now:       time.Now,
+	}
+}
+
+// Start begins the periodic backup loop.
+func (s *Scheduler) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			path, err := s.Run()
+			if err != nil {
+				log.Printf("Scheduled backup failed: %v", err)
+				continue
+			}
+			log.Printf("Wrote backup %s", path)
+		}
+	}
+}
+
+// Run writes an archive and prunes old ones. It returns the archive path.
+func (s *Scheduler) Run() (string, error) {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(s.dir, FileName(s.now(), s.encrypted))
+	tmpPath := path + ".tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup: %w", err)
+	}
+	if err := s.write(f); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to rename backup: %w", err)
+	}
+
+	if err := s.prune(); err != nil {
+		log.Printf("Failed to prune old backups: %v", err)
+	}
+
+	return path, nil
+}
+
+// prune removes all but the newest keep archives.
+func (s *Scheduler) prune() error {
+	if s.keep <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	var archives []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "wgrest-backup-") {
+			continue
+		}
+		if strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tar.gz.age") {
+			archives = append(archives, name)
+		}
+	}
+	if len(archives) <= s.keep {
+		return nil
+	}
+
+	// Names embed the UTC time, so they sort oldest first
+	sort.Strings(archives)
+
+	var lastErr error
+	for _, name := range archives[:len(archives)-s.keep] {
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,65 @@
+package backup
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler_Run(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	s := NewScheduler(dir, time.Hour, 2, false, func(w io.Writer) error {
+		_, err := w.Write([]byte("archive"))
+		return err
+	})
+	s.now = func() time.Time { return at }
+
+	// Unrelated files are never pruned
+	require.NoError(t, os.WriteFile(dir+"/notes.txt", nil, 0600))
+
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path, err := s.Run()
+		require.NoError(t, err)
+		paths = append(paths, path)
+		at = at.Add(time.Hour)
+	}
+
+	data, err := os.ReadFile(paths[2])
+	require.NoError(t, err)
+	assert.Equal(t, "archive", string(data))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{
+		"notes.txt",
+		"wgrest-backup-20261019T130000Z.tar.gz",
+		"wgrest-backup-20261019T140000Z.tar.gz",
+	}, names)
+}
+
+func TestScheduler_RunFailure(t *testing.T) {
+	dir := t.TempDir()
+
+	s := NewScheduler(dir, time.Hour, 0, true, func(w io.Writer) error {
+		return errors.New("boom")
+	})
+
+	_, err := s.Run()
+	require.Error(t, err)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
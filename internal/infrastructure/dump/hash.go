@@ -0,0 +1,117 @@
+package dump
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/netip"
+	"sort"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+)
+
+// canonicalState is the part of a device's state written to its config
+// file, in a normalized form that hashes the same from the kernel and from
+// the file.
+type canonicalState struct {
+	PrivateKey   string          `json:"private_key"`
+	ListenPort   int             `json:"listen_port"`
+	FirewallMark int             `json:"fwmark"`
+	Peers        []canonicalPeer `json:"peers"`
+}
+
+type canonicalPeer struct {
+	PublicKey    string   `json:"public_key"`
+	PresharedKey string   `json:"preshared_key"`
+	Endpoint     string   `json:"endpoint"`
+	AllowedIPs   []string `json:"allowed_ips"`
+	Keepalive    int      `json:"keepalive"`
+}
+
+// stateHash returns the canonical hash of a running device and its peers.
+func stateHash(device *entity.Device, peers []entity.Peer) string {
+	state := canonicalState{
+		PrivateKey:   canonicalKey(device.PrivateKey),
+		ListenPort:   int(device.ListenPort),
+		FirewallMark: int(device.FirewallMark),
+		Peers:        make([]canonicalPeer, len(peers)),
+	}
+	for i, p := range peers {
+		keepalive, _ := time.ParseDuration(p.PersistentKeepaliveInterval)
+		state.Peers[i] = canonicalPeer{
+			PublicKey:    canonicalKey(p.PublicKey),
+			PresharedKey: canonicalKey(p.PresharedKey),
+			Endpoint:     canonicalEndpoint(p.Endpoint),
+			AllowedIPs:   canonicalPrefixes(p.AllowedIPs),
+			Keepalive:    int(keepalive / time.Second),
+		}
+	}
+	return state.hash()
+}
+
+// configHash returns the canonical hash of a parsed config file.
+func configHash(cfg *wgquick.Config) string {
+	state := canonicalState{
+		PrivateKey:   canonicalKey(cfg.PrivateKey),
+		ListenPort:   cfg.ListenPort,
+		FirewallMark: cfg.FirewallMark,
+		Peers:        make([]canonicalPeer, len(cfg.Peers)),
+	}
+	for i, p := range cfg.Peers {
+		state.Peers[i] = canonicalPeer{
+			PublicKey:    canonicalKey(p.PublicKey),
+			PresharedKey: canonicalKey(p.PresharedKey),
+			Endpoint:     canonicalEndpoint(p.Endpoint),
+			AllowedIPs:   canonicalPrefixes(p.AllowedIPs),
+			Keepalive:    p.PersistentKeepaliveInterval,
+		}
+	}
+	return state.hash()
+}
+
+func (s canonicalState) hash() string {
+	sort.Slice(s.Peers, func(i, j int) bool {
+		return s.Peers[i].PublicKey < s.Peers[j].PublicKey
+	})
+
+	data, _ := json.Marshal(s)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalKey returns a key in standard base64. The all-zero key, which the
+// kernel reports for an unset preshared key, is returned as "".
+func canonicalKey(s string) string {
+	key, err := wgtypes.ParseKey(s)
+	if err != nil {
+		return s
+	}
+	if key == (wgtypes.Key{}) {
+		return ""
+	}
+	return key.String()
+}
+
+func canonicalEndpoint(s string) string {
+	addr, err := netip.ParseAddrPort(s)
+	if err != nil {
+		return s
+	}
+	return netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port()).String()
+}
+
+func canonicalPrefixes(ips []string) []string {
+	prefixes := make([]string, len(ips))
+	for i, ip := range ips {
+		prefixes[i] = ip
+		if prefix, err := netip.ParsePrefix(ip); err == nil {
+			prefixes[i] = prefix.Masked().String()
+		}
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
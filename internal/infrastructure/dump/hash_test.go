@@ -0,0 +1,85 @@
+package dump
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+)
+
+func TestStateHash_MatchesConfig(t *testing.T) {
+	privateKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	peer1, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	peer2, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	psk, err := wgtypes.GenerateKey()
+	require.NoError(t, err)
+
+	device := &entity.Device{
+		Name:         "wg0",
+		PrivateKey:   privateKey.String(),
+		ListenPort:   51820,
+		FirewallMark: 42,
+	}
+	// As reported by the kernel: no preshared key is the zero key, a
+	// disabled keepalive is 0s and allowed IPs come in kernel order
+	peers := []entity.Peer{
+		{
+			PublicKey:                   peer1.PublicKey().String(),
+			PresharedKey:                psk.String(),
+			AllowedIPs:                  []string{"10.0.0.3/32", "10.0.0.2/32"},
+			Endpoint:                    "[::ffff:192.0.2.1]:51820",
+			PersistentKeepaliveInterval: "25s",
+		},
+		{
+			PublicKey:                   peer2.PublicKey().String(),
+			PresharedKey:                wgtypes.Key{}.String(),
+			AllowedIPs:                  []string{"10.1.0.0/16"},
+			PersistentKeepaliveInterval: "0s",
+		},
+	}
+
+	// As written by wg-quick, with peers in another order and extra
+	// interface settings the kernel doesn't know about
+	conf := "[Interface]\n" +
+		"PrivateKey = " + privateKey.String() + "\n" +
+		"Address = 10.0.0.1/24\n" +
+		"ListenPort = 51820\n" +
+		"FwMark = 42\n" +
+		"\n[Peer]\n" +
+		"PublicKey = " + peer2.PublicKey().String() + "\n" +
+		"AllowedIPs = 10.1.0.0/16\n" +
+		"\n[Peer]\n" +
+		"PublicKey = " + peer1.PublicKey().String() + "\n" +
+		"PresharedKey = " + psk.String() + "\n" +
+		"AllowedIPs = 10.0.0.2/32, 10.0.0.3/32\n" +
+		"Endpoint = 192.0.2.1:51820\n" +
+		"PersistentKeepalive = 25\n"
+	cfg, err := wgquick.ParseConfig(strings.NewReader(conf))
+	require.NoError(t, err)
+
+	assert.Equal(t, stateHash(device, peers), configHash(cfg))
+
+	// Any change to the peers changes the hash
+	peers[1].AllowedIPs = []string{"10.2.0.0/16"}
+	assert.NotEqual(t, stateHash(device, peers), configHash(cfg))
+}
+
+func TestStateHash_DeviceChanges(t *testing.T) {
+	device := &entity.Device{Name: "wg0", ListenPort: 51820}
+	hash := stateHash(device, nil)
+
+	device.ListenPort = 51821
+	assert.NotEqual(t, hash, stateHash(device, nil))
+
+	device.ListenPort = 51820
+	device.FirewallMark = 1
+	assert.NotEqual(t, hash, stateHash(device, nil))
+}
@@ -3,30 +3,56 @@ package dump
 import (
 	"context"
 	"log"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
 	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
 	"github.com/suquant/wgrest/internal/infrastructure/wireguard"
 )
 
-// Service provides periodic config dump functionality.
+// Service keeps config files in sync with the running devices. A config file
+// is only written when the device state differs from it, and saves requested
+// by API mutations are batched within a debounce window.
 type Service struct {
 	interval   time.Duration
+	debounce   time.Duration
 	wgClient   *wireguard.Client
 	wgquickSvc *wgquick.Service
+	now        func() time.Time
+
+	// save writes a device's config if it changed and returns the state hash
+	save func(name string) (hash string, saved bool, err error)
+
+	mu      sync.Mutex
+	pending map[string]struct{}
+	saving  map[string]int
+	timer   *time.Timer
+	status  map[string]*entity.DumpStatus
 }
 
-// NewService creates a new dump service.
+// NewService creates a new dump service. Saves requested within debounce of
+// each other are batched; a debounce of 0 saves immediately.
 func NewService(
 	interval time.Duration,
+	debounce time.Duration,
 	wgClient *wireguard.Client,
 	wgquickSvc *wgquick.Service,
 ) *Service {
-	return &Service{
+	s := &Service{
 		interval:   interval,
+		debounce:   debounce,
 		wgClient:   wgClient,
 		wgquickSvc: wgquickSvc,
+		now:        time.Now,
+		pending:    make(map[string]struct{}),
+		saving:     make(map[string]int),
+		status:     make(map[string]*entity.DumpStatus),
 	}
+	s.save = s.saveIfChanged
+	return s
 }
 
 // Start begins the periodic dump loop.
@@ -42,8 +68,9 @@ func (s *Service) Start(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			// Final save on shutdown
+			// Final save on shutdown, which covers pending requests
 			log.Println("Performing final config dump before shutdown...")
+			s.stopTimer()
 			if err := s.SaveAll(); err != nil {
 				log.Printf("Final config dump failed: %v", err)
 			}
@@ -56,22 +83,228 @@ func (s *Service) Start(ctx context.Context) {
 	}
 }
 
-// SaveAll saves configs for all WireGuard devices using wg showconf.
+// SaveAll saves the configs of all WireGuard devices whose state changed.
 func (s *Service) SaveAll() error {
-	devices, err := s.wgClient.List()
+	devices, err := s.wgClient.List(context.Background())
 	if err != nil {
 		return err
 	}
 
+	names := make(map[string]bool, len(devices))
 	var lastErr error
 	for _, device := range devices {
-		if err := s.wgquickSvc.SaveFromShowconf(device.Name); err != nil {
-			log.Printf("Failed to save config for %s: %v", device.Name, err)
+		names[device.Name] = true
+		if err := s.saveUnlessDrifted(device.Name); err != nil {
 			lastErr = err
-		} else {
-			log.Printf("Saved config for interface: %s", device.Name)
 		}
 	}
 
+	// Forget devices that no longer exist
+	s.mu.Lock()
+	for name := range s.status {
+		if _, pending := s.pending[name]; !names[name] && !pending {
+			delete(s.status, name)
+		}
+	}
+	s.mu.Unlock()
+
 	return lastErr
 }
+
+// Save writes the config of a device if its state differs from the config
+// file and reports whether it was written. A written config is no longer
+// drifted.
+func (s *Service) Save(name string) (bool, error) {
+	s.mu.Lock()
+	delete(s.pending, name)
+	s.saving[name]++
+	s.mu.Unlock()
+
+	hash, saved, err := s.save(name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.saving[name]--; s.saving[name] == 0 {
+		delete(s.saving, name)
+	}
+
+	now := s.now()
+	status := s.deviceStatus(name)
+	status.CheckedAt = &now
+	status.Error = ""
+	if err != nil {
+		status.Error = err.Error()
+		log.Printf("Failed to save config for %s: %v", name, err)
+		return false, err
+	}
+	status.Hash = hash
+	if saved {
+		status.SavedAt = &now
+		status.Drifted = false
+		log.Printf("Saved config for interface: %s", name)
+	}
+	return saved, nil
+}
+
+// saveUnlessDrifted saves the config of a device unless its config file was
+// edited outside wgrest, so background saves don't overwrite the edit.
+func (s *Service) saveUnlessDrifted(name string) error {
+	if s.drifted(name) {
+		s.mu.Lock()
+		delete(s.pending, name)
+		s.mu.Unlock()
+		return nil
+	}
+	_, err := s.Save(name)
+	return err
+}
+
+// Request schedules a save of a device's config at the end of the debounce
+// window, so a burst of mutations results in one write. Drifted devices
+// aren't saved.
+func (s *Service) Request(name string) {
+	if s.debounce <= 0 {
+		s.saveUnlessDrifted(name)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending[name] = struct{}{}
+	s.deviceStatus(name)
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.debounce, s.Flush)
+	}
+}
+
+// Flush saves the configs of all devices with a pending request.
+func (s *Service) Flush() {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.pending))
+	for name := range s.pending {
+		names = append(names, name)
+	}
+	s.timer = nil
+	s.mu.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		s.saveUnlessDrifted(name)
+	}
+}
+
+// Pending reports whether a save of a device's config was requested or is
+// being written, so the config file doesn't reflect the device yet.
+func (s *Service) Pending(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, pending := s.pending[name]
+	return pending || s.saving[name] > 0
+}
+
+// InSync reports whether the config file of a running device matches its
+// state.
+func (s *Service) InSync(name string) (bool, error) {
+	_, _, _, inSync, err := s.compare(name)
+	return inSync, err
+}
+
+// SetDrifted flags a device whose config file was edited outside wgrest.
+// Periodic and requested saves skip drifted devices so the edit isn't
+// overwritten.
+func (s *Service) SetDrifted(name string, drifted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deviceStatus(name).Drifted = drifted
+}
+
+func (s *Service) drifted(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, ok := s.status[name]
+	return ok && status.Drifted
+}
+
+// Status returns the dump state of every known device, sorted by name.
+func (s *Service) Status() []entity.DumpStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]entity.DumpStatus, 0, len(s.status))
+	for name, status := range s.status {
+		st := *status
+		_, st.Pending = s.pending[name]
+		result = append(result, st)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Device < result[j].Device
+	})
+	return result
+}
+
+// deviceStatus returns the status of a device, creating it if needed.
+// s.mu must be held.
+func (s *Service) deviceStatus(name string) *entity.DumpStatus {
+	status, ok := s.status[name]
+	if !ok {
+		status = &entity.DumpStatus{Device: name}
+		s.status[name] = status
+	}
+	return status
+}
+
+func (s *Service) stopTimer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+// saveIfChanged writes the config of a running device only if its state
+// differs from the config file.
+func (s *Service) saveIfChanged(name string) (string, bool, error) {
+	device, peers, hash, inSync, err := s.compare(name)
+	if err != nil || inSync {
+		return hash, false, err
+	}
+
+	if err := s.wgquickSvc.SaveRunningConfig(device, peers); err != nil {
+		return "", false, err
+	}
+	return hash, true, nil
+}
+
+// compare fetches a running device and compares the canonical hash of its
+// state with that of its config file. An unparsable config file never
+// matches; one whose keys can't be decrypted is an error, so it isn't
+// overwritten.
+func (s *Service) compare(name string) (*entity.Device, []entity.Peer, string, bool, error) {
+	// Saves run in the background and on shutdown, so they aren't bound to
+	// a request or the server's context
+	device, peers, err := s.wgClient.GetWithPeers(context.Background(), name)
+	if err != nil {
+		return nil, nil, "", false, err
+	}
+	hash := stateHash(device, peers)
+
+	current, err := s.wgquickSvc.ReadConfig(name)
+	if err != nil {
+		return nil, nil, "", false, err
+	}
+	if current == "" {
+		return device, peers, hash, false, nil
+	}
+	cfg, err := s.wgquickSvc.DecodeConfig([]byte(current))
+	if domain.Code(err) == entity.ErrCodeEncryptedKey {
+		return nil, nil, "", false, err
+	}
+	return device, peers, hash, err == nil && configHash(cfg) == hash, nil
+}
@@ -0,0 +1,144 @@
+package dump
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestService returns a service whose saves are recorded instead of
+// touching any device.
+func newTestService(debounce time.Duration, saveErr error) (*Service, func() []string) {
+	s := NewService(time.Hour, debounce, nil, nil)
+
+	var mu sync.Mutex
+	var saved []string
+	s.save = func(name string) (string, bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if saveErr != nil {
+			return "", false, saveErr
+		}
+		saved = append(saved, name)
+		return "hash-" + name, true, nil
+	}
+	return s, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), saved...)
+	}
+}
+
+func TestService_RequestDebounces(t *testing.T) {
+	s, saved := newTestService(time.Hour, nil)
+
+	for i := 0; i < 100; i++ {
+		s.Request("wg1")
+		s.Request("wg0")
+	}
+	assert.Empty(t, saved())
+
+	status := s.Status()
+	require.Len(t, status, 2)
+	assert.Equal(t, "wg0", status[0].Device)
+	assert.True(t, status[0].Pending)
+	assert.Nil(t, status[0].SavedAt)
+
+	// The end of the window saves each device once
+	s.Flush()
+	assert.Equal(t, []string{"wg0", "wg1"}, saved())
+
+	status = s.Status()
+	require.Len(t, status, 2)
+	assert.False(t, status[0].Pending)
+	assert.Equal(t, "hash-wg0", status[0].Hash)
+	require.NotNil(t, status[0].SavedAt)
+	assert.Equal(t, status[0].CheckedAt, status[0].SavedAt)
+}
+
+func TestService_RequestTimer(t *testing.T) {
+	s, saved := newTestService(10*time.Millisecond, nil)
+
+	s.Request("wg0")
+	s.Request("wg0")
+
+	assert.Eventually(t, func() bool {
+		return len(saved()) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"wg0"}, saved())
+}
+
+func TestService_RequestWithoutDebounce(t *testing.T) {
+	s, saved := newTestService(0, nil)
+
+	s.Request("wg0")
+	assert.Equal(t, []string{"wg0"}, saved())
+	assert.False(t, s.Status()[0].Pending)
+}
+
+func TestService_SaveError(t *testing.T) {
+	s, _ := newTestService(0, errors.New("boom"))
+
+	saved, err := s.Save("wg0")
+	require.Error(t, err)
+	assert.False(t, saved)
+
+	status := s.Status()
+	require.Len(t, status, 1)
+	assert.Equal(t, "boom", status[0].Error)
+	assert.NotNil(t, status[0].CheckedAt)
+	assert.Nil(t, status[0].SavedAt)
+}
+
+func TestService_Drifted(t *testing.T) {
+	s, _ := newTestService(0, nil)
+
+	s.SetDrifted("wg0", true)
+	assert.True(t, s.drifted("wg0"))
+	assert.True(t, s.Status()[0].Drifted)
+
+	// Writing the config resolves the drift
+	_, err := s.Save("wg0")
+	require.NoError(t, err)
+	assert.False(t, s.drifted("wg0"))
+}
+
+func TestService_RequestSkipsDrifted(t *testing.T) {
+	for _, debounce := range []time.Duration{0, time.Hour} {
+		s, saved := newTestService(debounce, nil)
+		s.SetDrifted("wg0", true)
+
+		s.Request("wg0")
+		s.Request("wg1")
+		s.Flush()
+
+		// The edit made outside wgrest is kept until the drift is resolved
+		assert.Equal(t, []string{"wg1"}, saved())
+		status := s.Status()
+		require.Len(t, status, 2)
+		assert.True(t, status[0].Drifted)
+		assert.False(t, status[0].Pending)
+		assert.Nil(t, status[0].SavedAt)
+	}
+}
+
+func TestService_Pending(t *testing.T) {
+	s, _ := newTestService(time.Hour, nil)
+	assert.False(t, s.Pending("wg0"))
+
+	s.Request("wg0")
+	assert.True(t, s.Pending("wg0"))
+
+	// A save being written still counts
+	save := s.save
+	s.save = func(name string) (string, bool, error) {
+		assert.True(t, s.Pending(name))
+		return save(name)
+	}
+	s.Flush()
+	assert.False(t, s.Pending("wg0"))
+}
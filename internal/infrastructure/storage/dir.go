@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// entryExt is the extension of entry files.
+const entryExt = ".json"
+
+// entry is a stored value with an optional expiry.
+type entry struct {
+	Value   []byte    `json:"value"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+func (e entry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && now.After(e.Expires)
+}
+
+// DirStorage is a fiber.Storage keeping each entry in its own file in a
+// directory, so entries survive restarts and a write only touches the entry
+// being written.
+type DirStorage struct {
+	dir string
+	now func() time.Time
+
+	mu sync.Mutex
+	// expires holds the expiry of every stored entry, to prune them without
+	// reading their files
+	expires map[string]time.Time
+	// entries holds the entries of a storage without a directory
+	entries map[string]entry
+}
+
+// NewDirStorage opens the storage in dir, creating it on first write. An
+// empty dir keeps entries in memory only.
+func NewDirStorage(dir string) (*DirStorage, error) {
+	s := &DirStorage{
+		dir:     dir,
+		now:     time.Now,
+		expires: make(map[string]time.Time),
+		entries: make(map[string]entry),
+	}
+	if dir == "" {
+		return s, nil
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	for _, f := range files {
+		name, ok := strings.CutSuffix(f.Name(), entryExt)
+		if !ok || f.IsDir() {
+			continue
+		}
+		e, err := s.readEntry(name)
+		if err != nil {
+			return nil, err
+		}
+		s.expires[name] = e.Expires
+	}
+	s.prune()
+
+	return s, nil
+}
+
+// Get returns the value for key, or nil if it's missing or expired.
+func (s *DirStorage) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := fileName(key)
+	expires, ok := s.expires[name]
+	if !ok || (entry{Expires: expires}).expired(s.now()) {
+		return nil, nil
+	}
+	if s.dir == "" {
+		return s.entries[name].Value, nil
+	}
+
+	e, err := s.readEntry(name)
+	if err != nil {
+		return nil, err
+	}
+	return e.Value, nil
+}
+
+// Set stores val for key. A zero exp keeps the entry forever.
+func (s *DirStorage) Set(key string, val []byte, exp time.Duration) error {
+	if key == "" || len(val) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := entry{Value: append([]byte(nil), val...)}
+	if exp > 0 {
+		e.Expires = s.now().Add(exp)
+	}
+	s.prune()
+
+	name := fileName(key)
+	if err := s.writeEntry(name, e); err != nil {
+		return err
+	}
+	s.expires[name] = e.Expires
+	return nil
+}
+
+// Delete removes key.
+func (s *DirStorage) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.remove(fileName(key))
+}
+
+// Reset removes all entries.
+func (s *DirStorage) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name := range s.expires {
+		if err := s.remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close implements fiber.Storage. Entries are already persisted.
+func (s *DirStorage) Close() error {
+	return nil
+}
+
+// prune drops expired entries. Callers must hold mu.
+func (s *DirStorage) prune() {
+	now := s.now()
+	for name, expires := range s.expires {
+		if (entry{Expires: expires}).expired(now) {
+			s.remove(name)
+		}
+	}
+}
+
+// remove deletes the entry stored as name. Callers must hold mu.
+func (s *DirStorage) remove(name string) error {
+	if _, ok := s.expires[name]; !ok {
+		return nil
+	}
+	delete(s.expires, name)
+	delete(s.entries, name)
+
+	if s.dir == "" {
+		return nil
+	}
+	if err := os.Remove(s.path(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// readEntry reads the entry stored as name. Callers must hold mu.
+func (s *DirStorage) readEntry(name string) (entry, error) {
+	var e entry
+	data, err := os.ReadFile(s.path(name))
+	if err != nil {
+		return e, fmt.Errorf("failed to read %s: %w", s.path(name), err)
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return e, fmt.Errorf("failed to parse %s: %w", s.path(name), err)
+	}
+	return e, nil
+}
+
+// writeEntry atomically writes the entry stored as name. Callers must hold mu.
+func (s *DirStorage) writeEntry(name string, e entry) error {
+	if s.dir == "" {
+		s.entries[name] = e
+		return nil
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", s.dir, err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, name+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(name))
+}
+
+func (s *DirStorage) path(name string) string {
+	return filepath.Join(s.dir, name+entryExt)
+}
+
+// fileName returns the name an entry is stored as. Keys are hashed so any
+// key is a valid file name.
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirStorage_PersistsAcrossRestarts(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state", "store")
+
+	s, err := NewDirStorage(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.Set("a", []byte("1"), time.Hour))
+	require.NoError(t, s.Set("b", []byte("2"), 0))
+
+	info, err := os.Stat(filepath.Join(dir, fileName("a")+entryExt))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	reopened, err := NewDirStorage(dir)
+	require.NoError(t, err)
+
+	val, err := reopened.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), val)
+
+	val, err = reopened.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), val)
+}
+
+func TestDirStorage_FilePerEntry(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewDirStorage(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.Set("a", []byte("1"), 0))
+
+	// Writing an entry leaves the files of others alone
+	pathA := filepath.Join(dir, fileName("a")+entryExt)
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(pathA, old, old))
+	require.NoError(t, s.Set("b", []byte("2"), 0))
+
+	info, err := os.Stat(pathA)
+	require.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(old))
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestDirStorage_Expiry(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	s, err := NewDirStorage(dir)
+	require.NoError(t, err)
+	s.now = func() time.Time { return now }
+
+	require.NoError(t, s.Set("key", []byte("value"), time.Minute))
+
+	now = now.Add(2 * time.Minute)
+	val, err := s.Get("key")
+	require.NoError(t, err)
+	assert.Nil(t, val)
+
+	// Expired entries are pruned on the next write
+	require.NoError(t, s.Set("other", []byte("x"), 0))
+	assert.NotContains(t, s.expires, fileName("key"))
+	_, err = os.Stat(filepath.Join(dir, fileName("key")+entryExt))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDirStorage_DeleteAndReset(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewDirStorage(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.Set("a", []byte("1"), 0))
+	require.NoError(t, s.Set("b", []byte("2"), 0))
+
+	require.NoError(t, s.Delete("a"))
+	val, _ := s.Get("a")
+	assert.Nil(t, val)
+
+	require.NoError(t, s.Reset())
+	reopened, err := NewDirStorage(dir)
+	require.NoError(t, err)
+	val, _ = reopened.Get("b")
+	assert.Nil(t, val)
+}
+
+func TestDirStorage_InMemory(t *testing.T) {
+	s, err := NewDirStorage("")
+	require.NoError(t, err)
+
+	require.NoError(t, s.Set("a", []byte("1"), 0))
+	val, err := s.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), val)
+}
+
+func TestNewDirStorage_InvalidEntry(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, fileName("a")+entryExt), []byte("not json"), 0600))
+
+	_, err := NewDirStorage(dir)
+	assert.Error(t, err)
+}
@@ -0,0 +1,110 @@
+package watch
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher reports changes to the config files in a set of directories.
+// Events for the same file within debounce are reported once, so an editor
+// writing a file in several steps results in one call.
+type Watcher struct {
+	dirs     []string
+	debounce time.Duration
+	onChange func(dir, name string)
+
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+// NewWatcher creates a watcher calling onChange with the directory and device
+// name of every changed config file.
+func NewWatcher(dirs []string, debounce time.Duration, onChange func(dir, name string)) *Watcher {
+	return &Watcher{
+		dirs:     dirs,
+		debounce: debounce,
+		onChange: onChange,
+		timers:   make(map[string]*time.Timer),
+	}
+}
+
+// Start watches the directories until ctx is done. Directories that don't
+// exist are skipped.
+func (w *Watcher) Start(ctx context.Context) error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fsw.Close()
+
+	for _, dir := range w.dirs {
+		if err := fsw.Add(dir); err != nil {
+			log.Printf("Not watching config directory %s: %v", dir, err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.stop()
+			return nil
+		case event, ok := <-fsw.Events:
+			if !ok {
+				return nil
+			}
+			w.handle(event)
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("Config watcher error: %v", err)
+		}
+	}
+}
+
+// handle schedules a report for a created or written config file. Removals
+// are ignored; config files are replaced by renaming a temp file over them,
+// which shows up as a create.
+func (w *Watcher) handle(event fsnotify.Event) {
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+
+	name, ok := strings.CutSuffix(filepath.Base(event.Name), ".conf")
+	if !ok || name == "" {
+		return
+	}
+	dir := filepath.Dir(event.Name)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if timer, ok := w.timers[event.Name]; ok {
+		timer.Reset(w.debounce)
+		return
+	}
+	path := event.Name
+	w.timers[path] = time.AfterFunc(w.debounce, func() {
+		w.mu.Lock()
+		delete(w.timers, path)
+		w.mu.Unlock()
+
+		w.onChange(dir, name)
+	})
+}
+
+func (w *Watcher) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for path, timer := range w.timers {
+		timer.Stop()
+		delete(w.timers, path)
+	}
+}
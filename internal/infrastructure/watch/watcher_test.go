@@ -0,0 +1,60 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+
+	var mu sync.Mutex
+	var changes []string
+	w := NewWatcher([]string{dir, filepath.Join(dir, "missing")}, 50*time.Millisecond, func(d, name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, dir, d)
+		changes = append(changes, name)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	// Give the watcher time to add the directory
+	time.Sleep(50 * time.Millisecond)
+
+	// Several writes of one file are reported once; other files are ignored
+	path := filepath.Join(dir, "wg0.conf")
+	for i := 0; i < 3; i++ {
+		require.NoError(t, os.WriteFile(path, []byte("[Interface]\n"), 0600))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0600))
+
+	// Atomic replacement through a temp file
+	tmp := filepath.Join(dir, "wg1.conf.tmp")
+	require.NoError(t, os.WriteFile(tmp, []byte("[Interface]\n"), 0600))
+	require.NoError(t, os.Rename(tmp, filepath.Join(dir, "wg1.conf")))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(changes) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.ElementsMatch(t, []string{"wg0", "wg1"}, changes)
+}
@@ -4,8 +4,11 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,6 +17,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/suquant/wgrest/internal/domain"
 	"github.com/suquant/wgrest/internal/domain/entity"
 )
 
@@ -46,10 +50,17 @@ type PeerConfig struct {
 	PersistentKeepaliveInterval int
 }
 
+// commandTimeout bounds a wg-quick run, which may hang waiting for a sudo
+// password.
+const commandTimeout = 30 * time.Second
+
 // Service manages wg-quick configuration files.
 type Service struct {
 	// Config directories to search (in order)
 	configDirs []string
+	history    *History
+	keyCipher  *KeyCipher
+	keySetter  KeySetter
 	mu         sync.Mutex
 }
 
@@ -104,6 +115,34 @@ func (s *Service) ConfigDirs() []string {
 	return s.configDirs
 }
 
+// ConfigFile is a config file found in one of the config directories.
+type ConfigFile struct {
+	Dir  string
+	Name string
+}
+
+// ConfigFiles returns every config file in every config directory, including
+// files shadowed by an earlier directory.
+func (s *Service) ConfigFiles() []ConfigFile {
+	var files []ConfigFile
+
+	for _, dir := range s.configDirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue // Directory might not exist
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".conf") {
+				continue
+			}
+			files = append(files, ConfigFile{Dir: dir, Name: strings.TrimSuffix(entry.Name(), ".conf")})
+		}
+	}
+
+	return files
+}
+
 // ListConfigDevices returns names of all devices that have config files.
 func (s *Service) ListConfigDevices() []string {
 	seen := make(map[string]bool)
@@ -144,118 +183,332 @@ func (s *Service) SaveConfig(device *entity.Device, peers []entity.Peer) error {
 		return err
 	}
 
-	config := s.buildConfig(device, peers)
-	configPath := filepath.Join(configDir, device.Name+".conf")
-	tmpPath := configPath + ".tmp"
+	return s.writeConfig(configDir, device.Name, []byte(s.buildConfig(device, peers)))
+}
 
-	if err := os.WriteFile(tmpPath, []byte(config), 0600); err != nil {
-		return fmt.Errorf("failed to write config: %w", err)
+// writeConfig atomically replaces the config file of a device in dir and
+// records a revision of it. Callers must hold mu.
+func (s *Service) writeConfig(dir, name string, data []byte) error {
+	unlock, err := lockConfig(dir, name)
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	if err := os.Rename(tmpPath, configPath); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to rename config: %w", err)
+	previous, previousTime, err := readCurrent(filepath.Join(dir, name+".conf"))
+	if err != nil {
+		return err
 	}
-
-	return nil
+	return s.replaceConfig(dir, name, previous, previousTime, data)
 }
 
-// SaveFromShowconf saves config using `wg showconf` command output.
-func (s *Service) SaveFromShowconf(name string) error {
+// EditConfig locks the config file of a device, passes it to fn parsed (nil
+// if there is none) and replaces it with the config fn returns, unless that
+// is empty. It fails with a conflict if the file is changed by another
+// process in the meantime.
+func (s *Service) EditConfig(name string, fn func(cfg *Config) (string, error)) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	cmd := exec.Command("wg", "showconf", name)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	// Write to the same dir where config was found, or first dir for new configs
+	dir := s.GetConfigDir(name)
+	if err := ensureDir(dir); err != nil {
+		return err
+	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("wg showconf failed: %w: %s", err, stderr.String())
+	return s.editConfigIn(dir, name, func(previous []byte) ([]byte, error) {
+		var cfg *Config
+		if previous != nil {
+			var err error
+			if cfg, err = ParseConfig(bytes.NewReader(previous)); err != nil {
+				return nil, domain.Validation(entity.ErrCodeInvalidRequest, "failed to parse config for %s: %w", name, err)
+			}
+			if err := s.decryptConfig(cfg); err != nil {
+				return nil, err
+			}
+		}
+
+		data, err := fn(cfg)
+		if err != nil || data == "" {
+			return nil, err
+		}
+		return []byte(data), nil
+	})
+}
+
+// editConfigIn locks the config file of a device in dir and replaces it with
+// what fn returns for its current contents (nil if there is none). If fn
+// returns nil the file is left alone. Callers must hold mu.
+func (s *Service) editConfigIn(dir, name string, fn func(previous []byte) ([]byte, error)) error {
+	unlock, err := lockConfig(dir, name)
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	// Write to the same dir where config was found, or first dir for new configs
-	configDir := s.GetConfigDir(name)
-	if err := ensureDir(configDir); err != nil {
+	previous, previousTime, err := readCurrent(filepath.Join(dir, name+".conf"))
+	if err != nil {
+		return err
+	}
+
+	data, err := fn(previous)
+	if err != nil || data == nil {
 		return err
 	}
+	return s.replaceConfig(dir, name, previous, previousTime, data)
+}
 
-	configPath := filepath.Join(configDir, name+".conf")
+// replaceConfig writes data to a synced temp file and renames it over the
+// config file, unless the file no longer holds previous, which means another
+// process changed it since it was read. Callers must hold the config lock.
+func (s *Service) replaceConfig(dir, name string, previous []byte, previousTime time.Time, data []byte) error {
+	configPath := filepath.Join(dir, name+".conf")
 	tmpPath := configPath + ".tmp"
 
-	if err := os.WriteFile(tmpPath, stdout.Bytes(), 0600); err != nil {
+	if err := writeSynced(tmpPath, data); err != nil {
+		os.Remove(tmpPath)
 		return fmt.Errorf("failed to write config: %w", err)
 	}
 
+	current, _, err := readCurrent(configPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if !bytes.Equal(current, previous) || (current == nil) != (previous == nil) {
+		os.Remove(tmpPath)
+		return domain.Conflict(entity.ErrCodeConfigModified, "config for %s was modified by another process", name)
+	}
+
 	if err := os.Rename(tmpPath, configPath); err != nil {
 		os.Remove(tmpPath)
 		return fmt.Errorf("failed to rename config: %w", err)
 	}
+	if err := syncDir(dir); err != nil {
+		return fmt.Errorf("failed to sync config directory: %w", err)
+	}
+
+	if s.history != nil {
+		// The config was written; a missing revision isn't worth failing for
+		if err := s.history.record(name, s.sealConfig(previous), previousTime, data); err != nil {
+			log.Printf("Failed to record config revision for %s: %v", name, err)
+		}
+	}
 
 	return nil
 }
 
+// readCurrent returns the contents and modification time of a file, or nil
+// if it doesn't exist.
+func readCurrent(path string) ([]byte, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, time.Time{}, nil
+		}
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, time.Time{}, nil
+		}
+		return nil, time.Time{}, err
+	}
+	return data, info.ModTime(), nil
+}
+
+// writeSynced writes data to a new file and flushes it to disk.
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// RenderConfig returns the config SaveConfig would write for a device.
+func (s *Service) RenderConfig(device *entity.Device, peers []entity.Peer) string {
+	return s.buildConfig(device, peers)
+}
+
+// ReadConfig returns the current config file contents, or "" if there is none.
+func (s *Service) ReadConfig(name string) (string, error) {
+	return readConfigFile(s.FindConfigPath(name), name)
+}
+
+// ReadConfigIn returns the contents of the config file of a device in dir,
+// or "" if there is none.
+func (s *Service) ReadConfigIn(dir, name string) (string, error) {
+	return readConfigFile(filepath.Join(dir, name+".conf"), name)
+}
+
+// WriteConfigIn replaces the config file of a device in dir with data.
+func (s *Service) WriteConfigIn(dir, name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ensureDir(dir); err != nil {
+		return err
+	}
+	return s.writeConfig(dir, name, data)
+}
+
+func readConfigFile(path, name string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		if errors.Is(err, fs.ErrPermission) {
+			return "", domain.Permission(entity.ErrCodePermissionDenied, "failed to read config for %s: %w", name, err)
+		}
+		return "", err
+	}
+	return string(data), nil
+}
+
+// SaveRunningConfig writes the config of a running device and its peers.
+// The wg-quick options of an existing config file (addresses, DNS, MTU, table
+// and hooks), which the kernel doesn't know about, are kept.
+func (s *Service) SaveRunningConfig(device *entity.Device, peers []entity.Peer) error {
+	return s.EditConfig(device.Name, func(cfg *Config) (string, error) {
+		rendered := *device
+		if cfg != nil {
+			rendered.Addresses = cfg.Addresses
+			rendered.DNS = cfg.DNS
+			rendered.MTU = int32(cfg.MTU)
+			rendered.Table = cfg.Table
+			rendered.PreUp = cfg.PreUp
+			rendered.PostUp = cfg.PostUp
+			rendered.PreDown = cfg.PreDown
+			rendered.PostDown = cfg.PostDown
+		}
+		return s.buildConfig(&rendered, peers), nil
+	})
+}
+
 // LoadConfig parses a wg-quick config file, searching all paths.
 func (s *Service) LoadConfig(name string) (*Config, error) {
 	configPath := s.FindConfigPath(name)
 	f, err := os.Open(configPath)
 	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, domain.NotFound(entity.ErrCodeConfigNotFound, "config for %s not found: %w", name, err)
+		}
+		if errors.Is(err, fs.ErrPermission) {
+			return nil, domain.Permission(entity.ErrCodePermissionDenied, "failed to read config for %s: %w", name, err)
+		}
 		return nil, err
 	}
 	defer f.Close()
 
-	return ParseConfig(f)
+	cfg, err := ParseConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.decryptConfig(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
 }
 
 // Up brings up a WireGuard interface using wg-quick.
 // Note: wg-quick typically requires root/sudo.
-func (s *Service) Up(name string) error {
-	// Use timeout to prevent indefinite waiting for sudo password
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+func (s *Service) Up(ctx context.Context, name string) error {
+	config, keys, cleanup, err := s.upConfig(name)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
 
-	cmd := exec.CommandContext(ctx, "wg-quick", "up", name)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	// Prevent any stdin interaction (sudo password prompts) - use explicit devnull
-	devNull, err := os.Open(os.DevNull)
-	if err == nil {
-		cmd.Stdin = devNull
-		defer devNull.Close()
+	if err := runWgQuick(ctx, "up", name, config); err != nil {
+		return err
 	}
-	// Set environment to prevent sudo from asking for password
-	cmd.Env = append(os.Environ(), "SUDO_ASKPASS=/bin/false", "SSH_ASKPASS=/bin/false")
 
-	if err := cmd.Run(); err != nil {
-		errMsg := stderr.String()
-		if errMsg == "" {
-			errMsg = stdout.String()
-		}
-		// Check for context timeout (usually means waiting for sudo)
-		if ctx.Err() == context.DeadlineExceeded {
-			return fmt.Errorf("wg-quick up timed out (likely waiting for sudo password): run wgrest as root")
+	if keys != nil {
+		presharedKeys := make(map[string]string)
+		for _, peer := range keys.Peers {
+			if peer.PresharedKey != "" {
+				presharedKeys[peer.PublicKey] = peer.PresharedKey
+			}
 		}
-		// Check for permission errors
-		if strings.Contains(errMsg, "must be run as root") ||
-			strings.Contains(errMsg, "Permission denied") ||
-			strings.Contains(errMsg, "Operation not permitted") {
-			return fmt.Errorf("wg-quick up requires root privileges: %s", errMsg)
+		if err := s.keySetter.SetKeys(ctx, name, keys.PrivateKey, presharedKeys); err != nil {
+			return fmt.Errorf("interface %s is up but setting its keys failed: %w", name, err)
 		}
-		return fmt.Errorf("wg-quick up failed: %w: %s", err, errMsg)
 	}
 
 	return nil
 }
 
+// upConfig returns the config to pass to wg-quick up. wg-quick can't decrypt
+// keys, so a config with encrypted keys is copied without them to a private
+// temp dir, and the decrypted config is returned for setting them once the
+// interface is up. cleanup removes the copy.
+func (s *Service) upConfig(name string) (config string, keys *Config, cleanup func(), err error) {
+	cleanup = func() {}
+
+	data, err := readConfigFile(s.FindConfigPath(name), name)
+	if err != nil || !strings.Contains(data, encryptedKeyPrefix) {
+		return name, nil, cleanup, err
+	}
+
+	keys, err = s.DecodeConfig([]byte(data))
+	if err != nil {
+		return "", nil, cleanup, err
+	}
+	if s.keySetter == nil {
+		return "", nil, cleanup, domain.NotSupported(entity.ErrCodeNotSupported, "config for %s has encrypted keys that can't be set", name)
+	}
+
+	stripped, err := rewriteKeys([]byte(data), func(value string) (string, error) {
+		if IsEncryptedKey(value) {
+			return "", nil
+		}
+		return value, nil
+	})
+	if err != nil {
+		return "", nil, cleanup, err
+	}
+
+	dir, err := os.MkdirTemp("", "wgrest-up-")
+	if err != nil {
+		return "", nil, cleanup, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+
+	// wg-quick takes the interface name from the file name
+	config = filepath.Join(dir, name+".conf")
+	if err := os.WriteFile(config, stripped, 0600); err != nil {
+		cleanup()
+		return "", nil, func() {}, err
+	}
+	return config, keys, cleanup, nil
+}
+
 // Down brings down a WireGuard interface using wg-quick.
 // Note: wg-quick typically requires root/sudo.
-func (s *Service) Down(name string) error {
+func (s *Service) Down(ctx context.Context, name string) error {
+	return runWgQuick(ctx, "down", name, name)
+}
+
+// runWgQuick runs wg-quick up or down for the device name with config, which
+// is either the device name or a config file path. It is killed when ctx is
+// done or after commandTimeout.
+func runWgQuick(ctx context.Context, action, name, config string) error {
 	// Use timeout to prevent indefinite waiting for sudo password
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	cmdCtx, cancel := context.WithTimeout(ctx, commandTimeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "wg-quick", "down", name)
+	cmd := exec.CommandContext(cmdCtx, "wg-quick", action, config)
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
@@ -273,22 +526,42 @@ func (s *Service) Down(name string) error {
 		if errMsg == "" {
 			errMsg = stdout.String()
 		}
-		// Check for context timeout (usually means waiting for sudo)
-		if ctx.Err() == context.DeadlineExceeded {
-			return fmt.Errorf("wg-quick down timed out (likely waiting for sudo password): run wgrest as root")
+		// The caller gave up, e.g. the request timed out
+		if ctx.Err() != nil {
+			return fmt.Errorf("wg-quick %s %s: %w", action, name, ctx.Err())
 		}
-		// Check for permission errors
-		if strings.Contains(errMsg, "must be run as root") ||
-			strings.Contains(errMsg, "Permission denied") ||
-			strings.Contains(errMsg, "Operation not permitted") {
-			return fmt.Errorf("wg-quick down requires root privileges: %s", errMsg)
+		// Check for context timeout (usually means waiting for sudo)
+		if cmdCtx.Err() == context.DeadlineExceeded {
+			return domain.BackendUnavailable(entity.ErrCodeBackendUnavailable,
+				"wg-quick %s timed out (likely waiting for sudo password): run wgrest as root", action)
 		}
-		return fmt.Errorf("wg-quick down failed: %w: %s", err, errMsg)
+		return commandError("wg-quick "+action, name, err, errMsg)
 	}
 
 	return nil
 }
 
+// commandError converts a failed wg/wg-quick invocation into a domain error.
+func commandError(command, name string, err error, output string) error {
+	output = strings.TrimSpace(output)
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		return domain.BackendUnavailable(entity.ErrCodeBackendUnavailable, "%s failed: %w", command, err)
+	case strings.Contains(output, "must be run as root") ||
+		strings.Contains(output, "Permission denied") ||
+		strings.Contains(output, "Operation not permitted"):
+		return domain.Permission(entity.ErrCodePermissionDenied, "%s requires root privileges: %s", command, output)
+	case strings.Contains(output, "does not exist") ||
+		strings.Contains(output, "No such device") ||
+		strings.Contains(output, "is not a WireGuard interface"):
+		return domain.NotFound(entity.ErrCodeDeviceNotFound, "%s failed for %s: %s", command, name, output)
+	case strings.Contains(output, "already exists"):
+		return domain.Conflict(entity.ErrCodeConflict, "%s failed for %s: %s", command, name, output)
+	default:
+		return fmt.Errorf("%s failed: %w: %s", command, err, output)
+	}
+}
+
 // GetPlatform returns the current platform (linux, darwin, freebsd).
 func GetPlatform() string {
 	return runtime.GOOS
@@ -300,7 +573,7 @@ func (s *Service) buildConfig(device *entity.Device, peers []entity.Peer) string
 	b.WriteString("[Interface]\n")
 
 	if device.PrivateKey != "" {
-		b.WriteString(fmt.Sprintf("PrivateKey = %s\n", device.PrivateKey))
+		b.WriteString(fmt.Sprintf("PrivateKey = %s\n", s.sealKey(device.PrivateKey)))
 	}
 
 	if device.ListenPort > 0 {
@@ -349,7 +622,7 @@ func (s *Service) buildConfig(device *entity.Device, peers []entity.Peer) string
 		b.WriteString(fmt.Sprintf("PublicKey = %s\n", peer.PublicKey))
 
 		if peer.PresharedKey != "" {
-			b.WriteString(fmt.Sprintf("PresharedKey = %s\n", peer.PresharedKey))
+			b.WriteString(fmt.Sprintf("PresharedKey = %s\n", s.sealKey(peer.PresharedKey)))
 		}
 
 		if len(peer.AllowedIPs) > 0 {
@@ -360,8 +633,9 @@ func (s *Service) buildConfig(device *entity.Device, peers []entity.Peer) string
 			b.WriteString(fmt.Sprintf("Endpoint = %s\n", peer.Endpoint))
 		}
 
-		if peer.PersistentKeepaliveInterval != "" && peer.PersistentKeepaliveInterval != "0s" {
-			b.WriteString(fmt.Sprintf("PersistentKeepalive = %s\n", peer.PersistentKeepaliveInterval))
+		// wg expects whole seconds, not a duration such as 25s
+		if keepalive, err := time.ParseDuration(peer.PersistentKeepaliveInterval); err == nil && keepalive > 0 {
+			b.WriteString(fmt.Sprintf("PersistentKeepalive = %d\n", int(keepalive.Seconds())))
 		}
 	}
 
@@ -165,7 +165,7 @@ func TestBuildConfig(t *testing.T) {
 	assert.Contains(t, config, "PublicKey = peerPublicKey")
 	assert.Contains(t, config, "AllowedIPs = 10.0.0.2/32")
 	assert.Contains(t, config, "Endpoint = 192.168.1.1:51820")
-	assert.Contains(t, config, "PersistentKeepalive = 25s")
+	assert.Contains(t, config, "PersistentKeepalive = 25\n")
 }
 
 func TestGetPlatform(t *testing.T) {
@@ -260,6 +260,73 @@ func TestListConfigDevices_MultipleDirs(t *testing.T) {
 	assert.Contains(t, devices, "wg1")
 }
 
+func TestConfigFiles(t *testing.T) {
+	tmpDir1 := t.TempDir()
+	tmpDir2 := t.TempDir()
+
+	err := os.WriteFile(tmpDir1+"/wg0.conf", []byte("[Interface]\n"), 0600)
+	require.NoError(t, err)
+	err = os.WriteFile(tmpDir1+"/notes.txt", nil, 0600)
+	require.NoError(t, err)
+	// Shadowed files are listed too
+	err = os.WriteFile(tmpDir2+"/wg0.conf", []byte("[Interface]\n"), 0600)
+	require.NoError(t, err)
+
+	svc := &Service{configDirs: []string{tmpDir1, tmpDir2, tmpDir2 + "/missing"}}
+	assert.Equal(t, []ConfigFile{
+		{Dir: tmpDir1, Name: "wg0"},
+		{Dir: tmpDir2, Name: "wg0"},
+	}, svc.ConfigFiles())
+}
+
+func TestWriteConfigIn(t *testing.T) {
+	tmpDir1 := t.TempDir()
+	tmpDir2 := t.TempDir() + "/nested"
+
+	svc := &Service{configDirs: []string{tmpDir1, tmpDir2}}
+	require.NoError(t, svc.WriteConfigIn(tmpDir2, "wg0", []byte("[Interface]\nMTU = 1420\n")))
+
+	data, err := svc.ReadConfigIn(tmpDir2, "wg0")
+	require.NoError(t, err)
+	assert.Equal(t, "[Interface]\nMTU = 1420\n", data)
+
+	// Nothing was written to the first directory
+	data, err = svc.ReadConfigIn(tmpDir1, "wg0")
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestSaveRunningConfig(t *testing.T) {
+	tmpDir1 := t.TempDir()
+	tmpDir2 := t.TempDir()
+
+	// The existing config in the second directory has wg-quick options
+	existing := "[Interface]\nPrivateKey = old\nAddress = 10.0.0.1/24\nDNS = 1.1.1.1\nMTU = 1420\nPostUp = iptables -A FORWARD -i %i -j ACCEPT\n"
+	require.NoError(t, os.WriteFile(tmpDir2+"/wg0.conf", []byte(existing), 0600))
+
+	svc := &Service{configDirs: []string{tmpDir1, tmpDir2}}
+	device := &entity.Device{
+		Name:       "wg0",
+		PrivateKey: "new",
+		ListenPort: 51820,
+		Addresses:  []string{"192.168.1.1/24"},
+	}
+	peers := []entity.Peer{{PublicKey: "peer1", AllowedIPs: []string{"10.0.0.2/32"}, PersistentKeepaliveInterval: "0s"}}
+	require.NoError(t, svc.SaveRunningConfig(device, peers))
+
+	data, err := svc.ReadConfigIn(tmpDir2, "wg0")
+	require.NoError(t, err)
+	assert.Equal(t, "[Interface]\nPrivateKey = new\nListenPort = 51820\nAddress = 10.0.0.1/24\nDNS = 1.1.1.1\nMTU = 1420\n"+
+		"PostUp = iptables -A FORWARD -i %i -j ACCEPT\n\n[Peer]\nPublicKey = peer1\nAllowedIPs = 10.0.0.2/32\n", data)
+
+	// Without a config file the interface addresses are written
+	device.Name = "wg1"
+	require.NoError(t, svc.SaveRunningConfig(device, nil))
+	data, err = svc.ReadConfigIn(tmpDir1, "wg1")
+	require.NoError(t, err)
+	assert.Contains(t, data, "Address = 192.168.1.1/24\n")
+}
+
 func TestNewService_EmptyDirs(t *testing.T) {
 	_, err := NewService([]string{})
 	assert.Error(t, err)
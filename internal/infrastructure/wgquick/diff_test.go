@@ -0,0 +1,67 @@
+package wgquick
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff_Equal(t *testing.T) {
+	assert.Empty(t, Diff("wg0", "a\nb\n", "a\nb\n"))
+}
+
+func TestDiff_NewFile(t *testing.T) {
+	diff := Diff("wg0", "", "[Interface]\nListenPort = 51820\n")
+
+	assert.Equal(t, `--- a/wg0.conf
++++ b/wg0.conf
+@@ -0,0 +1,2 @@
++[Interface]
++ListenPort = 51820
+`, diff)
+}
+
+func TestDiff_ChangedLine(t *testing.T) {
+	before := "[Interface]\nListenPort = 51820\nMTU = 1420\n"
+	after := "[Interface]\nListenPort = 51821\nMTU = 1420\n"
+
+	assert.Equal(t, `--- a/wg0.conf
++++ b/wg0.conf
+@@ -1,3 +1,3 @@
+ [Interface]
+-ListenPort = 51820
++ListenPort = 51821
+ MTU = 1420
+`, Diff("wg0", before, after))
+}
+
+func TestDiff_SeparateHunks(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	before := strings.Join(lines, "\n") + "\n"
+
+	lines[1] = "changed 2"
+	lines[18] = "changed 19"
+	after := strings.Join(lines, "\n") + "\n"
+
+	diff := Diff("wg0", before, after)
+
+	assert.Equal(t, 2, strings.Count(diff, "@@ -"))
+	assert.Contains(t, diff, "@@ -1,5 +1,5 @@\n line 1\n-line 2\n+changed 2\n line 3\n")
+	assert.Contains(t, diff, "@@ -16,5 +16,5 @@\n line 16\n line 17\n line 18\n-line 19\n+changed 19\n line 20\n")
+}
+
+func TestDiff_RemovedPeer(t *testing.T) {
+	before := "[Interface]\n\n[Peer]\nPublicKey = a\n\n[Peer]\nPublicKey = b\n"
+	after := "[Interface]\n\n[Peer]\nPublicKey = b\n"
+
+	diff := Diff("wg0", before, after)
+
+	assert.Contains(t, diff, "-PublicKey = a\n")
+	assert.NotContains(t, diff, "-PublicKey = b\n")
+	assert.Equal(t, 3, strings.Count(diff, "\n-"))
+}
@@ -0,0 +1,275 @@
+package wgquick
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+// revisionIDFormat formats revision times as sortable IDs.
+const revisionIDFormat = "20060102T150405.000000000Z"
+
+// History keeps revisions of the config files written by a Service.
+type History struct {
+	dir   string
+	limit int
+	now   func() time.Time
+}
+
+// NewHistory creates a history keeping the newest limit revisions of each
+// device in dir.
+func NewHistory(dir string, limit int) *History {
+	return &History{dir: dir, limit: limit, now: time.Now}
+}
+
+// SetHistory makes the service keep a revision of every config it writes.
+func (s *Service) SetHistory(h *History) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.history = h
+}
+
+// Revisions returns the config revisions of a device, newest first. The
+// revisions of a device without a config file, such as a deleted one, are
+// listed with none of them current.
+func (s *Service) Revisions(name string) ([]entity.ConfigRevision, error) {
+	if s.history == nil {
+		return nil, historyDisabled()
+	}
+
+	revisions, err := s.history.list(name)
+	if err != nil {
+		return nil, err
+	}
+
+	path := s.FindConfigPath(name)
+	current, err := readConfigFile(path, name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(path)
+	hasCurrent := err == nil
+
+	result := make([]entity.ConfigRevision, len(revisions))
+	for i, r := range revisions {
+		data, err := s.history.read(name, r.id)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		result[len(revisions)-1-i] = entity.ConfigRevision{
+			ID:        r.id,
+			CreatedAt: r.createdAt,
+			Size:      int64(len(data)),
+			SHA256:    hex.EncodeToString(sum[:]),
+			Current:   hasCurrent && string(data) == current,
+		}
+	}
+	return result, nil
+}
+
+// ReadRevision returns the contents of a config revision.
+func (s *Service) ReadRevision(name, id string) (string, error) {
+	if s.history == nil {
+		return "", historyDisabled()
+	}
+
+	if _, err := time.Parse(revisionIDFormat, id); err != nil {
+		return "", revisionNotFound(name, id)
+	}
+
+	data, err := s.history.read(name, id)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", revisionNotFound(name, id)
+	}
+	return string(data), err
+}
+
+// revision is a stored config revision.
+type revision struct {
+	id        string
+	createdAt time.Time
+}
+
+// deviceDir returns the directory holding the revisions of a device.
+func (h *History) deviceDir(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", domain.Validation(entity.ErrCodeInvalidRequest, "invalid device name %q", name)
+	}
+	return filepath.Join(h.dir, name), nil
+}
+
+// list returns the revisions of a device, oldest first.
+func (h *History) list(name string) ([]revision, error) {
+	dir, err := h.deviceDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var revisions []revision
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".conf")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		createdAt, err := time.Parse(revisionIDFormat, id)
+		if err != nil {
+			continue
+		}
+		revisions = append(revisions, revision{id: id, createdAt: createdAt})
+	}
+
+	// IDs sort chronologically
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].id < revisions[j].id
+	})
+	return revisions, nil
+}
+
+func (h *History) read(name, id string) ([]byte, error) {
+	dir, err := h.deviceDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(dir, id+".conf"))
+}
+
+// record stores data as the newest revision of a device unless it matches the
+// newest one. previous is the config it replaced, which is stored first if
+// the device has no revisions yet, so the config wgrest first overwrote can be
+// restored.
+func (h *History) record(name string, previous []byte, previousTime time.Time, data []byte) error {
+	if h.limit <= 0 {
+		return nil
+	}
+
+	revisions, err := h.list(name)
+	if err != nil {
+		return err
+	}
+
+	if len(revisions) == 0 {
+		if len(previous) > 0 && !bytes.Equal(previous, data) {
+			if err := h.write(name, previousTime, previous); err != nil {
+				return err
+			}
+		}
+	} else {
+		latest, err := h.read(name, revisions[len(revisions)-1].id)
+		if err == nil && bytes.Equal(latest, data) {
+			return nil
+		}
+	}
+
+	if err := h.write(name, h.now(), data); err != nil {
+		return err
+	}
+	return h.prune(name)
+}
+
+func (h *History) write(name string, at time.Time, data []byte) error {
+	dir, err := h.deviceDir(name)
+	if err != nil {
+		return err
+	}
+	if err := ensureDir(dir); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, at.UTC().Format(revisionIDFormat)+".conf")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write config revision: %w", err)
+	}
+	return nil
+}
+
+// rewrite replaces every revision of every device with what fn returns for
+// it. Revisions keep their IDs.
+func (h *History) rewrite(fn func(data []byte) ([]byte, error)) error {
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		revisions, err := h.list(name)
+		if err != nil {
+			return err
+		}
+		for _, r := range revisions {
+			path := filepath.Join(h.dir, name, r.id+".conf")
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			rewritten, err := fn(data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if bytes.Equal(rewritten, data) {
+				continue
+			}
+			if err := writeSynced(path+".tmp", rewritten); err != nil {
+				os.Remove(path + ".tmp")
+				return fmt.Errorf("failed to write config revision: %w", err)
+			}
+			if err := os.Rename(path+".tmp", path); err != nil {
+				os.Remove(path + ".tmp")
+				return fmt.Errorf("failed to rename config revision: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// prune removes all but the newest limit revisions of a device.
+func (h *History) prune(name string) error {
+	revisions, err := h.list(name)
+	if err != nil || len(revisions) <= h.limit {
+		return err
+	}
+
+	dir, _ := h.deviceDir(name)
+	var lastErr error
+	for _, r := range revisions[:len(revisions)-h.limit] {
+		if err := os.Remove(filepath.Join(dir, r.id+".conf")); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func historyDisabled() error {
+	return domain.NotSupported(entity.ErrCodeNotSupported, "config history is disabled")
+}
+
+func revisionNotFound(name, id string) error {
+	return domain.NotFound(entity.ErrCodeRevisionNotFound, "revision %s of %s not found", id, name)
+}
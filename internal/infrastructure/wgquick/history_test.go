@@ -0,0 +1,106 @@
+package wgquick
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+// newHistoryService returns a service keeping limit revisions, with a clock
+// advancing a second per revision.
+func newHistoryService(t *testing.T, limit int) (*Service, string) {
+	configDir := t.TempDir()
+	svc, err := NewService([]string{configDir})
+	require.NoError(t, err)
+
+	h := NewHistory(t.TempDir(), limit)
+	at := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	h.now = func() time.Time {
+		at = at.Add(time.Second)
+		return at
+	}
+	svc.SetHistory(h)
+
+	return svc, configDir
+}
+
+func TestHistory_Record(t *testing.T) {
+	svc, configDir := newHistoryService(t, 3)
+
+	// The config wgrest first overwrites is kept as the oldest revision
+	configPath := filepath.Join(configDir, "wg0.conf")
+	require.NoError(t, os.WriteFile(configPath, []byte("[Interface]\nMTU = 1280\n"), 0600))
+	modTime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(configPath, modTime, modTime))
+
+	require.NoError(t, svc.WriteConfigIn(configDir, "wg0", []byte("[Interface]\nMTU = 1420\n")))
+	// Writing the same config again doesn't add a revision
+	require.NoError(t, svc.WriteConfigIn(configDir, "wg0", []byte("[Interface]\nMTU = 1420\n")))
+
+	revisions, err := svc.Revisions("wg0")
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.True(t, revisions[0].Current)
+	assert.False(t, revisions[1].Current)
+	assert.True(t, modTime.Equal(revisions[1].CreatedAt))
+
+	data, err := svc.ReadRevision("wg0", revisions[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "[Interface]\nMTU = 1280\n", data)
+
+	// Only the newest revisions are kept
+	require.NoError(t, svc.WriteConfigIn(configDir, "wg0", []byte("[Interface]\nMTU = 1400\n")))
+	require.NoError(t, svc.WriteConfigIn(configDir, "wg0", []byte("[Interface]\nMTU = 1380\n")))
+
+	revisions, err = svc.Revisions("wg0")
+	require.NoError(t, err)
+	require.Len(t, revisions, 3)
+	data, err = svc.ReadRevision("wg0", revisions[2].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "[Interface]\nMTU = 1420\n", data)
+}
+
+func TestHistory_RevisionsOfDeletedDevice(t *testing.T) {
+	svc, configDir := newHistoryService(t, 3)
+
+	require.NoError(t, svc.WriteConfigIn(configDir, "wg0", []byte("")))
+	require.NoError(t, svc.WriteConfigIn(configDir, "wg0", []byte("[Interface]\nMTU = 1420\n")))
+	require.NoError(t, os.Remove(filepath.Join(configDir, "wg0.conf")))
+
+	revisions, err := svc.Revisions("wg0")
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.False(t, revisions[0].Current)
+	assert.False(t, revisions[1].Current)
+}
+
+func TestHistory_ReadRevisionNotFound(t *testing.T) {
+	svc, _ := newHistoryService(t, 3)
+
+	for _, id := range []string{"20261019T120000.000000000Z", "../wg1/x", ""} {
+		_, err := svc.ReadRevision("wg0", id)
+		assert.ErrorIs(t, err, domain.ErrNotFound, id)
+		assert.Equal(t, entity.ErrCodeRevisionNotFound, domain.Code(err))
+	}
+
+	_, err := svc.Revisions("..")
+	assert.ErrorIs(t, err, domain.ErrValidation)
+}
+
+func TestHistory_Disabled(t *testing.T) {
+	svc, err := NewService([]string{t.TempDir()})
+	require.NoError(t, err)
+
+	_, err = svc.Revisions("wg0")
+	assert.ErrorIs(t, err, domain.ErrNotSupported)
+
+	_, err = svc.ReadRevision("wg0", "20261019T120000.000000000Z")
+	assert.ErrorIs(t, err, domain.ErrNotSupported)
+}
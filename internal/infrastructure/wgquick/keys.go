@@ -0,0 +1,299 @@
+package wgquick
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+// encryptedKeyPrefix marks an encrypted key in a config file. Base64 has no
+// colons, so it can't be mistaken for a plaintext key.
+const encryptedKeyPrefix = "wgrest:v1:"
+
+// MasterKeySize is the size of the key encrypting private and preshared keys.
+const MasterKeySize = 32
+
+// KeyCipher encrypts the private and preshared keys written to config files.
+// Encryption is deterministic: the nonce is derived from the key being
+// encrypted, so rewriting an unchanged config yields the same file and config
+// hashes, diffs and revisions only change with the keys themselves.
+type KeyCipher struct {
+	aead     cipher.AEAD
+	nonceKey []byte
+}
+
+// NewKeyCipher creates a key cipher from a MasterKeySize byte master key.
+func NewKeyCipher(masterKey []byte) (*KeyCipher, error) {
+	if len(masterKey) != MasterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", MasterKeySize, len(masterKey))
+	}
+
+	block, err := aes.NewCipher(deriveKey(masterKey, "wgrest key encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyCipher{aead: aead, nonceKey: deriveKey(masterKey, "wgrest key nonce")}, nil
+}
+
+// ParseMasterKey decodes a base64 master key, as read from a file,
+// environment variable or systemd credential.
+func ParseMasterKey(data []byte) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	if len(key) != MasterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", MasterKeySize, len(key))
+	}
+	return key, nil
+}
+
+// GenerateMasterKey returns a new random base64 master key.
+func GenerateMasterKey() (string, error) {
+	key := make([]byte, MasterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// IsEncryptedKey reports whether a config file value is an encrypted key.
+func IsEncryptedKey(value string) bool {
+	return strings.HasPrefix(value, encryptedKeyPrefix)
+}
+
+// Seal encrypts a key. Empty and already encrypted keys are returned as is.
+func (c *KeyCipher) Seal(key string) string {
+	if key == "" || IsEncryptedKey(key) {
+		return key
+	}
+
+	mac := hmac.New(sha256.New, c.nonceKey)
+	mac.Write([]byte(key))
+	nonce := mac.Sum(nil)[:c.aead.NonceSize()]
+
+	sealed := c.aead.Seal(nonce, nonce, []byte(key), nil)
+	return encryptedKeyPrefix + base64.StdEncoding.EncodeToString(sealed)
+}
+
+// Open decrypts a key. Plaintext keys are returned as is, so configs that
+// haven't been migrated yet keep working.
+func (c *KeyCipher) Open(value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, encryptedKeyPrefix)
+	if !ok {
+		return value, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return "", domain.BackendUnavailable(entity.ErrCodeEncryptedKey, "malformed encrypted key")
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	key, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", domain.BackendUnavailable(entity.ErrCodeEncryptedKey, "failed to decrypt key: wrong master key or corrupted config")
+	}
+	return string(key), nil
+}
+
+// deriveKey derives a purpose specific key from the master key.
+func deriveKey(masterKey []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// KeySetter sets the private and preshared keys of a running interface.
+// wg-quick brings encrypted configs up without their keys, which are then
+// set through it.
+type KeySetter interface {
+	SetKeys(ctx context.Context, name, privateKey string, presharedKeys map[string]string) error
+}
+
+// SetKeyCipher makes the service encrypt the keys of the configs it writes
+// and decrypt them when reading. keys sets the decrypted keys when bringing
+// up a device.
+func (s *Service) SetKeyCipher(c *KeyCipher, keys KeySetter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keyCipher = c
+	s.keySetter = keys
+}
+
+// DecodeConfig parses config file contents, decrypting their keys.
+func (s *Service) DecodeConfig(data []byte) (*Config, error) {
+	cfg, err := ParseConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.decryptConfig(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// decryptConfig decrypts the keys of a parsed config in place.
+func (s *Service) decryptConfig(cfg *Config) error {
+	var err error
+	if cfg.PrivateKey, err = s.openKey(cfg.PrivateKey); err != nil {
+		return err
+	}
+	for i := range cfg.Peers {
+		if cfg.Peers[i].PresharedKey, err = s.openKey(cfg.Peers[i].PresharedKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) openKey(value string) (string, error) {
+	if s.keyCipher == nil {
+		if IsEncryptedKey(value) {
+			return "", domain.BackendUnavailable(entity.ErrCodeEncryptedKey, "config has encrypted keys but no master key is configured")
+		}
+		return value, nil
+	}
+	return s.keyCipher.Open(value)
+}
+
+// sealKey encrypts a key written to a config file if encryption is enabled.
+func (s *Service) sealKey(key string) string {
+	if s.keyCipher == nil {
+		return key
+	}
+	return s.keyCipher.Seal(key)
+}
+
+// sealConfig returns config file contents with their plaintext keys encrypted
+// if encryption is enabled, so configs replaced before migrating don't end
+// up in the history with plaintext keys.
+func (s *Service) sealConfig(data []byte) []byte {
+	if s.keyCipher == nil || data == nil {
+		return data
+	}
+	sealed, err := rewriteKeys(data, func(value string) (string, error) {
+		return s.keyCipher.Seal(value), nil
+	})
+	if err != nil {
+		return data
+	}
+	return sealed
+}
+
+// EncryptKeys encrypts the plaintext keys of every config file and its
+// revisions and returns the files that changed. Everything else in the files
+// is kept as is.
+func (s *Service) EncryptKeys() ([]ConfigFile, error) {
+	if s.keyCipher == nil {
+		return nil, fmt.Errorf("no master key configured")
+	}
+	return s.rewriteConfigKeys(func(value string) (string, error) {
+		return s.keyCipher.Seal(value), nil
+	})
+}
+
+// DecryptKeys writes the keys of every config file and its revisions back in
+// plaintext and returns the files that changed.
+func (s *Service) DecryptKeys() ([]ConfigFile, error) {
+	if s.keyCipher == nil {
+		return nil, fmt.Errorf("no master key configured")
+	}
+	return s.rewriteConfigKeys(s.keyCipher.Open)
+}
+
+func (s *Service) rewriteConfigKeys(fn func(value string) (string, error)) ([]ConfigFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changed []ConfigFile
+	for _, f := range s.ConfigFiles() {
+		rewritten := false
+		err := s.editConfigIn(f.Dir, f.Name, func(previous []byte) ([]byte, error) {
+			if previous == nil {
+				return nil, nil
+			}
+			data, err := rewriteKeys(previous, fn)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(data, previous) {
+				return nil, nil
+			}
+			rewritten = true
+			return data, nil
+		})
+		if err != nil {
+			return changed, fmt.Errorf("%s: %w", filepath.Join(f.Dir, f.Name+".conf"), err)
+		}
+		if rewritten {
+			changed = append(changed, f)
+		}
+	}
+
+	// Revisions, including those of deleted devices, would otherwise keep
+	// the keys as they were and could be rolled back to
+	if s.history != nil {
+		if err := s.history.rewrite(func(data []byte) ([]byte, error) {
+			return rewriteKeys(data, fn)
+		}); err != nil {
+			return changed, fmt.Errorf("config history: %w", err)
+		}
+	}
+	return changed, nil
+}
+
+// rewriteKeys returns config file contents with the value of every
+// PrivateKey and PresharedKey line replaced by fn. Lines for which fn returns
+// "" are dropped. Unless a value changes, data is returned as is.
+func rewriteKeys(data []byte, fn func(value string) (string, error)) ([]byte, error) {
+	var b bytes.Buffer
+	modified := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if key, value, ok := strings.Cut(line, "="); ok {
+			switch strings.ToLower(strings.TrimSpace(key)) {
+			case "privatekey", "presharedkey":
+				value = strings.TrimSpace(value)
+				replaced, err := fn(value)
+				if err != nil {
+					return nil, err
+				}
+				if replaced == "" {
+					modified = true
+					continue
+				}
+				if replaced != value {
+					modified = true
+					line = strings.TrimRight(key, " \t") + " = " + replaced
+				}
+			}
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !modified {
+		return data, nil
+	}
+	return b.Bytes(), nil
+}
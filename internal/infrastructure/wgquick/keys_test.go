@@ -0,0 +1,237 @@
+package wgquick
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+const (
+	testPrivateKey   = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	testPublicKey    = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+	testPresharedKey = "FpCyhws9cxwWoV4xELtfJvjJN+zQVRPISllRWgeopVE="
+)
+
+func newTestKeyCipher(t *testing.T) *KeyCipher {
+	encoded, err := GenerateMasterKey()
+	require.NoError(t, err)
+	masterKey, err := ParseMasterKey([]byte(encoded + "\n"))
+	require.NoError(t, err)
+	c, err := NewKeyCipher(masterKey)
+	require.NoError(t, err)
+	return c
+}
+
+func TestKeyCipher(t *testing.T) {
+	c := newTestKeyCipher(t)
+
+	sealed := c.Seal(testPrivateKey)
+	assert.True(t, IsEncryptedKey(sealed))
+	assert.NotContains(t, sealed, testPrivateKey)
+
+	// Sealing is deterministic and idempotent
+	assert.Equal(t, sealed, c.Seal(testPrivateKey))
+	assert.Equal(t, sealed, c.Seal(sealed))
+	assert.Empty(t, c.Seal(""))
+
+	key, err := c.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, testPrivateKey, key)
+
+	// Plaintext keys are passed through
+	key, err = c.Open(testPresharedKey)
+	require.NoError(t, err)
+	assert.Equal(t, testPresharedKey, key)
+
+	// Another master key can't open it
+	_, err = newTestKeyCipher(t).Open(sealed)
+	assert.Equal(t, entity.ErrCodeEncryptedKey, domain.Code(err))
+
+	_, err = c.Open(encryptedKeyPrefix + "not base64")
+	assert.Equal(t, entity.ErrCodeEncryptedKey, domain.Code(err))
+}
+
+func TestParseMasterKey(t *testing.T) {
+	_, err := ParseMasterKey([]byte("not base64"))
+	assert.Error(t, err)
+
+	_, err = ParseMasterKey([]byte("c2hvcnQ="))
+	assert.Error(t, err)
+
+	_, err = NewKeyCipher([]byte("short"))
+	assert.Error(t, err)
+}
+
+func TestService_EncryptedConfig(t *testing.T) {
+	configDir := t.TempDir()
+	svc, err := NewService([]string{configDir})
+	require.NoError(t, err)
+	svc.SetKeyCipher(newTestKeyCipher(t), nil)
+
+	device := &entity.Device{Name: "wg0", PrivateKey: testPrivateKey, Addresses: []string{"10.0.0.1/24"}}
+	peers := []entity.Peer{{PublicKey: testPublicKey, PresharedKey: testPresharedKey, AllowedIPs: []string{"10.0.0.2/32"}}}
+	require.NoError(t, svc.SaveConfig(device, peers))
+
+	// Keys are encrypted on disk, rendering is stable
+	data, err := svc.ReadConfig("wg0")
+	require.NoError(t, err)
+	assert.NotContains(t, data, testPrivateKey)
+	assert.NotContains(t, data, testPresharedKey)
+	assert.Contains(t, data, "PublicKey = "+testPublicKey+"\n")
+	assert.Equal(t, data, svc.RenderConfig(device, peers))
+
+	// And decrypted when read
+	cfg, err := svc.LoadConfig("wg0")
+	require.NoError(t, err)
+	assert.Equal(t, testPrivateKey, cfg.PrivateKey)
+	assert.Equal(t, testPresharedKey, cfg.Peers[0].PresharedKey)
+
+	cfg, err = svc.DecodeConfig([]byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, testPrivateKey, cfg.PrivateKey)
+
+	require.NoError(t, svc.EditConfig("wg0", func(cfg *Config) (string, error) {
+		assert.Equal(t, testPrivateKey, cfg.PrivateKey)
+		return data, nil
+	}))
+
+	// Without the master key the config can't be read
+	plain, err := NewService([]string{configDir})
+	require.NoError(t, err)
+	_, err = plain.LoadConfig("wg0")
+	assert.Equal(t, entity.ErrCodeEncryptedKey, domain.Code(err))
+}
+
+func TestService_EncryptKeys(t *testing.T) {
+	configDir := t.TempDir()
+	original := "[Interface]\n# keep me\nPrivateKey = " + testPrivateKey + "\nPostUp = echo up\n\n" +
+		"[Peer]\nPublicKey = " + testPublicKey + "\nPresharedKey = " + testPresharedKey + "\nAllowedIPs = 10.0.0.2/32\n"
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "wg0.conf"), []byte(original), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "wg1.conf"), []byte("[Interface]\nListenPort = 51820\n"), 0600))
+
+	svc, err := NewService([]string{configDir})
+	require.NoError(t, err)
+	_, err = svc.EncryptKeys()
+	assert.Error(t, err)
+
+	svc.SetKeyCipher(newTestKeyCipher(t), nil)
+	changed, err := svc.EncryptKeys()
+	require.NoError(t, err)
+	assert.Equal(t, []ConfigFile{{Dir: configDir, Name: "wg0"}}, changed)
+
+	data, err := svc.ReadConfig("wg0")
+	require.NoError(t, err)
+	assert.NotContains(t, data, testPrivateKey)
+	assert.Contains(t, data, "# keep me\n")
+	assert.Contains(t, data, "PostUp = echo up\n")
+
+	// Encrypting again changes nothing
+	changed, err = svc.EncryptKeys()
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+
+	changed, err = svc.DecryptKeys()
+	require.NoError(t, err)
+	assert.Len(t, changed, 1)
+	data, err = svc.ReadConfig("wg0")
+	require.NoError(t, err)
+	assert.Equal(t, original, data)
+}
+
+func TestService_EncryptKeys_History(t *testing.T) {
+	svc, configDir := newHistoryService(t, 10)
+	historyDir := svc.history.dir
+	plaintext := "[Interface]\nPrivateKey = " + testPrivateKey + "\n\n[Peer]\nPublicKey = " + testPublicKey +
+		"\nPresharedKey = " + testPresharedKey + "\n"
+
+	// Revisions recorded before encryption was enabled
+	require.NoError(t, svc.WriteConfigIn(configDir, "wg0", []byte(plaintext)))
+	require.NoError(t, svc.WriteConfigIn(configDir, "wg0", []byte(plaintext+"AllowedIPs = 10.0.0.2/32\n")))
+
+	// And a plaintext config overwritten for the first time after
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "wg1.conf"), []byte(plaintext), 0600))
+
+	svc.SetKeyCipher(newTestKeyCipher(t), nil)
+	_, err := svc.EncryptKeys()
+	require.NoError(t, err)
+	require.NoError(t, svc.SaveConfig(&entity.Device{Name: "wg1", PrivateKey: testPrivateKey}, nil))
+
+	revisions, err := svc.Revisions("wg1")
+	require.NoError(t, err)
+	assert.Len(t, revisions, 2)
+
+	err = filepath.WalkDir(historyDir, func(path string, d os.DirEntry, err error) error {
+		require.NoError(t, err)
+		if d.IsDir() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), testPrivateKey, path)
+		assert.NotContains(t, string(data), testPresharedKey, path)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Rolled back revisions still decrypt
+	revisions, err = svc.Revisions("wg0")
+	require.NoError(t, err)
+	data, err := svc.ReadRevision("wg0", revisions[len(revisions)-1].ID)
+	require.NoError(t, err)
+	cfg, err := svc.DecodeConfig([]byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, testPrivateKey, cfg.PrivateKey)
+}
+
+func TestService_UpConfig(t *testing.T) {
+	configDir := t.TempDir()
+	svc, err := NewService([]string{configDir})
+	require.NoError(t, err)
+
+	// Plaintext configs are brought up by name
+	require.NoError(t, svc.SaveConfig(&entity.Device{Name: "wg0", PrivateKey: testPrivateKey}, nil))
+	config, keys, cleanup, err := svc.upConfig("wg0")
+	require.NoError(t, err)
+	defer cleanup()
+	assert.Equal(t, "wg0", config)
+	assert.Nil(t, keys)
+
+	// Encrypted ones from a copy without their keys
+	svc.SetKeyCipher(newTestKeyCipher(t), nil)
+	peers := []entity.Peer{{PublicKey: testPublicKey, PresharedKey: testPresharedKey}}
+	require.NoError(t, svc.SaveConfig(&entity.Device{Name: "wg0", PrivateKey: testPrivateKey, PostUp: []string{"echo up"}}, peers))
+
+	_, _, _, err = svc.upConfig("wg0")
+	assert.Equal(t, entity.ErrCodeNotSupported, domain.Code(err))
+
+	svc.SetKeyCipher(svc.keyCipher, keySetterFunc(nil))
+	config, keys, cleanup, err = svc.upConfig("wg0")
+	require.NoError(t, err)
+	assert.Equal(t, "wg0.conf", filepath.Base(config))
+	require.NotNil(t, keys)
+	assert.Equal(t, testPrivateKey, keys.PrivateKey)
+	assert.Equal(t, testPresharedKey, keys.Peers[0].PresharedKey)
+
+	data, err := os.ReadFile(config)
+	require.NoError(t, err)
+	assert.Equal(t, "[Interface]\nPostUp = echo up\n\n[Peer]\nPublicKey = "+testPublicKey+"\n", string(data))
+	assert.False(t, strings.Contains(string(data), encryptedKeyPrefix))
+
+	cleanup()
+	_, err = os.Stat(filepath.Dir(config))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+type keySetterFunc func(ctx context.Context, name, privateKey string, presharedKeys map[string]string) error
+
+func (f keySetterFunc) SetKeys(ctx context.Context, name, privateKey string, presharedKeys map[string]string) error {
+	return f(ctx, name, privateKey, presharedKeys)
+}
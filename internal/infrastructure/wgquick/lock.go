@@ -0,0 +1,29 @@
+package wgquick
+
+import (
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+// How long to wait for a config lock held by another process.
+var (
+	lockTimeout       = 10 * time.Second
+	lockRetryInterval = 50 * time.Millisecond
+)
+
+var errLocked = errors.New("config is locked by another process")
+
+// lockConfig takes the advisory lock guarding the config file of a device in
+// dir against other processes. The lock file sits next to the config, so
+// wgrest instances and tools using flock(1) on it are serialized.
+func lockConfig(dir, name string) (func(), error) {
+	unlock, err := lockFile(filepath.Join(dir, "."+name+".conf.lock"))
+	if errors.Is(err, errLocked) {
+		return nil, domain.Conflict(entity.ErrCodeConfigLocked, "config for %s is locked by another process", name)
+	}
+	return unlock, err
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package wgquick
+
+// lockFile is a no-op on platforms without flock; the in-process mutex still
+// serializes writes.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
+
+// syncDir is a no-op on platforms that can't sync directories.
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,99 @@
+//go:build unix
+
+package wgquick
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+func TestEditConfig(t *testing.T) {
+	configDir := t.TempDir()
+	svc, err := NewService([]string{configDir})
+	require.NoError(t, err)
+
+	// Without a file fn gets nil
+	err = svc.EditConfig("wg0", func(cfg *Config) (string, error) {
+		assert.Nil(t, cfg)
+		return "[Interface]\nListenPort = 51820\n", nil
+	})
+	require.NoError(t, err)
+
+	err = svc.EditConfig("wg0", func(cfg *Config) (string, error) {
+		require.NotNil(t, cfg)
+		assert.Equal(t, 51820, cfg.ListenPort)
+		return "[Interface]\nListenPort = 51821\n", nil
+	})
+	require.NoError(t, err)
+
+	data, err := svc.ReadConfig("wg0")
+	require.NoError(t, err)
+	assert.Equal(t, "[Interface]\nListenPort = 51821\n", data)
+
+	// No temp file is left behind
+	_, err = os.Stat(filepath.Join(configDir, "wg0.conf.tmp"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestEditConfig_ConcurrentModification(t *testing.T) {
+	configDir := t.TempDir()
+	svc, err := NewService([]string{configDir})
+	require.NoError(t, err)
+	configPath := filepath.Join(configDir, "wg0.conf")
+	require.NoError(t, os.WriteFile(configPath, []byte("[Interface]\nListenPort = 51820\n"), 0600))
+
+	// Another process, ignoring the lock, edits the file in the meantime
+	err = svc.EditConfig("wg0", func(cfg *Config) (string, error) {
+		require.NoError(t, os.WriteFile(configPath, []byte("[Interface]\nListenPort = 1\n"), 0600))
+		return "[Interface]\nListenPort = 51821\n", nil
+	})
+	assert.ErrorIs(t, err, domain.ErrConflict)
+	assert.Equal(t, entity.ErrCodeConfigModified, domain.Code(err))
+
+	// Their edit is kept
+	data, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "[Interface]\nListenPort = 1\n", string(data))
+
+	// A file created in the meantime is a modification too
+	require.NoError(t, os.Remove(configPath))
+	err = svc.EditConfig("wg0", func(cfg *Config) (string, error) {
+		require.NoError(t, os.WriteFile(configPath, nil, 0600))
+		return "[Interface]\n", nil
+	})
+	assert.Equal(t, entity.ErrCodeConfigModified, domain.Code(err))
+}
+
+func TestEditConfig_Locked(t *testing.T) {
+	timeout := lockTimeout
+	lockTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { lockTimeout = timeout })
+
+	configDir := t.TempDir()
+	svc, err := NewService([]string{configDir})
+	require.NoError(t, err)
+
+	// Another process holds the lock
+	unlock, err := lockFile(filepath.Join(configDir, ".wg0.conf.lock"))
+	require.NoError(t, err)
+
+	called := false
+	err = svc.EditConfig("wg0", func(cfg *Config) (string, error) {
+		called = true
+		return "", nil
+	})
+	assert.Equal(t, entity.ErrCodeConfigLocked, domain.Code(err))
+	assert.False(t, called)
+
+	// Once released the edit goes through
+	unlock()
+	require.NoError(t, svc.SaveConfig(&entity.Device{Name: "wg0"}, nil))
+}
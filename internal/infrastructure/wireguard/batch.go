@@ -0,0 +1,194 @@
+package wireguard
+
+import (
+	"context"
+	"net"
+	"slices"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+// PeerBatchItem is an operation of a peer batch and its outcome.
+type PeerBatchItem struct {
+	Op entity.PeerBatchOperation
+
+	// Peer is the created, updated or deleted peer
+	Peer *entity.Peer
+
+	// Err is why the operation failed; items that already have Err are not applied
+	Err error
+}
+
+// ApplyPeerBatch applies the operations of items to a device with a single
+// ConfigureDevice call, setting Peer or Err on each item. In atomic mode
+// nothing is applied if any item fails. It reports whether the batch was
+// applied; if the device rejects it, changes already made are rolled back.
+func (c *Client) ApplyPeerBatch(ctx context.Context, deviceName string, items []PeerBatchItem, atomic bool) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	d, err := c.ctrl.Device(resolveInterfaceName(deviceName))
+	if err != nil {
+		return false, deviceError(deviceName, err)
+	}
+
+	existing := make(map[wgtypes.Key]wgtypes.Peer, len(d.Peers))
+	for _, p := range d.Peers {
+		existing[p.PublicKey] = p
+	}
+
+	var (
+		peerCfgs []wgtypes.PeerConfig
+		updated  []int
+		seen     = make(map[wgtypes.Key]int, len(items))
+		failed   bool
+	)
+	for i := range items {
+		item := &items[i]
+		if item.Err == nil {
+			var cfgs []wgtypes.PeerConfig
+			cfgs, item.Peer, item.Err = planPeerOp(existing, item.Op)
+			if item.Err == nil {
+				key := cfgs[len(cfgs)-1].PublicKey
+				if first, ok := seen[key]; ok {
+					item.Err = domain.Conflict(entity.ErrCodeConflict, "peer %s is already changed by operation %d", key.String(), first)
+				} else {
+					seen[key] = i
+					peerCfgs = append(peerCfgs, cfgs...)
+				}
+			}
+		}
+
+		if item.Err != nil {
+			item.Peer = nil
+			failed = true
+		} else if item.Op.Op == entity.PeerBatchUpdate {
+			updated = append(updated, i)
+		}
+	}
+
+	if (failed && atomic) || len(peerCfgs) == 0 {
+		return false, nil
+	}
+
+	defer c.cache.invalidate(deviceName)
+	if err := c.ctrl.ConfigureDevice(d.Name, wgtypes.Config{Peers: peerCfgs}); err != nil {
+		// Large batches are split into several messages, so part of the
+		// batch may have been applied already
+		if after, derr := c.ctrl.Device(d.Name); derr == nil {
+			if restore := rollbackPeers(d.Peers, after.Peers); len(restore) > 0 {
+				_ = c.ctrl.ConfigureDevice(d.Name, wgtypes.Config{Peers: restore})
+			}
+		}
+		return false, configureError(deviceName, err)
+	}
+
+	// Updated peers are read back to report their current state
+	if len(updated) > 0 {
+		if after, err := c.ctrl.Device(d.Name); err == nil {
+			current := make(map[string]wgtypes.Peer, len(after.Peers))
+			for _, p := range after.Peers {
+				current[p.PublicKey.String()] = p
+			}
+			for _, i := range updated {
+				if p, ok := current[items[i].Peer.PublicKey]; ok {
+					peer := peerToEntity(p)
+					items[i].Peer = &peer
+				}
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// planPeerOp builds the configs for a single batch operation and the peer it
+// affects.
+func planPeerOp(existing map[wgtypes.Key]wgtypes.Peer, op entity.PeerBatchOperation) ([]wgtypes.PeerConfig, *entity.Peer, error) {
+	switch op.Op {
+	case entity.PeerBatchCreate:
+		peerCfg, peer, err := createPeerConfig(op.Peer)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := existing[peerCfg.PublicKey]; ok {
+			return nil, nil, domain.AlreadyExists(entity.ErrCodePeerExists, "peer %s already exists", peerCfg.PublicKey.String())
+		}
+		return []wgtypes.PeerConfig{peerCfg}, peer, nil
+
+	case entity.PeerBatchUpdate, entity.PeerBatchDelete:
+		key, err := DecodeURLSafeKey(op.PublicKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		p, ok := existing[*key]
+		if !ok {
+			return nil, nil, domain.NotFound(entity.ErrCodePeerNotFound, "peer %s not found", key.String())
+		}
+		peer := peerToEntity(p)
+
+		if op.Op == entity.PeerBatchDelete {
+			return []wgtypes.PeerConfig{{PublicKey: *key, Remove: true}}, &peer, nil
+		}
+
+		peerCfgs, err := updatePeerConfig(p, op.Peer)
+		if err != nil {
+			return nil, nil, err
+		}
+		return peerCfgs, &peer, nil
+
+	default:
+		return nil, nil, domain.Validation(entity.ErrCodeValidationFailed, "unknown operation %q", op.Op)
+	}
+}
+
+// rollbackPeers returns the configs that turn the after peers back into the
+// before peers: added peers are removed and changed or removed peers are
+// restored.
+func rollbackPeers(before, after []wgtypes.Peer) []wgtypes.PeerConfig {
+	current := make(map[wgtypes.Key]wgtypes.Peer, len(after))
+	for _, p := range after {
+		current[p.PublicKey] = p
+	}
+
+	var restore []wgtypes.PeerConfig
+	for _, p := range before {
+		if now, ok := current[p.PublicKey]; ok {
+			delete(current, p.PublicKey)
+			if samePeerConfig(p, now) {
+				continue
+			}
+		}
+		restore = append(restore, wgtypes.PeerConfig{
+			PublicKey:                   p.PublicKey,
+			PresharedKey:                &p.PresharedKey,
+			Endpoint:                    p.Endpoint,
+			PersistentKeepaliveInterval: &p.PersistentKeepaliveInterval,
+			ReplaceAllowedIPs:           true,
+			AllowedIPs:                  p.AllowedIPs,
+		})
+	}
+	for key := range current {
+		restore = append(restore, wgtypes.PeerConfig{PublicKey: key, Remove: true})
+	}
+	return restore
+}
+
+// samePeerConfig reports whether two peers have the same configuration.
+func samePeerConfig(a, b wgtypes.Peer) bool {
+	return a.PresharedKey == b.PresharedKey &&
+		a.PersistentKeepaliveInterval == b.PersistentKeepaliveInterval &&
+		endpointString(a.Endpoint) == endpointString(b.Endpoint) &&
+		slices.EqualFunc(a.AllowedIPs, b.AllowedIPs, func(x, y net.IPNet) bool { return x.String() == y.String() })
+}
+
+func endpointString(addr *net.UDPAddr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
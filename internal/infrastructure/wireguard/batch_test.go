@@ -0,0 +1,113 @@
+package wireguard
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+func testPeer(t *testing.T, allowedIP string) wgtypes.Peer {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	_, allowed, err := net.ParseCIDR(allowedIP)
+	require.NoError(t, err)
+	return wgtypes.Peer{PublicKey: key.PublicKey(), AllowedIPs: []net.IPNet{*allowed}}
+}
+
+func TestPlanPeerOp(t *testing.T) {
+	p := testPeer(t, "10.0.0.2/32")
+	existing := map[wgtypes.Key]wgtypes.Peer{p.PublicKey: p}
+	urlSafe := base64.URLEncoding.EncodeToString(p.PublicKey[:])
+
+	t.Run("create", func(t *testing.T) {
+		cfgs, peer, err := planPeerOp(existing, entity.PeerBatchOperation{
+			Op:   entity.PeerBatchCreate,
+			Peer: entity.PeerCreateOrUpdateRequest{AllowedIPs: []string{"10.0.0.3/32"}},
+		})
+		require.NoError(t, err)
+		require.Len(t, cfgs, 1)
+		assert.Equal(t, peer.PublicKey, cfgs[0].PublicKey.String())
+		assert.NotEmpty(t, peer.PrivateKey)
+	})
+
+	t.Run("create existing", func(t *testing.T) {
+		key := p.PublicKey.String()
+		_, _, err := planPeerOp(existing, entity.PeerBatchOperation{
+			Op:   entity.PeerBatchCreate,
+			Peer: entity.PeerCreateOrUpdateRequest{PublicKey: &key},
+		})
+		assert.True(t, errors.Is(err, domain.ErrAlreadyExists))
+	})
+
+	t.Run("update", func(t *testing.T) {
+		cfgs, peer, err := planPeerOp(existing, entity.PeerBatchOperation{
+			Op:        entity.PeerBatchUpdate,
+			PublicKey: urlSafe,
+			Peer:      entity.PeerCreateOrUpdateRequest{NullFields: []string{"endpoint"}},
+		})
+		require.NoError(t, err)
+		assert.Len(t, cfgs, 2, "clearing the endpoint recreates the peer")
+		assert.Equal(t, p.PublicKey.String(), peer.PublicKey)
+	})
+
+	t.Run("delete with standard key", func(t *testing.T) {
+		cfgs, peer, err := planPeerOp(existing, entity.PeerBatchOperation{
+			Op:        entity.PeerBatchDelete,
+			PublicKey: p.PublicKey.String(),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []wgtypes.PeerConfig{{PublicKey: p.PublicKey, Remove: true}}, cfgs)
+		assert.Equal(t, []string{"10.0.0.2/32"}, peer.AllowedIPs)
+	})
+
+	t.Run("missing peer", func(t *testing.T) {
+		missing := testPeer(t, "10.0.0.4/32")
+		_, _, err := planPeerOp(existing, entity.PeerBatchOperation{
+			Op:        entity.PeerBatchDelete,
+			PublicKey: missing.PublicKey.String(),
+		})
+		assert.True(t, errors.Is(err, domain.ErrNotFound))
+	})
+
+	t.Run("unknown op", func(t *testing.T) {
+		_, _, err := planPeerOp(existing, entity.PeerBatchOperation{Op: "replace"})
+		assert.True(t, errors.Is(err, domain.ErrValidation))
+	})
+}
+
+func TestRollbackPeers(t *testing.T) {
+	unchanged := testPeer(t, "10.0.0.2/32")
+	changed := testPeer(t, "10.0.0.3/32")
+	removed := testPeer(t, "10.0.0.4/32")
+	removed.PersistentKeepaliveInterval = 25 * time.Second
+	added := testPeer(t, "10.0.0.5/32")
+
+	changedAfter := changed
+	changedAfter.AllowedIPs = nil
+
+	restore := rollbackPeers(
+		[]wgtypes.Peer{unchanged, changed, removed},
+		[]wgtypes.Peer{unchanged, changedAfter, added},
+	)
+
+	require.Len(t, restore, 3)
+	assert.Equal(t, changed.PublicKey, restore[0].PublicKey)
+	assert.True(t, restore[0].ReplaceAllowedIPs)
+	assert.Equal(t, changed.AllowedIPs, restore[0].AllowedIPs)
+
+	assert.Equal(t, removed.PublicKey, restore[1].PublicKey)
+	assert.False(t, restore[1].Remove)
+	assert.Equal(t, 25*time.Second, *restore[1].PersistentKeepaliveInterval)
+
+	assert.Equal(t, wgtypes.PeerConfig{PublicKey: added.PublicKey, Remove: true}, restore[2])
+}
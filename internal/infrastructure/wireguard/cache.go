@@ -0,0 +1,141 @@
+package wireguard
+
+import (
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+// deviceController is the part of wgctrl.Client the client uses.
+type deviceController interface {
+	Devices() ([]*wgtypes.Device, error)
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+	Close() error
+}
+
+// deviceSnapshot is the state of a device fetched with a single netlink
+// query, with its peers converted and indexed.
+type deviceSnapshot struct {
+	device  *wgtypes.Device
+	peers   []entity.Peer
+	index   *peerIndex
+	fetched time.Time
+}
+
+func newDeviceSnapshot(d *wgtypes.Device, fetched time.Time) *deviceSnapshot {
+	s := &deviceSnapshot{
+		device:  d,
+		peers:   make([]entity.Peer, len(d.Peers)),
+		index:   newPeerIndex(len(d.Peers)),
+		fetched: fetched,
+	}
+	for i, p := range d.Peers {
+		s.peers[i] = peerToEntity(p)
+		s.index.add(i, p.PublicKey)
+	}
+	return s
+}
+
+// peer returns the peer with the given public key.
+func (s *deviceSnapshot) peer(key wgtypes.Key) (wgtypes.Peer, entity.Peer, bool) {
+	i, ok := s.index.Key(key)
+	if !ok {
+		return wgtypes.Peer{}, entity.Peer{}, false
+	}
+	return s.device.Peers[i], s.peers[i], true
+}
+
+// peerCache keeps device snapshots for a short time, so listing and looking
+// up peers of large devices doesn't query and convert every peer each time.
+// Snapshots of a device are dropped whenever the client changes it.
+type peerCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu        sync.Mutex
+	snapshots map[string]*deviceSnapshot
+
+	// gen changes on every invalidation, so snapshots queried before a
+	// change aren't stored after it
+	gen uint64
+}
+
+func newPeerCache(ttl time.Duration) *peerCache {
+	return &peerCache{
+		ttl:       ttl,
+		now:       time.Now,
+		snapshots: make(map[string]*deviceSnapshot),
+	}
+}
+
+// get returns the snapshot of a device if it's fresh.
+func (c *peerCache) get(name string) (*deviceSnapshot, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.snapshots[name]
+	if !ok {
+		return nil, false
+	}
+	if c.now().Sub(s.fetched) >= c.ttl {
+		delete(c.snapshots, name)
+		return nil, false
+	}
+	return s, true
+}
+
+// generation returns the current generation, taken before querying a device.
+func (c *peerCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// put stores the snapshot of a device queried at generation gen, unless a
+// device was changed since or caching is disabled.
+func (c *peerCache) put(name string, s *deviceSnapshot, gen uint64) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen == c.gen {
+		c.snapshots[name] = s
+	}
+}
+
+// invalidate drops the snapshot of a device.
+func (c *peerCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	delete(c.snapshots, name)
+}
+
+// peerIndex looks peers up by public key.
+type peerIndex struct {
+	byKey map[wgtypes.Key]int
+}
+
+func newPeerIndex(size int) *peerIndex {
+	return &peerIndex{byKey: make(map[wgtypes.Key]int, size)}
+}
+
+func (x *peerIndex) add(i int, key wgtypes.Key) {
+	x.byKey[key] = i
+}
+
+// Key returns the position of the peer with the given public key.
+func (x *peerIndex) Key(key wgtypes.Key) (int, bool) {
+	i, ok := x.byKey[key]
+	return i, ok
+}
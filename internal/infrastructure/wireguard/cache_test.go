@@ -0,0 +1,226 @@
+package wireguard
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+// fakeController serves a single device from memory and counts queries.
+type fakeController struct {
+	device  *wgtypes.Device
+	queries int
+}
+
+func (f *fakeController) Devices() ([]*wgtypes.Device, error) {
+	return []*wgtypes.Device{f.device}, nil
+}
+
+func (f *fakeController) Device(name string) (*wgtypes.Device, error) {
+	f.queries++
+	if name != f.device.Name {
+		return nil, os.ErrNotExist
+	}
+	// Like netlink, every query returns a new copy
+	d := *f.device
+	d.Peers = append([]wgtypes.Peer(nil), f.device.Peers...)
+	return &d, nil
+}
+
+func (f *fakeController) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	for _, pc := range cfg.Peers {
+		i := -1
+		for j, p := range f.device.Peers {
+			if p.PublicKey == pc.PublicKey {
+				i = j
+			}
+		}
+		switch {
+		case pc.Remove && i >= 0:
+			f.device.Peers = append(f.device.Peers[:i], f.device.Peers[i+1:]...)
+		case i < 0:
+			f.device.Peers = append(f.device.Peers, wgtypes.Peer{PublicKey: pc.PublicKey, AllowedIPs: pc.AllowedIPs})
+		case pc.ReplaceAllowedIPs:
+			f.device.Peers[i].AllowedIPs = pc.AllowedIPs
+		}
+	}
+	return nil
+}
+
+func (f *fakeController) Close() error {
+	return nil
+}
+
+// newFakeClient returns a client for a device wg0 with n peers, peer i
+// allowed 10.x.y.z/32 for its index and 0.0.0.0/0 for the first peer.
+func newFakeClient(n int, ttl time.Duration) (*Client, *fakeController) {
+	peers := make([]wgtypes.Peer, n)
+	for i := range peers {
+		var key wgtypes.Key
+		key[0], key[1], key[2] = byte(i>>16), byte(i>>8), byte(i)
+		peers[i] = wgtypes.Peer{
+			PublicKey:  key,
+			AllowedIPs: []net.IPNet{{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)).To4(), Mask: net.CIDRMask(32, 32)}},
+		}
+	}
+	peers[0].AllowedIPs = append(peers[0].AllowedIPs, net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)})
+
+	ctrl := &fakeController{device: &wgtypes.Device{Name: "wg0", Peers: peers}}
+	return &Client{ctrl: ctrl, cache: newPeerCache(ttl)}, ctrl
+}
+
+func urlSafeKey(key wgtypes.Key) string {
+	return base64.URLEncoding.EncodeToString(key[:])
+}
+
+func TestClient_PeerCache(t *testing.T) {
+	ctx := context.Background()
+	c, ctrl := newFakeClient(3, time.Minute)
+	now := time.Now()
+	c.cache.now = func() time.Time { return now }
+
+	peers, err := c.ListPeers(ctx, "wg0")
+	require.NoError(t, err)
+	assert.Len(t, peers, 3)
+
+	// Reads within the TTL are served from the snapshot
+	peer, err := c.GetPeer(ctx, "wg0", urlSafeKey(ctrl.device.Peers[1].PublicKey))
+	require.NoError(t, err)
+	assert.Equal(t, ctrl.device.Peers[1].PublicKey.String(), peer.PublicKey)
+	assert.Equal(t, 1, ctrl.queries)
+
+	// Callers get their own copy
+	peers[0].PublicKey = "changed"
+	peers, err = c.ListPeers(ctx, "wg0")
+	require.NoError(t, err)
+	assert.NotEqual(t, "changed", peers[0].PublicKey)
+
+	// Changes drop the snapshot
+	deleted, err := c.DeletePeer(ctx, "wg0", urlSafeKey(ctrl.device.Peers[2].PublicKey))
+	require.NoError(t, err)
+	peers, err = c.ListPeers(ctx, "wg0")
+	require.NoError(t, err)
+	assert.Len(t, peers, 2)
+	assert.NotContains(t, peers, *deleted)
+	assert.Equal(t, 3, ctrl.queries)
+
+	_, err = c.GetPeer(ctx, "wg0", deleted.URLSafePublicKey)
+	assert.Equal(t, entity.ErrCodePeerNotFound, domain.Code(err))
+
+	// As does the TTL
+	now = now.Add(time.Minute)
+	_, err = c.ListPeers(ctx, "wg0")
+	require.NoError(t, err)
+	assert.Equal(t, 4, ctrl.queries)
+
+	// And changes made elsewhere
+	c.Invalidate("wg0")
+	_, err = c.ListPeers(ctx, "wg0")
+	require.NoError(t, err)
+	assert.Equal(t, 5, ctrl.queries)
+
+	// Errors aren't cached
+	_, err = c.ListPeers(ctx, "wg1")
+	assert.Equal(t, entity.ErrCodeDeviceNotFound, domain.Code(err))
+	_, err = c.ListPeers(ctx, "wg1")
+	assert.Error(t, err)
+	assert.Equal(t, 7, ctrl.queries)
+}
+
+func TestClient_PeerCacheMutationsQueryDevice(t *testing.T) {
+	ctx := context.Background()
+	c, ctrl := newFakeClient(2, time.Minute)
+
+	_, err := c.ListPeers(ctx, "wg0")
+	require.NoError(t, err)
+
+	// A peer added by another tool after the snapshot
+	key, err := wgtypes.GenerateKey()
+	require.NoError(t, err)
+	ctrl.device.Peers = append(ctrl.device.Peers, wgtypes.Peer{PublicKey: key})
+
+	pub := key.String()
+	_, err = c.CreatePeer(ctx, "wg0", entity.PeerCreateOrUpdateRequest{PublicKey: &pub})
+	assert.Equal(t, entity.ErrCodePeerExists, domain.Code(err))
+
+	peer, err := c.GetPeerUncached(ctx, "wg0", urlSafeKey(key))
+	require.NoError(t, err)
+	assert.Equal(t, pub, peer.PublicKey)
+
+	deleted, err := c.DeletePeer(ctx, "wg0", urlSafeKey(key))
+	require.NoError(t, err)
+	assert.Equal(t, pub, deleted.PublicKey)
+	assert.Len(t, ctrl.device.Peers, 2)
+}
+
+func TestClient_PeerCacheDisabled(t *testing.T) {
+	ctx := context.Background()
+	c, ctrl := newFakeClient(2, 0)
+
+	for i := 0; i < 2; i++ {
+		_, err := c.ListPeers(ctx, "wg0")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, ctrl.queries)
+}
+
+func TestPeerCache_StaleSnapshot(t *testing.T) {
+	c := newPeerCache(time.Minute)
+
+	// A device changed while it was queried isn't cached
+	gen := c.generation()
+	c.invalidate("wg0")
+	c.put("wg0", &deviceSnapshot{fetched: c.now()}, gen)
+	_, ok := c.get("wg0")
+	assert.False(t, ok)
+
+	c.put("wg0", &deviceSnapshot{fetched: c.now()}, c.generation())
+	_, ok = c.get("wg0")
+	assert.True(t, ok)
+}
+
+func benchmarkPeerSizes(b *testing.B, fn func(b *testing.B, c *Client, ctrl *fakeController)) {
+	for _, n := range []int{10000, 20000} {
+		for _, ttl := range []time.Duration{0, time.Minute} {
+			name := fmt.Sprintf("peers=%d/cache=%t", n, ttl > 0)
+			b.Run(name, func(b *testing.B) {
+				c, ctrl := newFakeClient(n, ttl)
+				b.ReportAllocs()
+				b.ResetTimer()
+				fn(b, c, ctrl)
+			})
+		}
+	}
+}
+
+func BenchmarkClient_ListPeers(b *testing.B) {
+	benchmarkPeerSizes(b, func(b *testing.B, c *Client, _ *fakeController) {
+		for i := 0; i < b.N; i++ {
+			if _, err := c.ListPeers(context.Background(), "wg0"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkClient_GetPeer(b *testing.B) {
+	benchmarkPeerSizes(b, func(b *testing.B, c *Client, ctrl *fakeController) {
+		key := urlSafeKey(ctrl.device.Peers[len(ctrl.device.Peers)-1].PublicKey)
+		for i := 0; i < b.N; i++ {
+			if _, err := c.GetPeer(context.Background(), "wg0", key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -1,36 +1,100 @@
 package wireguard
 
 import (
+	"context"
 	"encoding/base64"
-	"fmt"
+	"errors"
 	"net"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
 	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 
+	"github.com/suquant/wgrest/internal/domain"
 	"github.com/suquant/wgrest/internal/domain/entity"
 )
 
 // EmptyKey represents an empty WireGuard key (all zeros).
 var EmptyKey = wgtypes.Key{}
 
-// Client provides access to WireGuard devices via wgctrl.
+// Client provides access to WireGuard devices via wgctrl. Netlink calls
+// can't be interrupted, so methods fail with the error of their context if it
+// is done before they start.
 type Client struct {
-	ctrl *wgctrl.Client
+	ctrl  deviceController
+	cache *peerCache
 }
 
-// NewClient creates a new WireGuard client.
+// NewClient creates a new WireGuard client. Devices aren't cached until
+// SetPeerCacheTTL is called.
 func NewClient() (*Client, error) {
 	ctrl, err := wgctrl.New()
 	if err != nil {
 		return nil, err
 	}
-	return &Client{ctrl: ctrl}, nil
+	return &Client{ctrl: ctrl, cache: newPeerCache(0)}, nil
+}
+
+// SetPeerCacheTTL makes the client reuse the peers of a device queried less
+// than ttl ago, and look them up by public key and allowed IP through an
+// index. Changes made through the client drop the cached peers at once;
+// changes made by other tools show up after ttl. 0 disables caching.
+func (c *Client) SetPeerCacheTTL(ttl time.Duration) {
+	c.cache = newPeerCache(ttl)
+}
+
+// Invalidate drops the cached peers of a device changed outside the client,
+// such as by wg-quick.
+func (c *Client) Invalidate(name string) {
+	c.cache.invalidate(name)
+}
+
+// snapshot returns the state of a device, from the cache if it's fresh.
+func (c *Client) snapshot(name string) (*deviceSnapshot, error) {
+	if s, ok := c.cache.get(name); ok {
+		return s, nil
+	}
+
+	gen, fetched := c.cache.generation(), c.cache.now()
+	d, err := c.ctrl.Device(resolveInterfaceName(name))
+	if err != nil {
+		return nil, deviceError(name, err)
+	}
+
+	s := newDeviceSnapshot(d, fetched)
+	c.cache.put(name, s, gen)
+	return s, nil
+}
+
+// getDevice queries a device, bypassing the cache. Changes are based on it so
+// they don't undo changes made by other tools since the cached snapshot.
+func (c *Client) getDevice(name string) (*wgtypes.Device, error) {
+	d, err := c.ctrl.Device(resolveInterfaceName(name))
+	if err != nil {
+		return nil, deviceError(name, err)
+	}
+	return d, nil
+}
+
+// findPeer returns the peer of a device with the given public key.
+func findPeer(d *wgtypes.Device, key wgtypes.Key) (wgtypes.Peer, bool) {
+	for _, p := range d.Peers {
+		if p.PublicKey == key {
+			return p, true
+		}
+	}
+	return wgtypes.Peer{}, false
+}
+
+// configure configures a device and drops its cached peers.
+func (c *Client) configure(name string, cfg wgtypes.Config) error {
+	defer c.cache.invalidate(name)
+	return c.ctrl.ConfigureDevice(resolveInterfaceName(name), cfg)
 }
 
 // Close closes the WireGuard client.
@@ -61,10 +125,17 @@ func resolveInterfaceName(name string) string {
 }
 
 // List returns all WireGuard devices.
-func (c *Client) List() ([]entity.Device, error) {
+func (c *Client) List(ctx context.Context) ([]entity.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	devices, err := c.ctrl.Devices()
 	if err != nil {
-		return nil, err
+		if errors.Is(err, os.ErrPermission) {
+			return nil, domain.Permission(entity.ErrCodePermissionDenied, "failed to list devices: %w", err)
+		}
+		return nil, domain.BackendUnavailable(entity.ErrCodeBackendUnavailable, "failed to list devices: %w", err)
 	}
 
 	result := make([]entity.Device, len(devices))
@@ -76,16 +147,17 @@ func (c *Client) List() ([]entity.Device, error) {
 }
 
 // Get returns a specific device by name.
-func (c *Client) Get(name string) (*entity.Device, error) {
+func (c *Client) Get(ctx context.Context, name string) (*entity.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Resolve actual interface name (macOS uses utunX)
 	realName := resolveInterfaceName(name)
 
 	d, err := c.ctrl.Device(realName)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("device %s not found", name)
-		}
-		return nil, err
+		return nil, deviceError(name, err)
 	}
 
 	device := deviceToEntity(d)
@@ -95,9 +167,13 @@ func (c *Client) Get(name string) (*entity.Device, error) {
 }
 
 // Create creates a new WireGuard device.
-func (c *Client) Create(req entity.DeviceCreateOrUpdateRequest) (*entity.Device, error) {
+func (c *Client) Create(ctx context.Context, req entity.DeviceCreateOrUpdateRequest) (*entity.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	if req.Name == nil || *req.Name == "" {
-		return nil, fmt.Errorf("device name is required")
+		return nil, domain.Validation(entity.ErrCodeValidationFailed, "device name is required")
 	}
 
 	name := *req.Name
@@ -105,7 +181,7 @@ func (c *Client) Create(req entity.DeviceCreateOrUpdateRequest) (*entity.Device,
 	// Check if device already exists
 	_, err := c.ctrl.Device(name)
 	if err == nil {
-		return nil, fmt.Errorf("device %s already exists", name)
+		return nil, domain.AlreadyExists(entity.ErrCodeDeviceExists, "device %s already exists", name)
 	}
 
 	// Build configuration
@@ -114,7 +190,7 @@ func (c *Client) Create(req entity.DeviceCreateOrUpdateRequest) (*entity.Device,
 	if req.PrivateKey != nil {
 		key, err := wgtypes.ParseKey(*req.PrivateKey)
 		if err != nil {
-			return nil, fmt.Errorf("invalid private key: %w", err)
+			return nil, domain.Validation(entity.ErrCodeInvalidKey, "invalid private key: %w", err)
 		}
 		cfg.PrivateKey = &key
 	}
@@ -129,22 +205,27 @@ func (c *Client) Create(req entity.DeviceCreateOrUpdateRequest) (*entity.Device,
 		cfg.FirewallMark = &mark
 	}
 
-	if err := c.ctrl.ConfigureDevice(name, cfg); err != nil {
-		return nil, err
+	if err := c.configure(name, cfg); err != nil {
+		if isNotExist(err) {
+			return nil, domain.NotSupported(entity.ErrCodeNotSupported,
+				"interface %s does not exist; create it with wg-quick or ip link first", name)
+		}
+		return nil, configureError(name, err)
 	}
 
-	return c.Get(name)
+	return c.Get(ctx, name)
 }
 
 // Update updates an existing WireGuard device.
-func (c *Client) Update(name string, req entity.DeviceCreateOrUpdateRequest) (*entity.Device, error) {
+func (c *Client) Update(ctx context.Context, name string, req entity.DeviceCreateOrUpdateRequest) (*entity.Device, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Check if device exists
 	_, err := c.ctrl.Device(name)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("device %s not found", name)
-		}
-		return nil, err
+		return nil, deviceError(name, err)
 	}
 
 	cfg := wgtypes.Config{}
@@ -152,7 +233,7 @@ func (c *Client) Update(name string, req entity.DeviceCreateOrUpdateRequest) (*e
 	if req.PrivateKey != nil {
 		key, err := wgtypes.ParseKey(*req.PrivateKey)
 		if err != nil {
-			return nil, fmt.Errorf("invalid private key: %w", err)
+			return nil, domain.Validation(entity.ErrCodeInvalidKey, "invalid private key: %w", err)
 		}
 		cfg.PrivateKey = &key
 	}
@@ -160,104 +241,241 @@ func (c *Client) Update(name string, req entity.DeviceCreateOrUpdateRequest) (*e
 	if req.ListenPort != nil {
 		port := int(*req.ListenPort)
 		cfg.ListenPort = &port
+	} else if req.IsNull("listen_port") {
+		port := 0
+		cfg.ListenPort = &port
 	}
 
 	if req.FirewallMark != nil {
 		mark := int(*req.FirewallMark)
 		cfg.FirewallMark = &mark
+	} else if req.IsNull("firewall_mark") {
+		mark := 0
+		cfg.FirewallMark = &mark
 	}
 
-	if err := c.ctrl.ConfigureDevice(name, cfg); err != nil {
-		return nil, err
+	if err := c.configure(name, cfg); err != nil {
+		return nil, configureError(name, err)
 	}
 
-	return c.Get(name)
+	return c.Get(ctx, name)
 }
 
 // Delete removes a WireGuard device.
-func (c *Client) Delete(name string) error {
+func (c *Client) Delete(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// Note: wgctrl doesn't support device deletion directly
 	// This would typically be handled by removing the network interface
-	return fmt.Errorf("device deletion not supported via wgctrl")
+	return domain.NotSupported(entity.ErrCodeNotSupported, "device deletion not supported via wgctrl")
 }
 
 // ListPeers returns all peers for a device.
-func (c *Client) ListPeers(deviceName string) ([]entity.Peer, error) {
-	// Resolve actual interface name (macOS uses utunX)
-	realName := resolveInterfaceName(deviceName)
+func (c *Client) ListPeers(ctx context.Context, deviceName string) ([]entity.Peer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	d, err := c.ctrl.Device(realName)
+	s, err := c.snapshot(deviceName)
 	if err != nil {
-		// Handle various "not found" errors across platforms
-		if os.IsNotExist(err) || strings.Contains(err.Error(), "does not exist") ||
-			strings.Contains(err.Error(), "no such file") ||
-			strings.Contains(err.Error(), "not found") {
-			return nil, fmt.Errorf("device %s not found", deviceName)
-		}
-		// Return actual error for debugging
-		return nil, fmt.Errorf("failed to get device %s: %w", deviceName, err)
+		return nil, err
 	}
 
-	result := make([]entity.Peer, len(d.Peers))
-	for i, p := range d.Peers {
-		result[i] = peerToEntity(p)
+	// Callers may sort or modify the peers
+	return slices.Clone(s.peers), nil
+}
+
+// GetWithPeers returns a device and its peers from a single query.
+func (c *Client) GetWithPeers(ctx context.Context, name string) (*entity.Device, []entity.Peer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
 	}
 
-	return result, nil
+	s, err := c.snapshot(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	device := deviceToEntity(s.device)
+	device.Name = name
+
+	return &device, slices.Clone(s.peers), nil
 }
 
 // GetPeer returns a specific peer by URL-safe public key.
-func (c *Client) GetPeer(deviceName string, urlSafePubKey string) (*entity.Peer, error) {
-	pubKey, err := decodeURLSafeKey(urlSafePubKey)
+func (c *Client) GetPeer(ctx context.Context, deviceName string, urlSafePubKey string) (*entity.Peer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	pubKey, err := DecodeURLSafeKey(urlSafePubKey)
 	if err != nil {
 		return nil, err
 	}
 
-	// Resolve actual interface name (macOS uses utunX)
-	realName := resolveInterfaceName(deviceName)
+	s, err := c.snapshot(deviceName)
+	if err != nil {
+		return nil, err
+	}
 
-	d, err := c.ctrl.Device(realName)
+	_, peer, ok := s.peer(*pubKey)
+	if !ok {
+		return nil, domain.NotFound(entity.ErrCodePeerNotFound, "peer %s not found", pubKey.String())
+	}
+	return &peer, nil
+}
+
+// GetPeerUncached returns a specific peer like GetPeer, bypassing the cache,
+// for checks that must see its current state.
+func (c *Client) GetPeerUncached(ctx context.Context, deviceName string, urlSafePubKey string) (*entity.Peer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	pubKey, err := DecodeURLSafeKey(urlSafePubKey)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("device %s not found", deviceName)
-		}
 		return nil, err
 	}
 
-	for _, p := range d.Peers {
-		if p.PublicKey.String() == pubKey.String() {
-			peer := peerToEntity(p)
-			return &peer, nil
-		}
+	d, err := c.getDevice(deviceName)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("peer not found")
+	p, ok := findPeer(d, *pubKey)
+	if !ok {
+		return nil, domain.NotFound(entity.ErrCodePeerNotFound, "peer %s not found", pubKey.String())
+	}
+	peer := peerToEntity(p)
+	return &peer, nil
 }
 
 // CreatePeer creates a new peer for a device.
-func (c *Client) CreatePeer(deviceName string, req entity.PeerCreateOrUpdateRequest) (*entity.Peer, error) {
-	d, err := c.ctrl.Device(deviceName)
+func (c *Client) CreatePeer(ctx context.Context, deviceName string, req entity.PeerCreateOrUpdateRequest) (*entity.Peer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	d, err := c.getDevice(deviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	peerCfg, peer, err := createPeerConfig(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := findPeer(d, peerCfg.PublicKey); ok {
+		return nil, domain.AlreadyExists(entity.ErrCodePeerExists, "peer %s already exists", peerCfg.PublicKey.String())
+	}
+
+	cfg := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{peerCfg},
+	}
+
+	if err := c.configure(deviceName, cfg); err != nil {
+		return nil, configureError(deviceName, err)
+	}
+
+	return peer, nil
+}
+
+// UpdatePeer updates an existing peer.
+func (c *Client) UpdatePeer(ctx context.Context, deviceName string, urlSafePubKey string, req entity.PeerCreateOrUpdateRequest) (*entity.Peer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	pubKey, err := DecodeURLSafeKey(urlSafePubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := c.getDevice(deviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	existingPeer, ok := findPeer(d, *pubKey)
+	if !ok {
+		return nil, domain.NotFound(entity.ErrCodePeerNotFound, "peer %s not found", pubKey.String())
+	}
+
+	peerCfgs, err := updatePeerConfig(existingPeer, req)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := wgtypes.Config{
+		Peers: peerCfgs,
+	}
+
+	if err := c.configure(deviceName, cfg); err != nil {
+		return nil, configureError(deviceName, err)
+	}
+
+	return c.GetPeer(ctx, deviceName, urlSafePubKey)
+}
+
+// DeletePeer removes a peer from a device.
+func (c *Client) DeletePeer(ctx context.Context, deviceName string, urlSafePubKey string) (*entity.Peer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	pubKey, err := DecodeURLSafeKey(urlSafePubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get peer info before deletion
+	d, err := c.getDevice(deviceName)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("device %s not found", deviceName)
-		}
 		return nil, err
 	}
 
+	p, ok := findPeer(d, *pubKey)
+	if !ok {
+		return nil, domain.NotFound(entity.ErrCodePeerNotFound, "peer %s not found", pubKey.String())
+	}
+	peer := peerToEntity(p)
+
+	peerCfg := wgtypes.PeerConfig{
+		PublicKey: *pubKey,
+		Remove:    true,
+	}
+
+	cfg := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{peerCfg},
+	}
+
+	if err := c.configure(deviceName, cfg); err != nil {
+		return nil, configureError(deviceName, err)
+	}
+
+	return &peer, nil
+}
+
+// createPeerConfig builds the config for a new peer, generating a key pair
+// if the request has no public key, and the peer it creates.
+func createPeerConfig(req entity.PeerCreateOrUpdateRequest) (wgtypes.PeerConfig, *entity.Peer, error) {
 	peerCfg := wgtypes.PeerConfig{}
 
 	// Generate or use provided keys
 	if req.PublicKey != nil {
 		key, err := wgtypes.ParseKey(*req.PublicKey)
 		if err != nil {
-			return nil, fmt.Errorf("invalid public key: %w", err)
+			return peerCfg, nil, domain.Validation(entity.ErrCodeInvalidKey, "invalid public key: %w", err)
 		}
 		peerCfg.PublicKey = key
 	} else {
 		// Generate new key pair
 		privateKey, err := wgtypes.GeneratePrivateKey()
 		if err != nil {
-			return nil, err
+			return peerCfg, nil, err
 		}
 		peerCfg.PublicKey = privateKey.PublicKey()
 		// Store private key in request for caller to persist
@@ -268,19 +486,15 @@ func (c *Client) CreatePeer(deviceName string, req entity.PeerCreateOrUpdateRequ
 	if req.PresharedKey != nil {
 		key, err := wgtypes.ParseKey(*req.PresharedKey)
 		if err != nil {
-			return nil, fmt.Errorf("invalid preshared key: %w", err)
+			return peerCfg, nil, domain.Validation(entity.ErrCodeInvalidKey, "invalid preshared key: %w", err)
 		}
 		peerCfg.PresharedKey = &key
 	}
 
 	if len(req.AllowedIPs) > 0 {
-		allowedIPs := make([]net.IPNet, 0, len(req.AllowedIPs))
-		for _, ip := range req.AllowedIPs {
-			_, ipNet, err := net.ParseCIDR(ip)
-			if err != nil {
-				return nil, fmt.Errorf("invalid allowed IP %s: %w", ip, err)
-			}
-			allowedIPs = append(allowedIPs, *ipNet)
+		allowedIPs, err := parseAllowedIPs(req.AllowedIPs)
+		if err != nil {
+			return peerCfg, nil, err
 		}
 		peerCfg.AllowedIPs = allowedIPs
 	}
@@ -288,7 +502,7 @@ func (c *Client) CreatePeer(deviceName string, req entity.PeerCreateOrUpdateRequ
 	if req.PersistentKeepaliveInterval != nil {
 		duration, err := time.ParseDuration(*req.PersistentKeepaliveInterval)
 		if err != nil {
-			return nil, fmt.Errorf("invalid keepalive interval: %w", err)
+			return peerCfg, nil, domain.Validation(entity.ErrCodeValidationFailed, "invalid keepalive interval: %w", err)
 		}
 		peerCfg.PersistentKeepaliveInterval = &duration
 	}
@@ -296,19 +510,11 @@ func (c *Client) CreatePeer(deviceName string, req entity.PeerCreateOrUpdateRequ
 	if req.Endpoint != nil && *req.Endpoint != "" {
 		addr, err := net.ResolveUDPAddr("udp", *req.Endpoint)
 		if err != nil {
-			return nil, fmt.Errorf("invalid endpoint: %w", err)
+			return peerCfg, nil, domain.Validation(entity.ErrCodeValidationFailed, "invalid endpoint: %w", err)
 		}
 		peerCfg.Endpoint = addr
 	}
 
-	cfg := wgtypes.Config{
-		Peers: []wgtypes.PeerConfig{peerCfg},
-	}
-
-	if err := c.ctrl.ConfigureDevice(d.Name, cfg); err != nil {
-		return nil, err
-	}
-
 	peer := entity.Peer{
 		PublicKey:                   peerCfg.PublicKey.String(),
 		URLSafePublicKey:            base64.URLEncoding.EncodeToString(peerCfg.PublicKey[:]),
@@ -333,59 +539,32 @@ func (c *Client) CreatePeer(deviceName string, req entity.PeerCreateOrUpdateRequ
 		peer.Endpoint = *req.Endpoint
 	}
 
-	return &peer, nil
+	return peerCfg, &peer, nil
 }
 
-// UpdatePeer updates an existing peer.
-func (c *Client) UpdatePeer(deviceName string, urlSafePubKey string, req entity.PeerCreateOrUpdateRequest) (*entity.Peer, error) {
-	pubKey, err := decodeURLSafeKey(urlSafePubKey)
-	if err != nil {
-		return nil, err
-	}
-
-	d, err := c.ctrl.Device(deviceName)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("device %s not found", deviceName)
-		}
-		return nil, err
-	}
-
-	// Find existing peer
-	var existingPeer *wgtypes.Peer
-	for _, p := range d.Peers {
-		if p.PublicKey.String() == pubKey.String() {
-			existingPeer = &p
-			break
-		}
-	}
-
-	if existingPeer == nil {
-		return nil, fmt.Errorf("peer not found")
-	}
-
+// updatePeerConfig builds the configs applying req to an existing peer.
+func updatePeerConfig(existing wgtypes.Peer, req entity.PeerCreateOrUpdateRequest) ([]wgtypes.PeerConfig, error) {
 	peerCfg := wgtypes.PeerConfig{
-		PublicKey:         *pubKey,
+		PublicKey:         existing.PublicKey,
 		UpdateOnly:        true,
-		ReplaceAllowedIPs: len(req.AllowedIPs) > 0,
+		ReplaceAllowedIPs: len(req.AllowedIPs) > 0 || req.IsNull("allowed_ips"),
 	}
 
 	if req.PresharedKey != nil {
 		key, err := wgtypes.ParseKey(*req.PresharedKey)
 		if err != nil {
-			return nil, fmt.Errorf("invalid preshared key: %w", err)
+			return nil, domain.Validation(entity.ErrCodeInvalidKey, "invalid preshared key: %w", err)
 		}
 		peerCfg.PresharedKey = &key
+	} else if req.IsNull("preshared_key") {
+		// An all-zero key removes the preshared key
+		peerCfg.PresharedKey = &wgtypes.Key{}
 	}
 
 	if len(req.AllowedIPs) > 0 {
-		allowedIPs := make([]net.IPNet, 0, len(req.AllowedIPs))
-		for _, ip := range req.AllowedIPs {
-			_, ipNet, err := net.ParseCIDR(ip)
-			if err != nil {
-				return nil, fmt.Errorf("invalid allowed IP %s: %w", ip, err)
-			}
-			allowedIPs = append(allowedIPs, *ipNet)
+		allowedIPs, err := parseAllowedIPs(req.AllowedIPs)
+		if err != nil {
+			return nil, err
 		}
 		peerCfg.AllowedIPs = allowedIPs
 	}
@@ -393,57 +572,108 @@ func (c *Client) UpdatePeer(deviceName string, urlSafePubKey string, req entity.
 	if req.PersistentKeepaliveInterval != nil {
 		duration, err := time.ParseDuration(*req.PersistentKeepaliveInterval)
 		if err != nil {
-			return nil, fmt.Errorf("invalid keepalive interval: %w", err)
+			return nil, domain.Validation(entity.ErrCodeValidationFailed, "invalid keepalive interval: %w", err)
 		}
 		peerCfg.PersistentKeepaliveInterval = &duration
+	} else if req.IsNull("persistent_keepalive_interval") {
+		var disabled time.Duration
+		peerCfg.PersistentKeepaliveInterval = &disabled
 	}
 
 	if req.Endpoint != nil && *req.Endpoint != "" {
 		addr, err := net.ResolveUDPAddr("udp", *req.Endpoint)
 		if err != nil {
-			return nil, fmt.Errorf("invalid endpoint: %w", err)
+			return nil, domain.Validation(entity.ErrCodeValidationFailed, "invalid endpoint: %w", err)
 		}
 		peerCfg.Endpoint = addr
 	}
 
-	cfg := wgtypes.Config{
-		Peers: []wgtypes.PeerConfig{peerCfg},
+	// WireGuard can't unset an endpoint, so the peer is removed and re-added
+	if req.IsNull("endpoint") {
+		return recreatePeer(existing, peerCfg), nil
 	}
 
-	if err := c.ctrl.ConfigureDevice(d.Name, cfg); err != nil {
-		return nil, err
-	}
+	return []wgtypes.PeerConfig{peerCfg}, nil
+}
 
-	return c.GetPeer(deviceName, urlSafePubKey)
+func parseAllowedIPs(ips []string) ([]net.IPNet, error) {
+	allowedIPs := make([]net.IPNet, 0, len(ips))
+	for _, ip := range ips {
+		_, ipNet, err := net.ParseCIDR(ip)
+		if err != nil {
+			return nil, domain.Validation(entity.ErrCodeValidationFailed, "invalid allowed IP %s: %w", ip, err)
+		}
+		allowedIPs = append(allowedIPs, *ipNet)
+	}
+	return allowedIPs, nil
 }
 
-// DeletePeer removes a peer from a device.
-func (c *Client) DeletePeer(deviceName string, urlSafePubKey string) (*entity.Peer, error) {
-	pubKey, err := decodeURLSafeKey(urlSafePubKey)
-	if err != nil {
-		return nil, err
+// recreatePeer returns configs that remove a peer and add it back with the
+// updates applied and no endpoint. Traffic counters restart from zero.
+func recreatePeer(existing wgtypes.Peer, update wgtypes.PeerConfig) []wgtypes.PeerConfig {
+	peerCfg := wgtypes.PeerConfig{
+		PublicKey:                   existing.PublicKey,
+		ReplaceAllowedIPs:           true,
+		AllowedIPs:                  existing.AllowedIPs,
+		PersistentKeepaliveInterval: &existing.PersistentKeepaliveInterval,
+	}
+	if existing.PresharedKey != (wgtypes.Key{}) {
+		peerCfg.PresharedKey = &existing.PresharedKey
 	}
 
-	// Get peer info before deletion
-	peer, err := c.GetPeer(deviceName, urlSafePubKey)
-	if err != nil {
-		return nil, err
+	if update.PresharedKey != nil {
+		peerCfg.PresharedKey = update.PresharedKey
+	}
+	if update.ReplaceAllowedIPs {
+		peerCfg.AllowedIPs = update.AllowedIPs
+	}
+	if update.PersistentKeepaliveInterval != nil {
+		peerCfg.PersistentKeepaliveInterval = update.PersistentKeepaliveInterval
 	}
 
-	peerCfg := wgtypes.PeerConfig{
-		PublicKey: *pubKey,
-		Remove:    true,
+	return []wgtypes.PeerConfig{
+		{PublicKey: existing.PublicKey, Remove: true},
+		peerCfg,
 	}
+}
 
-	cfg := wgtypes.Config{
-		Peers: []wgtypes.PeerConfig{peerCfg},
+// SetKeys sets the private key of a device and the preshared keys of its
+// peers, by public key, leaving everything else alone.
+func (c *Client) SetKeys(ctx context.Context, name, privateKey string, presharedKeys map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	if err := c.ctrl.ConfigureDevice(deviceName, cfg); err != nil {
-		return nil, err
+	cfg := wgtypes.Config{}
+
+	if privateKey != "" {
+		key, err := wgtypes.ParseKey(privateKey)
+		if err != nil {
+			return domain.Validation(entity.ErrCodeInvalidKey, "invalid private key: %w", err)
+		}
+		cfg.PrivateKey = &key
 	}
 
-	return peer, nil
+	for publicKey, presharedKey := range presharedKeys {
+		pubKey, err := wgtypes.ParseKey(publicKey)
+		if err != nil {
+			return domain.Validation(entity.ErrCodeInvalidKey, "invalid public key: %w", err)
+		}
+		psk, err := wgtypes.ParseKey(presharedKey)
+		if err != nil {
+			return domain.Validation(entity.ErrCodeInvalidKey, "invalid preshared key: %w", err)
+		}
+		cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{
+			PublicKey:    pubKey,
+			UpdateOnly:   true,
+			PresharedKey: &psk,
+		})
+	}
+
+	if err := c.configure(name, cfg); err != nil {
+		return configureError(name, err)
+	}
+	return nil
 }
 
 // GetDevice returns the raw wgtypes.Device for advanced operations.
@@ -502,7 +732,8 @@ func peerToEntity(p wgtypes.Peer) entity.Peer {
 	}
 }
 
-func decodeURLSafeKey(urlSafeKey string) (*wgtypes.Key, error) {
+// DecodeURLSafeKey decodes a public key in URL-safe or standard base64.
+func DecodeURLSafeKey(urlSafeKey string) (*wgtypes.Key, error) {
 	// Handle both standard and URL-safe base64
 	keyBytes, err := base64.URLEncoding.DecodeString(urlSafeKey)
 	if err != nil {
@@ -511,12 +742,12 @@ func decodeURLSafeKey(urlSafeKey string) (*wgtypes.Key, error) {
 			strings.ReplaceAll(strings.ReplaceAll(urlSafeKey, "-", "+"), "_", "/"),
 		)
 		if err != nil {
-			return nil, fmt.Errorf("invalid public key encoding: %w", err)
+			return nil, domain.Validation(entity.ErrCodeInvalidKey, "invalid public key encoding: %w", err)
 		}
 	}
 
 	if len(keyBytes) != wgtypes.KeyLen {
-		return nil, fmt.Errorf("invalid key length")
+		return nil, domain.Validation(entity.ErrCodeInvalidKey, "invalid key length")
 	}
 
 	var key wgtypes.Key
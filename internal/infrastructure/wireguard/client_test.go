@@ -0,0 +1,57 @@
+package wireguard
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestRecreatePeer(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	psk, err := wgtypes.GenerateKey()
+	require.NoError(t, err)
+	_, allowed, _ := net.ParseCIDR("10.0.0.2/32")
+
+	existing := wgtypes.Peer{
+		PublicKey:                   key.PublicKey(),
+		PresharedKey:                psk,
+		Endpoint:                    &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 51820},
+		AllowedIPs:                  []net.IPNet{*allowed},
+		PersistentKeepaliveInterval: 25 * time.Second,
+	}
+
+	keepalive := time.Duration(0)
+	cfgs := recreatePeer(existing, wgtypes.PeerConfig{
+		PublicKey:                   existing.PublicKey,
+		PersistentKeepaliveInterval: &keepalive,
+	})
+
+	require.Len(t, cfgs, 2)
+	assert.True(t, cfgs[0].Remove)
+	assert.Equal(t, existing.PublicKey, cfgs[0].PublicKey)
+
+	added := cfgs[1]
+	assert.False(t, added.Remove)
+	assert.False(t, added.UpdateOnly)
+	assert.Nil(t, added.Endpoint)
+	assert.Equal(t, psk, *added.PresharedKey)
+	assert.Equal(t, []net.IPNet{*allowed}, added.AllowedIPs)
+	assert.Equal(t, time.Duration(0), *added.PersistentKeepaliveInterval)
+}
+
+func TestRecreatePeer_ReplacesAllowedIPs(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	_, allowed, _ := net.ParseCIDR("10.0.0.2/32")
+
+	existing := wgtypes.Peer{PublicKey: key.PublicKey(), AllowedIPs: []net.IPNet{*allowed}}
+	cfgs := recreatePeer(existing, wgtypes.PeerConfig{PublicKey: existing.PublicKey, ReplaceAllowedIPs: true})
+
+	assert.Empty(t, cfgs[1].AllowedIPs)
+	assert.Nil(t, cfgs[1].PresharedKey)
+}
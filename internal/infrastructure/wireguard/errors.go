@@ -0,0 +1,45 @@
+package wireguard
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+// isNotExist handles the various "device not found" errors across platforms.
+func isNotExist(err error) bool {
+	if os.IsNotExist(err) || errors.Is(err, syscall.ENODEV) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "does not exist") ||
+		strings.Contains(msg, "no such file") ||
+		strings.Contains(msg, "not found")
+}
+
+// deviceError converts a wgctrl error for a device into a domain error.
+func deviceError(name string, err error) error {
+	switch {
+	case isNotExist(err):
+		return domain.NotFound(entity.ErrCodeDeviceNotFound, "device %s not found", name)
+	case errors.Is(err, os.ErrPermission):
+		return domain.Permission(entity.ErrCodePermissionDenied, "access to device %s denied: %w", name, err)
+	default:
+		return domain.BackendUnavailable(entity.ErrCodeBackendUnavailable, "failed to get device %s: %w", name, err)
+	}
+}
+
+// configureError converts a wgctrl ConfigureDevice error into a domain error.
+func configureError(name string, err error) error {
+	if errors.Is(err, syscall.EINVAL) {
+		return domain.Validation(entity.ErrCodeValidationFailed, "invalid configuration for device %s: %w", name, err)
+	}
+	if isNotExist(err) || errors.Is(err, os.ErrPermission) {
+		return deviceError(name, err)
+	}
+	return domain.BackendUnavailable(entity.ErrCodeBackendUnavailable, "failed to configure device %s: %w", name, err)
+}
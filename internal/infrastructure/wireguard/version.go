@@ -0,0 +1,22 @@
+package wireguard
+
+import (
+	"os"
+	"strings"
+)
+
+// moduleVersionPath is where Linux exposes the version of the WireGuard module.
+var moduleVersionPath = "/sys/module/wireguard/version"
+
+// ModuleVersion returns the version of the WireGuard kernel module, or
+// "unknown" if it isn't loaded or the platform doesn't expose it.
+func ModuleVersion() string {
+	data, err := os.ReadFile(moduleVersionPath)
+	if err != nil {
+		return "unknown"
+	}
+	if version := strings.TrimSpace(string(data)); version != "" {
+		return version
+	}
+	return "unknown"
+}
@@ -0,0 +1,22 @@
+package wireguard
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModuleVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "version")
+	orig := moduleVersionPath
+	moduleVersionPath = path
+	t.Cleanup(func() { moduleVersionPath = orig })
+
+	assert.Equal(t, "unknown", ModuleVersion())
+
+	require.NoError(t, os.WriteFile(path, []byte("1.0.0\n"), 0644))
+	assert.Equal(t, "1.0.0", ModuleVersion())
+}
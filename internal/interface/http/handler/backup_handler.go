@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/interface/http/middleware"
+	"github.com/suquant/wgrest/internal/usecase"
+)
+
+// Backup encryption headers. Keys are sent in headers rather than the query
+// string so they don't end up in access logs.
+const (
+	headerBackupPassphrase = "X-Backup-Passphrase"
+	headerBackupRecipient  = "X-Backup-Recipient"
+	headerBackupIdentity   = "X-Backup-Identity"
+)
+
+// BackupHandler handles HTTP requests for config backups.
+type BackupHandler struct {
+	useCase *usecase.BackupUseCase
+}
+
+// NewBackupHandler creates a new backup handler.
+func NewBackupHandler(uc *usecase.BackupUseCase) *BackupHandler {
+	return &BackupHandler{useCase: uc}
+}
+
+// Backup godoc
+// @Summary Download a backup of all config files
+// @Description Returns a tar.gz of every config file in every config directory with a manifest.
+// @Description With X-Backup-Passphrase or X-Backup-Recipient the archive is age encrypted.
+// @Tags Backup
+// @Produce application/gzip,application/octet-stream
+// @Param X-Backup-Passphrase header string false "Encrypt the archive with a passphrase"
+// @Param X-Backup-Recipient header string false "Encrypt the archive to age recipients (comma separated)"
+// @Success 200 {file} binary
+// @Failure 400 {object} entity.Error
+// @Failure 403 {object} entity.Error
+// @Failure 500 {object} entity.Error
+// @Security BearerAuth
+// @Router /backup/ [get]
+func (h *BackupHandler) Backup(c *fiber.Ctx) error {
+	// Archives contain private keys
+	if !middleware.HasPermission(c, middleware.PermissionSecretsRead) {
+		return c.Status(fiber.StatusForbidden).JSON(entity.Error{
+			Code:    entity.ErrCodeForbidden,
+			Message: "backups require the " + middleware.PermissionSecretsRead + " permission",
+		})
+	}
+
+	filename, err := h.useCase.Backup(
+		c.Response().BodyWriter(),
+		c.Get(headerBackupPassphrase),
+		headerList(c, headerBackupRecipient),
+	)
+	if err != nil {
+		c.Response().ResetBody()
+		return errorResponse(c, err)
+	}
+
+	// Attachment sets the content type from the extension
+	c.Attachment(filename)
+	return nil
+}
+
+// Restore godoc
+// @Summary Restore config files from a backup
+// @Description Validates an archive from GET /backup/ and writes its config files back. Running devices whose
+// @Description config changes are brought to the restored config; config files not in the archive are left alone.
+// @Description With dry_run=true only the changes are returned. Keys in diffs are redacted unless include_secrets=true.
+// @Tags Backup
+// @Accept application/gzip,application/octet-stream
+// @Produce json
+// @Param archive body string true "Backup archive"
+// @Param X-Backup-Passphrase header string false "Passphrase of an encrypted archive"
+// @Param X-Backup-Identity header string false "age identities of an encrypted archive (comma separated)"
+// @Param dry_run query bool false "Only return the changes"
+// @Param include_secrets query bool false "Include keys in diffs (requires secrets:read)"
+// @Success 200 {object} entity.RestoreResult
+// @Failure 400 {object} entity.Error
+// @Failure 403 {object} entity.Error
+// @Failure 422 {object} entity.Error
+// @Failure 500 {object} entity.Error
+// @Failure 503 {object} entity.Error
+// @Security BearerAuth
+// @Router /restore/ [post]
+func (h *BackupHandler) Restore(c *fiber.Ctx) error {
+	includeSecrets, ok := wantSecrets(c)
+	if !ok {
+		return secretsForbidden(c)
+	}
+
+	result, err := h.useCase.Restore(
+		c.UserContext(),
+		bytes.NewReader(c.Body()),
+		c.Get(headerBackupPassphrase),
+		headerList(c, headerBackupIdentity),
+		wantDryRun(c),
+	)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	if !includeSecrets {
+		for i := range result.Devices {
+			result.Devices[i].Diff = redactConfig(result.Devices[i].Diff)
+		}
+	}
+
+	return c.JSON(result)
+}
+
+// headerList returns the comma separated values of all instances of a header.
+func headerList(c *fiber.Ctx, name string) []string {
+	var values []string
+	for _, header := range c.GetReqHeaders()[name] {
+		for _, v := range strings.Split(header, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+	"github.com/suquant/wgrest/internal/interface/http/middleware"
+	"github.com/suquant/wgrest/internal/usecase"
+)
+
+func TestBackup_Download(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "wg0.conf"), []byte("[Interface]\n"), 0600))
+	svc, err := wgquick.NewService([]string{dir})
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Use(middleware.GrantPermissions(middleware.PermissionSecretsRead))
+	app.Get("/backup/", NewBackupHandler(usecase.NewBackupUseCase(nil, svc, "")).Backup)
+
+	testCases := []struct {
+		name        string
+		header      string
+		value       string
+		contentType string
+		expected    int
+	}{
+		{"plain", "", "", "application/gzip", http.StatusOK},
+		{"passphrase", headerBackupPassphrase, "secret", "application/octet-stream", http.StatusOK},
+		{"invalid recipient", headerBackupRecipient, "age1invalid", "application/json", http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/backup/", nil)
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.value)
+			}
+
+			resp, err := app.Test(req, -1)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, resp.StatusCode)
+			assert.Contains(t, resp.Header.Get("Content-Type"), tc.contentType)
+			if tc.expected == http.StatusOK {
+				assert.Contains(t, resp.Header.Get("Content-Disposition"), "wgrest-backup-")
+			}
+		})
+	}
+}
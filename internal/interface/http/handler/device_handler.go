@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"errors"
+
 	"github.com/gofiber/fiber/v2"
 
 	"github.com/suquant/wgrest/internal/domain/entity"
@@ -24,20 +26,31 @@ func NewDeviceHandler(uc *usecase.DeviceUseCase) *DeviceHandler {
 // @Produce json
 // @Param page query int false "Page number" default(0)
 // @Param per_page query int false "Items per page" default(100)
+// @Param include_secrets query bool false "Include private keys (requires secrets:read permission)"
 // @Success 200 {array} entity.Device
+// @Failure 403 {object} entity.Error
 // @Failure 500 {object} entity.Error
+// @Failure 503 {object} entity.Error
 // @Security BearerAuth
 // @Router /devices/ [get]
 func (h *DeviceHandler) ListDevices(c *fiber.Ctx) error {
 	page := c.QueryInt("page", 0)
 	perPage := c.QueryInt("per_page", 100)
 
-	devices, total, err := h.useCase.ListDevices(page, perPage)
+	includeSecrets, ok := wantSecrets(c)
+	if !ok {
+		return secretsForbidden(c)
+	}
+
+	devices, total, err := h.useCase.ListDevices(c.UserContext(), page, perPage)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(entity.Error{
-			Code:    entity.ErrCodeInternalError,
-			Message: err.Error(),
-		})
+		return errorResponse(c, err)
+	}
+
+	if !includeSecrets {
+		for i := range devices {
+			redactDevice(&devices[i])
+		}
 	}
 
 	// Set Link header for pagination
@@ -52,19 +65,35 @@ func (h *DeviceHandler) ListDevices(c *fiber.Ctx) error {
 // @Accept json
 // @Produce json
 // @Param name path string true "Device name"
+// @Param include_secrets query bool false "Include private keys (requires secrets:read permission)"
+// @Param If-None-Match header string false "Return 304 if the device ETag matches"
 // @Success 200 {object} entity.Device
+// @Success 304 "Not Modified"
+// @Header 200 {string} ETag "Device entity tag"
+// @Failure 403 {object} entity.Error
 // @Failure 404 {object} entity.Error
+// @Failure 503 {object} entity.Error
 // @Security BearerAuth
 // @Router /devices/{name}/ [get]
 func (h *DeviceHandler) GetDevice(c *fiber.Ctx) error {
 	name := c.Params("name")
 
-	device, err := h.useCase.GetDevice(name)
+	includeSecrets, ok := wantSecrets(c)
+	if !ok {
+		return secretsForbidden(c)
+	}
+
+	device, err := h.useCase.GetDevice(c.UserContext(), name)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(entity.Error{
-			Code:    entity.ErrCodeDeviceNotFound,
-			Message: err.Error(),
-		})
+		return errorResponse(c, err)
+	}
+
+	if notModified(c, usecase.DeviceETag(device)) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	if !includeSecrets {
+		redactDevice(device)
 	}
 
 	return c.JSON(device)
@@ -76,34 +105,38 @@ func (h *DeviceHandler) GetDevice(c *fiber.Ctx) error {
 // @Accept json
 // @Produce json
 // @Param request body entity.DeviceCreateOrUpdateRequest true "Device creation request"
+// @Param Idempotency-Key header string false "Replay the original response for retries with the same key"
+// @Param dry_run query bool false "Validate and return a DryRunResult with the config diff without applying changes"
+// @Param include_secrets query bool false "Include keys in the dry run result (requires secrets:read permission)"
 // @Success 201 {object} entity.Device
+// @Success 200 {object} entity.DryRunResult "Dry run result"
 // @Failure 400 {object} entity.Error
+// @Failure 403 {object} entity.Error
 // @Failure 409 {object} entity.Error
+// @Failure 422 {object} entity.Error
 // @Failure 500 {object} entity.Error
+// @Failure 501 {object} entity.Error
+// @Failure 503 {object} entity.Error
 // @Security BearerAuth
 // @Router /devices/ [post]
 func (h *DeviceHandler) CreateDevice(c *fiber.Ctx) error {
 	var req entity.DeviceCreateOrUpdateRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(entity.Error{
-			Code:    entity.ErrCodeInvalidRequest,
-			Message: err.Error(),
-		})
+		return badRequest(c, err)
 	}
 
-	device, err := h.useCase.CreateDevice(req)
-	if err != nil {
-		// Check if device already exists
-		if isDeviceExistsError(err) {
-			return c.Status(fiber.StatusConflict).JSON(entity.Error{
-				Code:    entity.ErrCodeDeviceExists,
-				Message: err.Error(),
-			})
+	if wantDryRun(c) {
+		includeSecrets, ok := wantSecrets(c)
+		if !ok {
+			return secretsForbidden(c)
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(entity.Error{
-			Code:    entity.ErrCodeInternalError,
-			Message: err.Error(),
-		})
+		result, err := h.useCase.PlanCreateDevice(c.UserContext(), req)
+		return dryRunResponse(c, result, err, includeSecrets)
+	}
+
+	device, err := h.useCase.CreateDevice(c.UserContext(), req)
+	if err != nil {
+		return errorResponse(c, err)
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(device)
@@ -112,32 +145,52 @@ func (h *DeviceHandler) CreateDevice(c *fiber.Ctx) error {
 // UpdateDevice godoc
 // @Summary Update a device
 // @Tags Devices
-// @Accept json
+// @Accept json,application/merge-patch+json
 // @Produce json
 // @Param name path string true "Device name"
-// @Param request body entity.DeviceCreateOrUpdateRequest true "Device update request"
+// @Param request body entity.DeviceCreateOrUpdateRequest true "Device update request (null clears a field with application/merge-patch+json)"
+// @Param include_secrets query bool false "Include private keys (requires secrets:read permission)"
+// @Param If-Match header string false "Only update if the device ETag matches"
+// @Param dry_run query bool false "Validate and return a DryRunResult with the config diff without applying changes"
 // @Success 200 {object} entity.Device
+// @Header 200 {string} ETag "Device entity tag after the update"
 // @Failure 400 {object} entity.Error
+// @Failure 403 {object} entity.Error
 // @Failure 404 {object} entity.Error
+// @Failure 412 {object} entity.Error
+// @Failure 422 {object} entity.Error
+// @Failure 503 {object} entity.Error
 // @Security BearerAuth
 // @Router /devices/{name}/ [patch]
 func (h *DeviceHandler) UpdateDevice(c *fiber.Ctx) error {
 	name := c.Params("name")
 
+	includeSecrets, ok := wantSecrets(c)
+	if !ok {
+		return secretsForbidden(c)
+	}
+
 	var req entity.DeviceCreateOrUpdateRequest
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(entity.Error{
-			Code:    entity.ErrCodeInvalidRequest,
-			Message: err.Error(),
-		})
+	nulls, err := parsePatch(c, &req)
+	if err != nil {
+		return badRequest(c, err)
 	}
+	req.NullFields = nulls
+	ifMatch := c.Get(fiber.HeaderIfMatch)
 
-	device, err := h.useCase.UpdateDevice(name, req)
+	if wantDryRun(c) {
+		result, err := h.useCase.PlanUpdateDevice(c.UserContext(), name, req, ifMatch)
+		return dryRunResponse(c, result, err, includeSecrets)
+	}
+
+	device, err := h.useCase.UpdateDevice(c.UserContext(), name, req, ifMatch)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(entity.Error{
-			Code:    entity.ErrCodeDeviceNotFound,
-			Message: err.Error(),
-		})
+		return errorResponse(c, err)
+	}
+	c.Set(fiber.HeaderETag, usecase.DeviceETag(device))
+
+	if !includeSecrets {
+		redactDevice(device)
 	}
 
 	return c.JSON(device)
@@ -147,18 +200,32 @@ func (h *DeviceHandler) UpdateDevice(c *fiber.Ctx) error {
 // @Summary Delete a device
 // @Tags Devices
 // @Param name path string true "Device name"
+// @Param If-Match header string false "Only delete if the device ETag matches"
+// @Param dry_run query bool false "Validate and return a DryRunResult with the config diff without applying changes"
+// @Param include_secrets query bool false "Include keys in the dry run result (requires secrets:read permission)"
 // @Success 204 "No Content"
+// @Failure 403 {object} entity.Error
 // @Failure 404 {object} entity.Error
+// @Failure 412 {object} entity.Error
+// @Failure 501 {object} entity.Error
+// @Failure 503 {object} entity.Error
 // @Security BearerAuth
 // @Router /devices/{name}/ [delete]
 func (h *DeviceHandler) DeleteDevice(c *fiber.Ctx) error {
 	name := c.Params("name")
+	ifMatch := c.Get(fiber.HeaderIfMatch)
 
-	if err := h.useCase.DeleteDevice(name); err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(entity.Error{
-			Code:    entity.ErrCodeDeviceNotFound,
-			Message: err.Error(),
-		})
+	if wantDryRun(c) {
+		includeSecrets, ok := wantSecrets(c)
+		if !ok {
+			return secretsForbidden(c)
+		}
+		result, err := h.useCase.PlanDeleteDevice(c.UserContext(), name, ifMatch)
+		return dryRunResponse(c, result, err, includeSecrets)
+	}
+
+	if err := h.useCase.DeleteDevice(c.UserContext(), name, ifMatch); err != nil {
+		return errorResponse(c, err)
 	}
 
 	return c.SendStatus(fiber.StatusNoContent)
@@ -170,17 +237,18 @@ func (h *DeviceHandler) DeleteDevice(c *fiber.Ctx) error {
 // @Produce json
 // @Param name path string true "Device name"
 // @Success 200 {object} map[string]string
+// @Failure 403 {object} entity.Error
+// @Failure 404 {object} entity.Error
+// @Failure 409 {object} entity.Error
 // @Failure 500 {object} entity.Error
+// @Failure 503 {object} entity.Error
 // @Security BearerAuth
 // @Router /devices/{name}/up/ [post]
 func (h *DeviceHandler) Up(c *fiber.Ctx) error {
 	name := c.Params("name")
 
-	if err := h.useCase.Up(name); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(entity.Error{
-			Code:    entity.ErrCodeInternalError,
-			Message: err.Error(),
-		})
+	if err := h.useCase.Up(c.UserContext(), name); err != nil {
+		return errorResponse(c, err)
 	}
 
 	return c.JSON(fiber.Map{"status": "up", "interface": name})
@@ -192,36 +260,262 @@ func (h *DeviceHandler) Up(c *fiber.Ctx) error {
 // @Produce json
 // @Param name path string true "Device name"
 // @Success 200 {object} map[string]string
+// @Failure 403 {object} entity.Error
+// @Failure 404 {object} entity.Error
 // @Failure 500 {object} entity.Error
+// @Failure 503 {object} entity.Error
 // @Security BearerAuth
 // @Router /devices/{name}/down/ [post]
 func (h *DeviceHandler) Down(c *fiber.Ctx) error {
 	name := c.Params("name")
 
-	if err := h.useCase.Down(name); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(entity.Error{
-			Code:    entity.ErrCodeInternalError,
-			Message: err.Error(),
-		})
+	if err := h.useCase.Down(c.UserContext(), name); err != nil {
+		return errorResponse(c, err)
 	}
 
 	return c.JSON(fiber.Map{"status": "down", "interface": name})
 }
 
-func isDeviceExistsError(err error) bool {
-	return err != nil && (err.Error() == "device already exists" ||
-		contains(err.Error(), "already exists"))
+// GetDeviceSpec godoc
+// @Summary Get the declarative spec of a device
+// @Description Returns the device settings and all peers as JSON or YAML (Accept: application/yaml).
+// @Tags Devices
+// @Produce json,application/yaml
+// @Param name path string true "Device name"
+// @Param include_secrets query bool false "Include private and preshared keys (requires secrets:read permission)"
+// @Success 200 {object} entity.DeviceSpec
+// @Failure 403 {object} entity.Error
+// @Failure 404 {object} entity.Error
+// @Failure 503 {object} entity.Error
+// @Security BearerAuth
+// @Router /devices/{name}/spec/ [get]
+func (h *DeviceHandler) GetDeviceSpec(c *fiber.Ctx) error {
+	name := c.Params("name")
+
+	includeSecrets, ok := wantSecrets(c)
+	if !ok {
+		return secretsForbidden(c)
+	}
+
+	spec, err := h.useCase.GetDeviceSpec(c.UserContext(), name)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	if !includeSecrets {
+		spec.PrivateKey = ""
+		for i := range spec.Peers {
+			spec.Peers[i].PresharedKey = ""
+		}
+	}
+
+	return writeDocument(c, fiber.StatusOK, spec)
 }
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && searchSubstring(s, substr)
+// ApplyDeviceSpec godoc
+// @Summary Apply the declarative spec of a device
+// @Description Brings the device to the given settings and peers, changing only what differs, like wg syncconf.
+// @Description Peers not listed are removed. Omitted private and preshared keys, listen port and peer endpoints
+// @Description keep their current values. The spec of a device that isn't running is applied to its config file.
+// @Description The body may be JSON or YAML (Content-Type: application/yaml).
+// @Tags Devices
+// @Accept json,application/yaml
+// @Produce json,application/yaml
+// @Param name path string true "Device name"
+// @Param request body entity.DeviceSpec true "Device spec"
+// @Param dry_run query bool false "Only compute the changes"
+// @Success 200 {object} entity.DeviceSpecResult
+// @Failure 400 {object} entity.Error
+// @Failure 404 {object} entity.Error
+// @Failure 409 {object} entity.Error
+// @Failure 422 {object} entity.Error
+// @Failure 503 {object} entity.Error
+// @Security BearerAuth
+// @Router /devices/{name}/spec/ [put]
+func (h *DeviceHandler) ApplyDeviceSpec(c *fiber.Ctx) error {
+	name := c.Params("name")
+
+	var spec entity.DeviceSpec
+	if err := parseDocument(c, &spec); err != nil {
+		return badRequest(c, err)
+	}
+
+	result, err := h.useCase.ApplyDeviceSpec(c.UserContext(), name, spec, wantDryRun(c))
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	return writeDocument(c, fiber.StatusOK, result)
 }
 
-func searchSubstring(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
+// ListConfigRevisions godoc
+// @Summary List config revisions of a device
+// @Description Every config write keeps a revision, newest first, up to --config-history-limit per device.
+// @Tags Devices
+// @Produce json
+// @Param name path string true "Device name"
+// @Success 200 {array} entity.ConfigRevision
+// @Failure 422 {object} entity.Error
+// @Failure 500 {object} entity.Error
+// @Failure 501 {object} entity.Error
+// @Security BearerAuth
+// @Router /devices/{name}/revisions/ [get]
+func (h *DeviceHandler) ListConfigRevisions(c *fiber.Ctx) error {
+	revisions, err := h.useCase.ListConfigRevisions(c.UserContext(), c.Params("name"))
+	if err != nil {
+		return errorResponse(c, err)
 	}
-	return false
+
+	return c.JSON(revisions)
+}
+
+// DiffConfigRevisions godoc
+// @Summary Diff two config revisions of a device
+// @Description Keys in the diff are redacted unless include_secrets=true.
+// @Tags Devices
+// @Produce json
+// @Param name path string true "Device name"
+// @Param from query string true "Old revision ID"
+// @Param to query string false "New revision ID (default: the current config file)"
+// @Param include_secrets query bool false "Include keys (requires secrets:read permission)"
+// @Success 200 {object} entity.ConfigRevisionDiff
+// @Failure 400 {object} entity.Error
+// @Failure 403 {object} entity.Error
+// @Failure 404 {object} entity.Error
+// @Failure 501 {object} entity.Error
+// @Security BearerAuth
+// @Router /devices/{name}/revisions/diff/ [get]
+func (h *DeviceHandler) DiffConfigRevisions(c *fiber.Ctx) error {
+	includeSecrets, ok := wantSecrets(c)
+	if !ok {
+		return secretsForbidden(c)
+	}
+
+	from := c.Query("from")
+	if from == "" {
+		return badRequest(c, errors.New("from is required"))
+	}
+
+	diff, err := h.useCase.DiffConfigRevisions(c.UserContext(), c.Params("name"), from, c.Query("to"))
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	if !includeSecrets {
+		diff.Diff = redactConfig(diff.Diff)
+	}
+
+	return c.JSON(diff)
+}
+
+// RollbackConfig godoc
+// @Summary Roll a device back to a config revision
+// @Description Restores the config file and, if the device is running, brings it to the revision.
+// @Description Keys in the diff are redacted unless include_secrets=true.
+// @Tags Devices
+// @Produce json
+// @Param name path string true "Device name"
+// @Param id path string true "Revision ID"
+// @Param dry_run query bool false "Only compute the changes"
+// @Param include_secrets query bool false "Include keys (requires secrets:read permission)"
+// @Success 200 {object} entity.ConfigRollbackResult
+// @Failure 403 {object} entity.Error
+// @Failure 404 {object} entity.Error
+// @Failure 409 {object} entity.Error
+// @Failure 422 {object} entity.Error
+// @Failure 501 {object} entity.Error
+// @Failure 503 {object} entity.Error
+// @Security BearerAuth
+// @Router /devices/{name}/revisions/{id}/rollback/ [post]
+func (h *DeviceHandler) RollbackConfig(c *fiber.Ctx) error {
+	includeSecrets, ok := wantSecrets(c)
+	if !ok {
+		return secretsForbidden(c)
+	}
+
+	result, err := h.useCase.RollbackConfig(c.UserContext(), c.Params("name"), c.Params("id"), wantDryRun(c))
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	if !includeSecrets {
+		result.Diff = redactConfig(result.Diff)
+	}
+
+	return c.JSON(result)
+}
+
+// GetDrift godoc
+// @Summary Compare a device's config file with its running interface
+// @Description Returns the interface settings and peers (keys, port, fwmark, allowed IPs, endpoints, keepalives)
+// @Description that differ. Keys are redacted unless include_secrets=true.
+// @Tags Devices
+// @Produce json
+// @Param name path string true "Device name"
+// @Param include_secrets query bool false "Include keys (requires secrets:read permission)"
+// @Success 200 {object} entity.DeviceDrift
+// @Failure 403 {object} entity.Error
+// @Failure 404 {object} entity.Error
+// @Failure 503 {object} entity.Error
+// @Security BearerAuth
+// @Router /devices/{name}/drift/ [get]
+func (h *DeviceHandler) GetDrift(c *fiber.Ctx) error {
+	includeSecrets, ok := wantSecrets(c)
+	if !ok {
+		return secretsForbidden(c)
+	}
+
+	drift, err := h.useCase.GetDrift(c.UserContext(), c.Params("name"))
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	if !includeSecrets {
+		redactDrift(drift)
+	}
+
+	return c.JSON(drift)
+}
+
+// SyncDevice godoc
+// @Summary Sync a device's config file and running interface
+// @Description With to=kernel the running interface is brought to the config file, like wg syncconf.
+// @Description With to=file the running interface is written to the config file, keeping its wg-quick options.
+// @Description Keys in the drift are redacted unless include_secrets=true.
+// @Tags Devices
+// @Produce json
+// @Param name path string true "Device name"
+// @Param to query string true "What to bring in line: kernel or file"
+// @Param dry_run query bool false "Only compute the drift and changes"
+// @Param include_secrets query bool false "Include keys (requires secrets:read permission)"
+// @Success 200 {object} entity.DeviceSyncResult
+// @Failure 400 {object} entity.Error
+// @Failure 403 {object} entity.Error
+// @Failure 404 {object} entity.Error
+// @Failure 409 {object} entity.Error
+// @Failure 422 {object} entity.Error
+// @Failure 503 {object} entity.Error
+// @Security BearerAuth
+// @Router /devices/{name}/sync/ [post]
+func (h *DeviceHandler) SyncDevice(c *fiber.Ctx) error {
+	includeSecrets, ok := wantSecrets(c)
+	if !ok {
+		return secretsForbidden(c)
+	}
+
+	to := c.Query("to")
+	if to != entity.SyncToKernel && to != entity.SyncToFile {
+		return badRequest(c, errors.New("to must be kernel or file"))
+	}
+
+	result, err := h.useCase.SyncDevice(c.UserContext(), c.Params("name"), to, wantDryRun(c))
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	if !includeSecrets {
+		redactDrift(&result.Drift)
+	}
+
+	return c.JSON(result)
 }
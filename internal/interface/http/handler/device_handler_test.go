@@ -13,6 +13,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
 	"github.com/suquant/wgrest/internal/usecase"
 )
 
@@ -150,31 +151,37 @@ func TestDeviceHandler_UpdateDevice_InvalidJSON(t *testing.T) {
 	assert.Equal(t, entity.ErrCodeInvalidRequest, errResp.Code)
 }
 
-func TestIsDeviceExistsError(t *testing.T) {
-	testCases := []struct {
-		name     string
-		err      error
-		expected bool
-	}{
-		{"nil error", nil, false},
-		{"device already exists", assert.AnError, false},
-		{"contains already exists", &mockError{msg: "device already exists"}, true},
-		{"contains already exists mid", &mockError{msg: "the device already exists here"}, true},
-		{"other error", &mockError{msg: "some other error"}, false},
-	}
+func TestDeviceHandler_DiffConfigRevisions_MissingFrom(t *testing.T) {
+	app := fiber.New()
+	handler := &DeviceHandler{}
+	app.Get("/devices/:name/revisions/diff/", handler.DiffConfigRevisions)
 
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			result := isDeviceExistsError(tc.err)
-			assert.Equal(t, tc.expected, result)
-		})
-	}
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/devices/wg0/revisions/diff/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 }
 
-type mockError struct {
-	msg string
+func TestDeviceHandler_SyncDevice_InvalidTarget(t *testing.T) {
+	app := fiber.New()
+	handler := &DeviceHandler{}
+	app.Post("/devices/:name/sync/", handler.SyncDevice)
+
+	for _, target := range []string{"", "disk"} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodPost, "/devices/wg0/sync/?to="+target, nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, target)
+	}
 }
 
-func (e *mockError) Error() string {
-	return e.msg
+func TestDeviceHandler_ListConfigRevisions_Disabled(t *testing.T) {
+	svc, err := wgquick.NewService([]string{t.TempDir()})
+	require.NoError(t, err)
+
+	app := fiber.New()
+	handler := NewDeviceHandler(usecase.NewDeviceUseCase(nil, svc, nil))
+	app.Get("/devices/:name/revisions/", handler.ListConfigRevisions)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/devices/wg0/revisions/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
 }
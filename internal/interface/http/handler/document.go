@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// mimeYAML is the content type of YAML documents.
+const mimeYAML = "application/yaml"
+
+// yamlTypes are the content types accepted as YAML.
+var yamlTypes = []string{mimeYAML, "application/x-yaml", "text/yaml", "text/x-yaml"}
+
+// parseDocument decodes a JSON or YAML body into out, depending on its content
+// type. Unknown fields are rejected so typos don't silently drop settings.
+func parseDocument(c *fiber.Ctx, out any) error {
+	ctype, _, _ := strings.Cut(c.Get(fiber.HeaderContentType), ";")
+	ctype = strings.ToLower(strings.TrimSpace(ctype))
+
+	for _, t := range yamlTypes {
+		if ctype == t {
+			dec := yaml.NewDecoder(bytes.NewReader(c.Body()))
+			dec.KnownFields(true)
+			if err := dec.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+				return err
+			}
+			return nil
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(c.Body()))
+	dec.DisallowUnknownFields()
+	return dec.Decode(out)
+}
+
+// writeDocument writes v as YAML if the client prefers it, otherwise as JSON.
+func writeDocument(c *fiber.Ctx, status int, v any) error {
+	offers := append([]string{fiber.MIMEApplicationJSON}, yamlTypes...)
+	if accepted := c.Accepts(offers...); accepted != "" && accepted != fiber.MIMEApplicationJSON {
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, accepted)
+		return c.Status(status).Send(data)
+	}
+	return c.Status(status).JSON(v)
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+func TestParseDocument(t *testing.T) {
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		wantErr     bool
+	}{
+		{
+			name:        "json",
+			contentType: "application/json",
+			body:        `{"listen_port":51820,"peers":[{"public_key":"a","allowed_ips":["10.0.0.2/32"]}]}`,
+		},
+		{
+			name:        "yaml",
+			contentType: "application/yaml; charset=utf-8",
+			body:        "listen_port: 51820\npeers:\n  - public_key: a\n    allowed_ips: [10.0.0.2/32]\n",
+		},
+		{
+			name:        "unknown json field",
+			contentType: "application/json",
+			body:        `{"listen_prot":51820}`,
+			wantErr:     true,
+		},
+		{
+			name:        "unknown yaml field",
+			contentType: "text/yaml",
+			body:        "peers:\n  - public_key: a\n    allowed_ip: 10.0.0.2/32\n",
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := fiber.New()
+			var spec entity.DeviceSpec
+			var parseErr error
+			app.Put("/", func(c *fiber.Ctx) error {
+				parseErr = parseDocument(c, &spec)
+				return nil
+			})
+
+			req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			_, err := app.Test(req)
+			require.NoError(t, err)
+
+			if tc.wantErr {
+				assert.Error(t, parseErr)
+				return
+			}
+			require.NoError(t, parseErr)
+			assert.Equal(t, int32(51820), spec.ListenPort)
+			assert.Equal(t, []entity.PeerSpec{{PublicKey: "a", AllowedIPs: []string{"10.0.0.2/32"}}}, spec.Peers)
+		})
+	}
+}
+
+func TestWriteDocument(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return writeDocument(c, fiber.StatusOK, entity.DeviceSpec{
+			Name:  "wg0",
+			Peers: []entity.PeerSpec{{PublicKey: "a", PersistentKeepaliveInterval: "25s"}},
+		})
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/yaml")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, "application/yaml", resp.Header.Get("Content-Type"))
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "name: wg0\npeers:\n    - public_key: a\n      persistent_keepalive_interval: 25s\n", string(body))
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.MIMEApplicationJSON, resp.Header.Get("Content-Type"))
+}
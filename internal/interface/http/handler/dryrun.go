@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+// wantDryRun reports whether the caller asked for a dry run with ?dry_run=true.
+func wantDryRun(c *fiber.Ctx) bool {
+	return c.QueryBool("dry_run")
+}
+
+// dryRunResponse writes the result of a dry run. Secrets in the resulting
+// device, peer and config diff are redacted unless includeSecrets is set.
+func dryRunResponse(c *fiber.Ctx, result *entity.DryRunResult, err error, includeSecrets bool) error {
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	if !includeSecrets {
+		if result.Device != nil {
+			redactDevice(result.Device)
+		}
+		if result.Peer != nil {
+			redactPeer(result.Peer)
+		}
+		result.ConfigDiff = redactConfig(result.ConfigDiff)
+	}
+
+	return c.JSON(result)
+}
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/suquant/wgrest/internal/usecase"
+)
+
+// DumpHandler handles HTTP requests for config dump status.
+type DumpHandler struct {
+	useCase *usecase.DumpUseCase
+}
+
+// NewDumpHandler creates a new dump handler.
+func NewDumpHandler(uc *usecase.DumpUseCase) *DumpHandler {
+	return &DumpHandler{useCase: uc}
+}
+
+// GetDumpStatus godoc
+// @Summary Get config dump status
+// @Description Returns when each device's config file was last checked and written, whether a save is pending
+// @Description and the error of the last save.
+// @Tags Dump
+// @Produce json
+// @Success 200 {array} entity.DumpStatus
+// @Security BearerAuth
+// @Router /dump/ [get]
+func (h *DumpHandler) GetDumpStatus(c *fiber.Ctx) error {
+	return c.JSON(h.useCase.Status())
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+// errorStatuses maps domain error kinds, and the errors of request contexts
+// that timed out or were canceled, to HTTP statuses and default codes.
+var errorStatuses = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{domain.ErrNotFound, fiber.StatusNotFound, entity.ErrCodeNotFound},
+	{domain.ErrAlreadyExists, fiber.StatusConflict, entity.ErrCodeConflict},
+	{domain.ErrConflict, fiber.StatusConflict, entity.ErrCodeConflict},
+	{domain.ErrValidation, fiber.StatusUnprocessableEntity, entity.ErrCodeValidationFailed},
+	{domain.ErrPermission, fiber.StatusForbidden, entity.ErrCodePermissionDenied},
+	{domain.ErrBackendUnavailable, fiber.StatusServiceUnavailable, entity.ErrCodeBackendUnavailable},
+	{domain.ErrPreconditionFailed, fiber.StatusPreconditionFailed, entity.ErrCodePreconditionFailed},
+	{domain.ErrNotSupported, fiber.StatusNotImplemented, entity.ErrCodeNotSupported},
+	{context.DeadlineExceeded, fiber.StatusGatewayTimeout, entity.ErrCodeTimeout},
+	{context.Canceled, fiber.StatusServiceUnavailable, entity.ErrCodeCanceled},
+}
+
+// errorStatus returns the HTTP status and stable code for an error.
+// Errors that aren't domain errors are internal errors.
+func errorStatus(err error) (int, string) {
+	for _, s := range errorStatuses {
+		if errors.Is(err, s.kind) {
+			code := domain.Code(err)
+			if code == "" {
+				code = s.code
+			}
+			return s.status, code
+		}
+	}
+	return fiber.StatusInternalServerError, entity.ErrCodeInternalError
+}
+
+// errorResponse writes err as an entity.Error with the mapped status.
+func errorResponse(c *fiber.Ctx, err error) error {
+	status, code := errorStatus(err)
+	return c.Status(status).JSON(entity.Error{
+		Code:    code,
+		Message: err.Error(),
+		Fields:  domain.Fields(err),
+	})
+}
+
+// badRequest writes a 400 response for malformed requests.
+func badRequest(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusBadRequest).JSON(entity.Error{
+		Code:    entity.ErrCodeInvalidRequest,
+		Message: err.Error(),
+	})
+}
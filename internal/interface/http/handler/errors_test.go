@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/usecase"
+)
+
+func TestErrorStatus(t *testing.T) {
+	testCases := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"device not found", domain.NotFound(entity.ErrCodeDeviceNotFound, "device wg0 not found"), http.StatusNotFound, entity.ErrCodeDeviceNotFound},
+		{"peer not found", domain.NotFound(entity.ErrCodePeerNotFound, "peer not found"), http.StatusNotFound, entity.ErrCodePeerNotFound},
+		{"device exists", domain.AlreadyExists(entity.ErrCodeDeviceExists, "device wg0 already exists"), http.StatusConflict, entity.ErrCodeDeviceExists},
+		{"conflict", domain.Conflict(entity.ErrCodeConflict, "interface is up"), http.StatusConflict, entity.ErrCodeConflict},
+		{"invalid key", domain.Validation(entity.ErrCodeInvalidKey, "invalid public key"), http.StatusUnprocessableEntity, entity.ErrCodeInvalidKey},
+		{"permission", domain.Permission(entity.ErrCodePermissionDenied, "requires root"), http.StatusForbidden, entity.ErrCodePermissionDenied},
+		{"backend unavailable", domain.BackendUnavailable(entity.ErrCodeBackendUnavailable, "netlink"), http.StatusServiceUnavailable, entity.ErrCodeBackendUnavailable},
+		{"precondition failed", domain.PreconditionFailed(entity.ErrCodePreconditionFailed, "changed"), http.StatusPreconditionFailed, entity.ErrCodePreconditionFailed},
+		{"not supported", domain.NotSupported(entity.ErrCodeNotSupported, "delete"), http.StatusNotImplemented, entity.ErrCodeNotSupported},
+		{"wrapped domain error", fmt.Errorf("create: %w", domain.NotFound(entity.ErrCodeDeviceNotFound, "missing")), http.StatusNotFound, entity.ErrCodeDeviceNotFound},
+		{"bare sentinel", fmt.Errorf("lookup: %w", domain.ErrNotFound), http.StatusNotFound, entity.ErrCodeNotFound},
+		{"timeout", fmt.Errorf("wg-quick up wg0: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, entity.ErrCodeTimeout},
+		{"canceled", context.Canceled, http.StatusServiceUnavailable, entity.ErrCodeCanceled},
+		{"plain error", errors.New("device wg0 not found"), http.StatusInternalServerError, entity.ErrCodeInternalError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, code := errorStatus(tc.err)
+			assert.Equal(t, tc.expectedStatus, status)
+			assert.Equal(t, tc.expectedCode, code)
+		})
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return errorResponse(c, domain.Validation(entity.ErrCodeInvalidKey, "invalid public key: %w", errors.New("bad length")))
+	})
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	body, _ := io.ReadAll(resp.Body)
+	var errResp entity.Error
+	require.NoError(t, json.Unmarshal(body, &errResp))
+	assert.Equal(t, entity.ErrCodeInvalidKey, errResp.Code)
+	assert.Equal(t, "invalid public key: bad length", errResp.Message)
+}
+
+func TestCreateDevice_ValidationFields(t *testing.T) {
+	app := setupDeviceTestApp(usecase.NewDeviceUseCase(nil, nil, nil))
+
+	body := `{"name":"wg0","listen_port":70000,"mtu":-1,"addresses":["10.0.0.1/24","nope"]}`
+	req := httptest.NewRequest(http.MethodPost, "/devices/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	respBody, _ := io.ReadAll(resp.Body)
+	var errResp entity.Error
+	require.NoError(t, json.Unmarshal(respBody, &errResp))
+	assert.Equal(t, entity.ErrCodeValidationFailed, errResp.Code)
+	assert.Equal(t, []entity.FieldError{
+		{Field: "listen_port", Code: entity.FieldCodeOutOfRange, Message: "listen port must be between 0 and 65535"},
+		{Field: "addresses[1]", Code: entity.FieldCodeInvalidCIDR, Message: `"nope" is not an IP address or CIDR`},
+		{Field: "mtu", Code: entity.FieldCodeOutOfRange, Message: "mtu must be 0 (auto) or between 576 and 65535"},
+	}, errResp.Fields)
+}
+
+func TestCreatePeer_ValidationFields(t *testing.T) {
+	app := fiber.New()
+	h := NewPeerHandler(usecase.NewPeerUseCase(nil, nil, nil))
+	app.Post("/devices/:name/peers/", h.CreatePeer)
+
+	body := `{"allowed_ips":["10.0.0.2"],"persistent_keepalive_interval":"soon"}`
+	req := httptest.NewRequest(http.MethodPost, "/devices/wg0/peers/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	respBody, _ := io.ReadAll(resp.Body)
+	var errResp entity.Error
+	require.NoError(t, json.Unmarshal(respBody, &errResp))
+	require.Len(t, errResp.Fields, 2)
+	assert.Equal(t, "allowed_ips[0]", errResp.Fields[0].Field)
+	assert.Equal(t, "persistent_keepalive_interval", errResp.Fields[1].Field)
+}
+
+func TestBatchPeers_ValidationFields(t *testing.T) {
+	app := fiber.New()
+	h := NewPeerHandler(usecase.NewPeerUseCase(nil, nil, nil))
+	app.Post("/devices/:name/peers/batch/", h.BatchPeers)
+
+	body := `{"mode":"all","operations":[]}`
+	req := httptest.NewRequest(http.MethodPost, "/devices/wg0/peers/batch/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	respBody, _ := io.ReadAll(resp.Body)
+	var errResp entity.Error
+	require.NoError(t, json.Unmarshal(respBody, &errResp))
+	require.Len(t, errResp.Fields, 2)
+	assert.Equal(t, "mode", errResp.Fields[0].Field)
+	assert.Equal(t, "operations", errResp.Fields[1].Field)
+}
+
+func TestApplyDeviceSpec_ValidationFields(t *testing.T) {
+	app := fiber.New()
+	h := NewDeviceHandler(usecase.NewDeviceUseCase(nil, nil, nil))
+	app.Put("/devices/:name/spec/", h.ApplyDeviceSpec)
+
+	body := "name: wg1\npeers:\n  - allowed_ips: [10.0.0.2/32]\n"
+	req := httptest.NewRequest(http.MethodPut, "/devices/wg0/spec/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/yaml")
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	respBody, _ := io.ReadAll(resp.Body)
+	var errResp entity.Error
+	require.NoError(t, json.Unmarshal(respBody, &errResp))
+	require.Len(t, errResp.Fields, 2)
+	assert.Equal(t, "name", errResp.Fields[0].Field)
+	assert.Equal(t, "peers[0].public_key", errResp.Fields[1].Field)
+}
+
+func TestImportState_ValidationFields(t *testing.T) {
+	app := fiber.New()
+	h := NewStateHandler(usecase.NewStateUseCase(nil, nil, ""))
+	app.Put("/state/", h.ImportState)
+
+	body := `{"version": 1, "devices": [{"name": "wg0", "peers": []}]}`
+	req := httptest.NewRequest(http.MethodPut, "/state/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	respBody, _ := io.ReadAll(resp.Body)
+	var errResp entity.Error
+	require.NoError(t, json.Unmarshal(respBody, &errResp))
+	require.Len(t, errResp.Fields, 1)
+	assert.Equal(t, "devices[0].private_key", errResp.Fields[0].Field)
+}
+
+func TestRestore_InvalidArchive(t *testing.T) {
+	app := fiber.New()
+	h := NewBackupHandler(usecase.NewBackupUseCase(nil, nil, ""))
+	app.Post("/restore/", h.Restore)
+
+	req := httptest.NewRequest(http.MethodPost, "/restore/", strings.NewReader("not an archive"))
+	req.Header.Set("Content-Type", "application/gzip")
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	respBody, _ := io.ReadAll(resp.Body)
+	var errResp entity.Error
+	require.NoError(t, json.Unmarshal(respBody, &errResp))
+	assert.Equal(t, entity.ErrCodeInvalidBackup, errResp.Code)
+}
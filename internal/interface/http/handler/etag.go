@@ -0,0 +1,15 @@
+package handler
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/suquant/wgrest/internal/usecase"
+)
+
+// notModified sets the ETag header and reports whether the request's
+// If-None-Match header matches it.
+func notModified(c *fiber.Ctx, etag string) bool {
+	c.Set(fiber.HeaderETag, etag)
+	header := c.Get(fiber.HeaderIfNoneMatch)
+	return header != "" && usecase.ETagMatches(header, etag, true)
+}
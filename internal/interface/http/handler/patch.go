@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// mimeMergePatch is the content type of a JSON Merge Patch (RFC 7396).
+const mimeMergePatch = "application/merge-patch+json"
+
+// parsePatch parses a PATCH body into out. For merge patches it also returns
+// the top-level fields set to null; plain JSON bodies treat null as omitted.
+func parsePatch(c *fiber.Ctx, out any) ([]string, error) {
+	if !isMergePatch(c) {
+		return nil, c.BodyParser(out)
+	}
+
+	nulls, err := nullFields(c.Body())
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(c.Body(), out); err != nil {
+		return nil, err
+	}
+	return nulls, nil
+}
+
+// nullFields returns the sorted top-level fields of a JSON object set to null.
+func nullFields(data []byte) ([]string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	var nulls []string
+	for name, value := range fields {
+		if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			nulls = append(nulls, name)
+		}
+	}
+	sort.Strings(nulls)
+	return nulls, nil
+}
+
+func isMergePatch(c *fiber.Ctx) bool {
+	ctype, _, _ := strings.Cut(c.Get(fiber.HeaderContentType), ";")
+	return strings.EqualFold(strings.TrimSpace(ctype), mimeMergePatch)
+}
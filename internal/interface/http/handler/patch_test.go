@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/usecase"
+)
+
+func TestParsePatch(t *testing.T) {
+	testCases := []struct {
+		name          string
+		contentType   string
+		body          string
+		expectedNulls []string
+		expectedMTU   *int32
+		expectedDNS   []string
+	}{
+		{
+			name:          "merge patch records nulls",
+			contentType:   "application/merge-patch+json",
+			body:          `{"dns": null, "pre_up": null, "mtu": 1420}`,
+			expectedNulls: []string{"dns", "pre_up"},
+			expectedMTU:   ptr(int32(1420)),
+		},
+		{
+			name:          "merge patch with charset",
+			contentType:   "application/merge-patch+json; charset=utf-8",
+			body:          `{"table": null}`,
+			expectedNulls: []string{"table"},
+		},
+		{
+			name:        "plain json ignores nulls",
+			contentType: "application/json",
+			body:        `{"dns": null, "mtu": 1420}`,
+			expectedMTU: ptr(int32(1420)),
+		},
+		{
+			name:        "merge patch without nulls",
+			contentType: "application/merge-patch+json",
+			body:        `{"dns": ["1.1.1.1"]}`,
+			expectedDNS: []string{"1.1.1.1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				nulls []string
+				req   entity.DeviceCreateOrUpdateRequest
+			)
+			app := fiber.New()
+			app.Patch("/", func(c *fiber.Ctx) error {
+				var err error
+				nulls, err = parsePatch(c, &req)
+				if err != nil {
+					return badRequest(c, err)
+				}
+				return c.SendStatus(fiber.StatusNoContent)
+			})
+
+			httpReq := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(tc.body))
+			httpReq.Header.Set("Content-Type", tc.contentType)
+			resp, err := app.Test(httpReq)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+			assert.Equal(t, tc.expectedNulls, nulls)
+			assert.Equal(t, tc.expectedMTU, req.MTU)
+			assert.Equal(t, tc.expectedDNS, req.DNS)
+		})
+	}
+}
+
+func TestParsePatch_InvalidJSON(t *testing.T) {
+	app := fiber.New()
+	app.Patch("/", func(c *fiber.Ctx) error {
+		var req entity.PeerCreateOrUpdateRequest
+		if _, err := parsePatch(c, &req); err != nil {
+			return badRequest(c, err)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(`[1, 2]`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestUpdatePeer_MergePatchCannotClearPublicKey(t *testing.T) {
+	app := fiber.New()
+	h := NewPeerHandler(usecase.NewPeerUseCase(nil, nil, nil))
+	app.Patch("/devices/:name/peers/:urlSafePubKey/", h.UpdatePeer)
+
+	req := httptest.NewRequest(http.MethodPatch, "/devices/wg0/peers/key/", strings.NewReader(`{"public_key": null}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestParsePeerBatch(t *testing.T) {
+	body := `{"mode":"per_item","operations":[
+		{"op":"create","peer":{"allowed_ips":["10.0.0.2/32"]}},
+		{"op":"update","public_key":"a","peer":{"endpoint":null,"preshared_key":null}},
+		{"op":"delete","public_key":"b"}
+	]}`
+
+	req, err := parsePeerBatch([]byte(body))
+	require.NoError(t, err)
+
+	assert.Equal(t, entity.PeerBatchPerItem, req.Mode)
+	require.Len(t, req.Operations, 3)
+	assert.Equal(t, []string{"10.0.0.2/32"}, req.Operations[0].Peer.AllowedIPs)
+	assert.Empty(t, req.Operations[0].Peer.NullFields)
+	assert.Equal(t, []string{"endpoint", "preshared_key"}, req.Operations[1].Peer.NullFields)
+	assert.Equal(t, "b", req.Operations[2].PublicKey)
+
+	_, err = parsePeerBatch([]byte(`{"operations":[{"op":"update","peer":[]}]}`))
+	assert.Error(t, err)
+}
@@ -1,8 +1,11 @@
 package handler
 
 import (
+	"encoding/json"
+
 	"github.com/gofiber/fiber/v2"
 
+	"github.com/suquant/wgrest/internal/domain"
 	"github.com/suquant/wgrest/internal/domain/entity"
 	"github.com/suquant/wgrest/internal/usecase"
 )
@@ -27,9 +30,12 @@ func NewPeerHandler(uc *usecase.PeerUseCase) *PeerHandler {
 // @Param per_page query int false "Items per page" default(100)
 // @Param q query string false "Search by allowed IPs"
 // @Param sort query string false "Sort field (prefix with - for desc)" Enums(pub_key, -pub_key, receive_bytes, -receive_bytes, transmit_bytes, -transmit_bytes, total_bytes, -total_bytes, last_handshake_time, -last_handshake_time)
+// @Param include_secrets query bool false "Include private and preshared keys (requires secrets:read permission)"
 // @Success 200 {array} entity.Peer
+// @Failure 403 {object} entity.Error
 // @Failure 404 {object} entity.Error
 // @Failure 500 {object} entity.Error
+// @Failure 503 {object} entity.Error
 // @Security BearerAuth
 // @Router /devices/{name}/peers/ [get]
 func (h *PeerHandler) ListPeers(c *fiber.Ctx) error {
@@ -39,18 +45,20 @@ func (h *PeerHandler) ListPeers(c *fiber.Ctx) error {
 	query := c.Query("q")
 	sort := c.Query("sort")
 
-	peers, total, err := h.useCase.ListPeers(deviceName, page, perPage, query, sort)
+	includeSecrets, ok := wantSecrets(c)
+	if !ok {
+		return secretsForbidden(c)
+	}
+
+	peers, total, err := h.useCase.ListPeers(c.UserContext(), deviceName, page, perPage, query, sort)
 	if err != nil {
-		if isDeviceNotFoundError(err) {
-			return c.Status(fiber.StatusNotFound).JSON(entity.Error{
-				Code:    entity.ErrCodeDeviceNotFound,
-				Message: err.Error(),
-			})
+		return errorResponse(c, err)
+	}
+
+	if !includeSecrets {
+		for i := range peers {
+			redactPeer(&peers[i])
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(entity.Error{
-			Code:    entity.ErrCodeInternalError,
-			Message: err.Error(),
-		})
 	}
 
 	// Set Link header for pagination
@@ -66,20 +74,37 @@ func (h *PeerHandler) ListPeers(c *fiber.Ctx) error {
 // @Produce json
 // @Param name path string true "Device name"
 // @Param urlSafePubKey path string true "URL-safe base64 encoded public key"
+// @Param include_secrets query bool false "Include private and preshared keys (requires secrets:read permission)"
+// @Param If-None-Match header string false "Return 304 if the peer ETag matches"
 // @Success 200 {object} entity.Peer
+// @Success 304 "Not Modified"
+// @Header 200 {string} ETag "Peer entity tag"
+// @Failure 403 {object} entity.Error
 // @Failure 404 {object} entity.Error
+// @Failure 422 {object} entity.Error
+// @Failure 503 {object} entity.Error
 // @Security BearerAuth
 // @Router /devices/{name}/peers/{urlSafePubKey}/ [get]
 func (h *PeerHandler) GetPeer(c *fiber.Ctx) error {
 	deviceName := c.Params("name")
 	urlSafePubKey := c.Params("urlSafePubKey")
 
-	peer, err := h.useCase.GetPeer(deviceName, urlSafePubKey)
+	includeSecrets, ok := wantSecrets(c)
+	if !ok {
+		return secretsForbidden(c)
+	}
+
+	peer, err := h.useCase.GetPeer(c.UserContext(), deviceName, urlSafePubKey)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(entity.Error{
-			Code:    entity.ErrCodePeerNotFound,
-			Message: err.Error(),
-		})
+		return errorResponse(c, err)
+	}
+
+	if notModified(c, usecase.PeerETag(peer)) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	if !includeSecrets {
+		redactPeer(peer)
 	}
 
 	return c.JSON(peer)
@@ -92,10 +117,18 @@ func (h *PeerHandler) GetPeer(c *fiber.Ctx) error {
 // @Produce json
 // @Param name path string true "Device name"
 // @Param request body entity.PeerCreateOrUpdateRequest true "Peer creation request"
+// @Param Idempotency-Key header string false "Replay the original response for retries with the same key"
+// @Param dry_run query bool false "Validate and return a DryRunResult with the config diff without applying changes"
+// @Param include_secrets query bool false "Include keys in the dry run result (requires secrets:read permission)"
 // @Success 201 {object} entity.Peer
+// @Success 200 {object} entity.DryRunResult "Dry run result"
 // @Failure 400 {object} entity.Error
+// @Failure 403 {object} entity.Error
 // @Failure 404 {object} entity.Error
+// @Failure 409 {object} entity.Error
+// @Failure 422 {object} entity.Error
 // @Failure 500 {object} entity.Error
+// @Failure 503 {object} entity.Error
 // @Security BearerAuth
 // @Router /devices/{name}/peers/ [post]
 func (h *PeerHandler) CreatePeer(c *fiber.Ctx) error {
@@ -103,24 +136,21 @@ func (h *PeerHandler) CreatePeer(c *fiber.Ctx) error {
 
 	var req entity.PeerCreateOrUpdateRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(entity.Error{
-			Code:    entity.ErrCodeInvalidRequest,
-			Message: err.Error(),
-		})
+		return badRequest(c, err)
 	}
 
-	peer, err := h.useCase.CreatePeer(deviceName, req)
-	if err != nil {
-		if isDeviceNotFoundError(err) {
-			return c.Status(fiber.StatusNotFound).JSON(entity.Error{
-				Code:    entity.ErrCodeDeviceNotFound,
-				Message: err.Error(),
-			})
+	if wantDryRun(c) {
+		includeSecrets, ok := wantSecrets(c)
+		if !ok {
+			return secretsForbidden(c)
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(entity.Error{
-			Code:    entity.ErrCodeInternalError,
-			Message: err.Error(),
-		})
+		result, err := h.useCase.PlanCreatePeer(c.UserContext(), deviceName, req)
+		return dryRunResponse(c, result, err, includeSecrets)
+	}
+
+	peer, err := h.useCase.CreatePeer(c.UserContext(), deviceName, req)
+	if err != nil {
+		return errorResponse(c, err)
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(peer)
@@ -129,34 +159,54 @@ func (h *PeerHandler) CreatePeer(c *fiber.Ctx) error {
 // UpdatePeer godoc
 // @Summary Update a peer
 // @Tags Peers
-// @Accept json
+// @Accept json,application/merge-patch+json
 // @Produce json
 // @Param name path string true "Device name"
 // @Param urlSafePubKey path string true "URL-safe base64 encoded public key"
-// @Param request body entity.PeerCreateOrUpdateRequest true "Peer update request"
+// @Param request body entity.PeerCreateOrUpdateRequest true "Peer update request (null clears a field with application/merge-patch+json)"
+// @Param include_secrets query bool false "Include private and preshared keys (requires secrets:read permission)"
+// @Param If-Match header string false "Only update if the peer ETag matches"
+// @Param dry_run query bool false "Validate and return a DryRunResult with the config diff without applying changes"
 // @Success 200 {object} entity.Peer
+// @Header 200 {string} ETag "Peer entity tag after the update"
 // @Failure 400 {object} entity.Error
+// @Failure 403 {object} entity.Error
 // @Failure 404 {object} entity.Error
+// @Failure 412 {object} entity.Error
+// @Failure 422 {object} entity.Error
+// @Failure 503 {object} entity.Error
 // @Security BearerAuth
 // @Router /devices/{name}/peers/{urlSafePubKey}/ [patch]
 func (h *PeerHandler) UpdatePeer(c *fiber.Ctx) error {
 	deviceName := c.Params("name")
 	urlSafePubKey := c.Params("urlSafePubKey")
 
+	includeSecrets, ok := wantSecrets(c)
+	if !ok {
+		return secretsForbidden(c)
+	}
+
 	var req entity.PeerCreateOrUpdateRequest
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(entity.Error{
-			Code:    entity.ErrCodeInvalidRequest,
-			Message: err.Error(),
-		})
+	nulls, err := parsePatch(c, &req)
+	if err != nil {
+		return badRequest(c, err)
+	}
+	req.NullFields = nulls
+	ifMatch := c.Get(fiber.HeaderIfMatch)
+
+	if wantDryRun(c) {
+		result, err := h.useCase.PlanUpdatePeer(c.UserContext(), deviceName, urlSafePubKey, req, ifMatch)
+		return dryRunResponse(c, result, err, includeSecrets)
 	}
 
-	peer, err := h.useCase.UpdatePeer(deviceName, urlSafePubKey, req)
+	peer, err := h.useCase.UpdatePeer(c.UserContext(), deviceName, urlSafePubKey, req, ifMatch)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(entity.Error{
-			Code:    entity.ErrCodePeerNotFound,
-			Message: err.Error(),
-		})
+		return errorResponse(c, err)
+	}
+	c.Set(fiber.HeaderETag, usecase.PeerETag(peer))
+
+	if !includeSecrets {
+		redactPeer(peer)
 	}
 
 	return c.JSON(peer)
@@ -167,27 +217,133 @@ func (h *PeerHandler) UpdatePeer(c *fiber.Ctx) error {
 // @Tags Peers
 // @Param name path string true "Device name"
 // @Param urlSafePubKey path string true "URL-safe base64 encoded public key"
+// @Param include_secrets query bool false "Include private and preshared keys (requires secrets:read permission)"
+// @Param If-Match header string false "Only delete if the peer ETag matches"
+// @Param dry_run query bool false "Validate and return a DryRunResult with the config diff without applying changes"
 // @Success 200 {object} entity.Peer
+// @Failure 403 {object} entity.Error
 // @Failure 404 {object} entity.Error
+// @Failure 412 {object} entity.Error
+// @Failure 422 {object} entity.Error
+// @Failure 503 {object} entity.Error
 // @Security BearerAuth
 // @Router /devices/{name}/peers/{urlSafePubKey}/ [delete]
 func (h *PeerHandler) DeletePeer(c *fiber.Ctx) error {
 	deviceName := c.Params("name")
 	urlSafePubKey := c.Params("urlSafePubKey")
 
-	peer, err := h.useCase.DeletePeer(deviceName, urlSafePubKey)
+	includeSecrets, ok := wantSecrets(c)
+	if !ok {
+		return secretsForbidden(c)
+	}
+	ifMatch := c.Get(fiber.HeaderIfMatch)
+
+	if wantDryRun(c) {
+		result, err := h.useCase.PlanDeletePeer(c.UserContext(), deviceName, urlSafePubKey, ifMatch)
+		return dryRunResponse(c, result, err, includeSecrets)
+	}
+
+	peer, err := h.useCase.DeletePeer(c.UserContext(), deviceName, urlSafePubKey, ifMatch)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(entity.Error{
-			Code:    entity.ErrCodePeerNotFound,
-			Message: err.Error(),
-		})
+		return errorResponse(c, err)
+	}
+
+	if !includeSecrets {
+		redactPeer(peer)
 	}
 
 	return c.JSON(peer)
 }
 
-func isDeviceNotFoundError(err error) bool {
-	return err != nil && contains(err.Error(), "not found")
+// BatchPeers godoc
+// @Summary Create, update and delete peers in one request
+// @Description Operations are applied with a single device configuration and the config is saved once.
+// @Description In atomic mode (default) nothing is applied if any operation fails and the response status
+// @Description is that of the first failure; in per_item mode the valid operations are applied.
+// @Description Null fields in an update clear them as in a merge patch.
+// @Tags Peers
+// @Accept json
+// @Produce json
+// @Param name path string true "Device name"
+// @Param request body entity.PeerBatchRequest true "Peer operations"
+// @Param include_secrets query bool false "Include private and preshared keys of updated and deleted peers (requires secrets:read permission)"
+// @Param Idempotency-Key header string false "Replay the original response for retries with the same key"
+// @Success 200 {object} entity.PeerBatchResponse
+// @Failure 400 {object} entity.Error
+// @Failure 403 {object} entity.Error
+// @Failure 404 {object} entity.Error
+// @Failure 409 {object} entity.PeerBatchResponse
+// @Failure 422 {object} entity.PeerBatchResponse
+// @Failure 503 {object} entity.Error
+// @Security BearerAuth
+// @Router /devices/{name}/peers/batch/ [post]
+func (h *PeerHandler) BatchPeers(c *fiber.Ctx) error {
+	deviceName := c.Params("name")
+
+	includeSecrets, ok := wantSecrets(c)
+	if !ok {
+		return secretsForbidden(c)
+	}
+
+	req, err := parsePeerBatch(c.Body())
+	if err != nil {
+		return badRequest(c, err)
+	}
+
+	resp, err := h.useCase.BatchPeers(c.UserContext(), deviceName, req)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	status := fiber.StatusOK
+	for i := range resp.Results {
+		result := &resp.Results[i]
+		if result.Err != nil {
+			itemStatus, code := errorStatus(result.Err)
+			if status == fiber.StatusOK && resp.Mode == entity.PeerBatchAtomic {
+				status = itemStatus
+			}
+			result.Error = &entity.Error{
+				Code:    code,
+				Message: result.Err.Error(),
+				Fields:  domain.Fields(result.Err),
+			}
+		}
+
+		// Keys are returned on create, as with a single peer
+		if result.Peer != nil && result.Op != entity.PeerBatchCreate && !includeSecrets {
+			redactPeer(result.Peer)
+		}
+	}
+
+	return c.Status(status).JSON(resp)
 }
 
+// parsePeerBatch parses a batch request, recording the peer fields each
+// operation sets to null.
+func parsePeerBatch(body []byte) (entity.PeerBatchRequest, error) {
+	var req entity.PeerBatchRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return req, err
+	}
 
+	var raw struct {
+		Operations []struct {
+			Peer json.RawMessage `json:"peer"`
+		} `json:"operations"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return req, err
+	}
+	for i, op := range raw.Operations {
+		if len(op.Peer) == 0 {
+			continue
+		}
+		nulls, err := nullFields(op.Peer)
+		if err != nil {
+			return req, err
+		}
+		req.Operations[i].Peer.NullFields = nulls
+	}
+	return req, nil
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"regexp"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/interface/http/middleware"
+)
+
+// wantSecrets reports whether the caller asked for secrets with ?include_secrets=true.
+// ok is false if the caller asked but lacks the secrets:read permission.
+func wantSecrets(c *fiber.Ctx) (include bool, ok bool) {
+	include = c.QueryBool("include_secrets")
+	if include && !middleware.HasPermission(c, middleware.PermissionSecretsRead) {
+		return false, false
+	}
+	return include, true
+}
+
+// secretsForbidden responds with 403 for callers without the secrets:read permission.
+func secretsForbidden(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(entity.Error{
+		Code:    entity.ErrCodeForbidden,
+		Message: "include_secrets requires the " + middleware.PermissionSecretsRead + " permission",
+	})
+}
+
+// redactDevice removes the interface private key.
+func redactDevice(device *entity.Device) {
+	device.PrivateKey = ""
+}
+
+// redactPeer removes the peer private and preshared keys.
+func redactPeer(peer *entity.Peer) {
+	peer.PrivateKey = ""
+	peer.PresharedKey = ""
+}
+
+// configSecretRe matches key lines in wg-quick configs and diffs of them.
+var configSecretRe = regexp.MustCompile(`(?m)^([ +-]?(?:PrivateKey|PresharedKey)\s*=\s*)\S.*$`)
+
+// redactConfig replaces private and preshared keys in a wg-quick config or diff.
+func redactConfig(config string) string {
+	return configSecretRe.ReplaceAllString(config, "${1}(redacted)")
+}
+
+// redactDrift replaces key values in a drift report.
+func redactDrift(drift *entity.DeviceDrift) {
+	redactFields(drift.Fields)
+	for i := range drift.Peers {
+		redactFields(drift.Peers[i].Fields)
+	}
+}
+
+func redactFields(fields []entity.DriftField) {
+	for i := range fields {
+		f := &fields[i]
+		if f.Field != "private_key" && f.Field != "preshared_key" {
+			continue
+		}
+		if f.File != "" {
+			f.File = "(redacted)"
+		}
+		if f.Running != "" {
+			f.Running = "(redacted)"
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/interface/http/middleware"
+)
+
+func TestWantSecrets(t *testing.T) {
+	testCases := []struct {
+		name        string
+		query       string
+		permissions []string
+		expected    int
+	}{
+		{"not requested", "", nil, http.StatusOK},
+		{"requested without permission", "?include_secrets=true", nil, http.StatusForbidden},
+		{"requested with permission", "?include_secrets=true", []string{middleware.PermissionSecretsRead}, http.StatusAccepted},
+		{"not requested with permission", "?include_secrets=false", []string{middleware.PermissionSecretsRead}, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(middleware.GrantPermissions(tc.permissions...))
+			app.Get("/", func(c *fiber.Ctx) error {
+				include, ok := wantSecrets(c)
+				if !ok {
+					return secretsForbidden(c)
+				}
+				if include {
+					return c.SendStatus(fiber.StatusAccepted)
+				}
+				return c.SendStatus(fiber.StatusOK)
+			})
+
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/"+tc.query, nil))
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, resp.StatusCode)
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	device := entity.Device{Name: "wg0", PublicKey: "pub", PrivateKey: "priv"}
+	redactDevice(&device)
+	assert.Empty(t, device.PrivateKey)
+	assert.Equal(t, "pub", device.PublicKey)
+
+	peer := entity.Peer{PublicKey: "pub", PrivateKey: "priv", PresharedKey: "psk"}
+	redactPeer(&peer)
+	assert.Empty(t, peer.PrivateKey)
+	assert.Empty(t, peer.PresharedKey)
+	assert.Equal(t, "pub", peer.PublicKey)
+}
+
+func TestRedactConfig(t *testing.T) {
+	diff := `--- a/wg0.conf
++++ b/wg0.conf
+@@ -1,4 +1,4 @@
+ [Interface]
+-PrivateKey = aGVsbG8=
++PrivateKey = d29ybGQ=
+ ListenPort = 51820
+ PresharedKey = c2VjcmV0
+`
+
+	assert.Equal(t, `--- a/wg0.conf
++++ b/wg0.conf
+@@ -1,4 +1,4 @@
+ [Interface]
+-PrivateKey = (redacted)
++PrivateKey = (redacted)
+ ListenPort = 51820
+ PresharedKey = (redacted)
+`, redactConfig(diff))
+}
+
+func TestRedactDrift(t *testing.T) {
+	drift := &entity.DeviceDrift{
+		Fields: []entity.DriftField{
+			{Field: "private_key", File: "aGVsbG8=", Running: "d29ybGQ="},
+			{Field: "listen_port", File: "51820", Running: "51821"},
+		},
+		Peers: []entity.PeerDrift{{
+			PublicKey: "cGVlcg==",
+			State:     entity.PeerDriftChanged,
+			Fields:    []entity.DriftField{{Field: "preshared_key", File: "c2VjcmV0"}},
+		}},
+	}
+
+	redactDrift(drift)
+	assert.Equal(t, entity.DriftField{Field: "private_key", File: "(redacted)", Running: "(redacted)"}, drift.Fields[0])
+	assert.Equal(t, entity.DriftField{Field: "listen_port", File: "51820", Running: "51821"}, drift.Fields[1])
+	assert.Equal(t, entity.DriftField{Field: "preshared_key", File: "(redacted)"}, drift.Peers[0].Fields[0])
+}
+
+func TestExportState_RequiresSecretsPermission(t *testing.T) {
+	app := fiber.New()
+	h := NewStateHandler(nil)
+	app.Get("/state/", h.ExportState)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/state/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestBackup_RequiresSecretsPermission(t *testing.T) {
+	app := fiber.New()
+	h := NewBackupHandler(nil)
+	app.Get("/backup/", h.Backup)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/backup/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/interface/http/middleware"
+	"github.com/suquant/wgrest/internal/usecase"
+)
+
+// StateHandler handles HTTP requests for exporting and importing server state.
+type StateHandler struct {
+	useCase *usecase.StateUseCase
+}
+
+// NewStateHandler creates a new state handler.
+func NewStateHandler(uc *usecase.StateUseCase) *StateHandler {
+	return &StateHandler{useCase: uc}
+}
+
+// ExportState godoc
+// @Summary Export every device and peer
+// @Description Returns a versioned document with all devices, their peers and keys as JSON or YAML (Accept: application/yaml).
+// @Tags State
+// @Produce json,application/yaml
+// @Success 200 {object} entity.State
+// @Failure 403 {object} entity.Error
+// @Failure 500 {object} entity.Error
+// @Failure 503 {object} entity.Error
+// @Security BearerAuth
+// @Router /state/ [get]
+func (h *StateHandler) ExportState(c *fiber.Ctx) error {
+	// The export contains private keys
+	if !middleware.HasPermission(c, middleware.PermissionSecretsRead) {
+		return c.Status(fiber.StatusForbidden).JSON(entity.Error{
+			Code:    entity.ErrCodeForbidden,
+			Message: "exporting state requires the " + middleware.PermissionSecretsRead + " permission",
+		})
+	}
+
+	state, err := h.useCase.ExportState(c.UserContext())
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	return writeDocument(c, fiber.StatusOK, state)
+}
+
+// ImportState godoc
+// @Summary Import devices and peers from an exported state
+// @Description Running devices are brought to their exported spec. For other devices the config file is written
+// @Description and the interface brought up if it was running when exported. Devices not in the state are left alone.
+// @Tags State
+// @Accept json,application/yaml
+// @Produce json,application/yaml
+// @Param request body entity.State true "Exported state"
+// @Success 200 {object} entity.StateImportResult
+// @Failure 400 {object} entity.Error
+// @Failure 403 {object} entity.Error
+// @Failure 409 {object} entity.Error
+// @Failure 422 {object} entity.Error
+// @Failure 500 {object} entity.Error
+// @Failure 503 {object} entity.Error
+// @Security BearerAuth
+// @Router /state/ [put]
+func (h *StateHandler) ImportState(c *fiber.Ctx) error {
+	var state entity.State
+	if err := parseDocument(c, &state); err != nil {
+		return badRequest(c, err)
+	}
+
+	result, err := h.useCase.ImportState(c.UserContext(), state)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	return writeDocument(c, fiber.StatusOK, result)
+}
@@ -0,0 +1,119 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// unixPrefix marks a listen address as a Unix domain socket path.
+const unixPrefix = "unix:"
+
+// ListenConfig contains listener settings.
+type ListenConfig struct {
+	// Address is host:port for TCP or unix:/path/to.sock for a Unix socket
+	Address string
+
+	// SocketMode is the Unix socket file mode
+	SocketMode fs.FileMode
+
+	// SocketGroup is the Unix socket group name or GID (unchanged if empty)
+	SocketGroup string
+}
+
+// IsUnixAddress reports whether the listen address is a Unix domain socket.
+func IsUnixAddress(address string) bool {
+	return strings.HasPrefix(address, unixPrefix)
+}
+
+// Listen creates a TCP or Unix domain socket listener.
+func Listen(cfg ListenConfig) (net.Listener, error) {
+	if !IsUnixAddress(cfg.Address) {
+		return net.Listen("tcp", cfg.Address)
+	}
+
+	path := strings.TrimPrefix(cfg.Address, unixPrefix)
+	if path == "" {
+		return nil, fmt.Errorf("unix socket path is empty")
+	}
+
+	// Remove a stale socket left by an unclean shutdown
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	// The socket starts out owner only; the group is set before the mode
+	// opens it up
+	ln, err := listenUnix(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.SocketGroup != "" {
+		gid, err := lookupGroupID(cfg.SocketGroup)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		if err := os.Chown(path, -1, gid); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to set socket group: %w", err)
+		}
+	}
+
+	if err := os.Chmod(path, cfg.SocketMode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket mode: %w", err)
+	}
+
+	return ln, nil
+}
+
+// ParseFileMode parses an octal file mode such as "0660".
+func ParseFileMode(value string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid file mode %q: %w", value, err)
+	}
+	return fs.FileMode(mode) & fs.ModePerm, nil
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	// Refuse to steal the socket of a running instance
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+
+	return os.Remove(path)
+}
+
+func lookupGroupID(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up group %s: %w", group, err)
+	}
+
+	return strconv.Atoi(g.Gid)
+}
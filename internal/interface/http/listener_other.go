@@ -0,0 +1,11 @@
+//go:build !unix
+
+package http
+
+import "net"
+
+// listenUnix creates a Unix socket; platforms without umask rely on the
+// mode set afterwards.
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListen_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wgrest.sock")
+
+	ln, err := Listen(ListenConfig{Address: "unix:" + path, SocketMode: 0600})
+	require.NoError(t, err)
+	defer ln.Close()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode()&fs.ModeSocket)
+	assert.Equal(t, fs.FileMode(0600), info.Mode().Perm())
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func TestListen_RemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wgrest.sock")
+
+	// Leave a socket file behind without unlinking it
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := Listen(ListenConfig{Address: "unix:" + path, SocketMode: 0660})
+	require.NoError(t, err)
+	ln.Close()
+}
+
+func TestListen_SocketInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wgrest.sock")
+
+	ln, err := Listen(ListenConfig{Address: "unix:" + path, SocketMode: 0660})
+	require.NoError(t, err)
+	defer ln.Close()
+
+	_, err = Listen(ListenConfig{Address: "unix:" + path, SocketMode: 0660})
+	assert.Error(t, err)
+}
+
+func TestListen_NotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wgrest.sock")
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0600))
+
+	_, err := Listen(ListenConfig{Address: "unix:" + path, SocketMode: 0660})
+	assert.Error(t, err)
+}
+
+func TestParseFileMode(t *testing.T) {
+	mode, err := ParseFileMode("0660")
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0660), mode)
+
+	mode, err = ParseFileMode("600")
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0600), mode)
+
+	_, err = ParseFileMode("rw-rw----")
+	assert.Error(t, err)
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package http
+
+import (
+	"net"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// umaskMu serializes umask changes, which apply to the whole process.
+var umaskMu sync.Mutex
+
+// listenUnix creates a Unix socket only its owner can connect to, so no
+// other process gets in before the requested mode and group are set.
+func listenUnix(path string) (net.Listener, error) {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+
+	old := unix.Umask(0177)
+	defer unix.Umask(old)
+
+	return net.Listen("unix", path)
+}
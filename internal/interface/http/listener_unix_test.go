@@ -0,0 +1,25 @@
+//go:build unix
+
+package http
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnix_OwnerOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wgrest.sock")
+
+	ln, err := listenUnix(path)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0600), info.Mode().Perm())
+}
@@ -1,6 +1,9 @@
 package middleware
 
 import (
+	"crypto/subtle"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -8,36 +11,88 @@ import (
 	"github.com/suquant/wgrest/internal/domain/entity"
 )
 
+// BearerAuthConfig contains configuration for the Bearer token middleware.
+type BearerAuthConfig struct {
+	// Token is the expected Bearer token
+	Token string
+
+	// Permissions are granted to callers presenting the token
+	Permissions []string
+
+	// Lockout blocks source IPs, or process UIDs on a Unix socket, after
+	// repeated failures (disabled if nil)
+	Lockout *AuthLockout
+}
+
 // BearerAuth creates a middleware that validates Bearer tokens.
 func BearerAuth(token string) fiber.Handler {
+	return BearerAuthWithConfig(BearerAuthConfig{Token: token})
+}
+
+// BearerAuthWithConfig creates a middleware that validates Bearer tokens,
+// grants the configured permissions and optionally locks out callers after
+// repeated failures, keyed by ClientKey.
+func BearerAuthWithConfig(cfg BearerAuthConfig) fiber.Handler {
+	token := cfg.Token
+	lockout := cfg.Lockout
+
 	return func(c *fiber.Ctx) error {
-		auth := c.Get("Authorization")
+		// Already authorized, e.g. by Unix socket peer credentials
+		if Authenticated(c) {
+			return c.Next()
+		}
 
-		if auth == "" {
+		client := ClientKey(c)
+
+		if lockout != nil {
+			if remaining, locked := lockout.Locked(client); locked {
+				return tooManyFailures(c, remaining.Seconds())
+			}
+		}
+
+		unauthorized := func(message string) error {
+			if lockout != nil && lockout.Fail(client) {
+				remaining, _ := lockout.Locked(client)
+				return tooManyFailures(c, remaining.Seconds())
+			}
 			return c.Status(fiber.StatusUnauthorized).JSON(entity.Error{
 				Code:    entity.ErrCodeUnauthorized,
-				Message: "missing authorization header",
+				Message: message,
 			})
 		}
 
+		auth := c.Get("Authorization")
+
+		if auth == "" {
+			return unauthorized("missing authorization header")
+		}
+
 		// Check for Bearer prefix
 		const prefix = "Bearer "
 		if !strings.HasPrefix(auth, prefix) {
-			return c.Status(fiber.StatusUnauthorized).JSON(entity.Error{
-				Code:    entity.ErrCodeUnauthorized,
-				Message: "invalid authorization format",
-			})
+			return unauthorized("invalid authorization format")
 		}
 
 		// Extract and validate token
 		providedToken := auth[len(prefix):]
-		if providedToken != token {
-			return c.Status(fiber.StatusUnauthorized).JSON(entity.Error{
-				Code:    entity.ErrCodeUnauthorized,
-				Message: "invalid token",
-			})
+		if subtle.ConstantTimeCompare([]byte(providedToken), []byte(token)) != 1 {
+			return unauthorized("invalid token")
+		}
+
+		if lockout != nil {
+			lockout.Reset(client)
 		}
 
+		SetPermissions(c, cfg.Permissions)
+
 		return c.Next()
 	}
 }
+
+func tooManyFailures(c *fiber.Ctx, retryAfter float64) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter))))
+	return c.Status(fiber.StatusTooManyRequests).JSON(entity.Error{
+		Code:    entity.ErrCodeTooManyRequests,
+		Message: "too many failed authorization attempts",
+	})
+}
@@ -1,14 +1,18 @@
 package middleware
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
 )
 
 func TestBearerAuth_ValidToken(t *testing.T) {
@@ -106,3 +110,116 @@ func TestBearerAuth_EmptyTokenConfig(t *testing.T) {
 	// Empty token config still requires auth header
 	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 }
+
+func TestBearerAuthWithConfig_LocksAfterThreshold(t *testing.T) {
+	app := fiber.New()
+	app.Use(BearerAuthWithConfig(BearerAuthConfig{Token: "test-token", Lockout: NewAuthLockout(3, time.Minute)}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	send := func(token string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, send("wrong").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, send("wrong").StatusCode)
+
+	// Third failure triggers the lockout
+	resp := send("wrong")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+
+	var errResp entity.Error
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+	assert.Equal(t, entity.ErrCodeTooManyRequests, errResp.Code)
+
+	// Even the valid token is rejected while locked out
+	assert.Equal(t, http.StatusTooManyRequests, send("test-token").StatusCode)
+}
+
+func TestBearerAuthWithConfig_SuccessResetsFailures(t *testing.T) {
+	app := fiber.New()
+	app.Use(BearerAuthWithConfig(BearerAuthConfig{Token: "test-token", Lockout: NewAuthLockout(2, time.Minute)}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	send := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, send("wrong"))
+	assert.Equal(t, http.StatusOK, send("test-token"))
+	assert.Equal(t, http.StatusUnauthorized, send("wrong"))
+}
+
+func TestAuthLockout_Expires(t *testing.T) {
+	now := time.Now()
+	l := NewAuthLockout(1, time.Minute)
+	l.now = func() time.Time { return now }
+
+	assert.True(t, l.Fail("10.0.0.1"))
+
+	remaining, locked := l.Locked("10.0.0.1")
+	assert.True(t, locked)
+	assert.Equal(t, time.Minute, remaining)
+
+	_, locked = l.Locked("10.0.0.2")
+	assert.False(t, locked)
+
+	now = now.Add(time.Minute + time.Second)
+	_, locked = l.Locked("10.0.0.1")
+	assert.False(t, locked)
+}
+
+func TestBearerAuthWithConfig_GrantsPermissions(t *testing.T) {
+	app := fiber.New()
+	app.Use(BearerAuthWithConfig(BearerAuthConfig{
+		Token:       "test-token",
+		Permissions: []string{PermissionSecretsRead},
+	}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		if HasPermission(c, PermissionSecretsRead) {
+			return c.SendString("secrets")
+		}
+		return c.SendString("OK")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "secrets", string(body))
+}
+
+func TestHasPermission_NoneGranted(t *testing.T) {
+	app := fiber.New()
+	app.Use(BearerAuth("test-token"))
+	app.Get("/", func(c *fiber.Ctx) error {
+		if HasPermission(c, PermissionSecretsRead) {
+			return c.SendString("secrets")
+		}
+		return c.SendString("OK")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "OK", string(body))
+}
@@ -0,0 +1,321 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header carrying the idempotency key
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader marks responses replayed from the key store
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// maxIdempotencyKeyLength is the longest accepted idempotency key
+	maxIdempotencyKeyLength = 255
+)
+
+// ResponseCipher encrypts stored responses, such as the key cipher encrypting
+// keys in config files.
+type ResponseCipher interface {
+	Seal(value string) string
+	Open(value string) (string, error)
+}
+
+// IdempotencyConfig configures the Idempotency middleware.
+type IdempotencyConfig struct {
+	// Storage keeps responses by key; use a persistent storage to survive restarts
+	Storage fiber.Storage
+
+	// Cipher encrypts responses carrying private or preshared keys before
+	// they're stored. Without it such responses are only kept in memory.
+	Cipher ResponseCipher
+
+	// Lifetime is how long a key is remembered
+	Lifetime time.Duration
+}
+
+// idempotentResponse is a stored response for an idempotency key.
+type idempotentResponse struct {
+	Fingerprint string `json:"fingerprint"`
+	StatusCode  int    `json:"status_code"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+
+	// Sealed is set if Body is encrypted with the cipher
+	Sealed bool `json:"sealed,omitempty"`
+}
+
+// Idempotency creates a middleware that replays the original response when a
+// request is retried with the same Idempotency-Key header. Keys are scoped to
+// the caller's credentials and the request URL; only successful responses are
+// stored so failed requests can be retried. Responses carrying private or
+// preshared keys are encrypted with cfg.Cipher, or never leave memory.
+func Idempotency(cfg IdempotencyConfig) fiber.Handler {
+	var (
+		mu       sync.Mutex
+		inFlight = make(map[string]bool)
+		secrets  = newMemoryResponses()
+	)
+
+	load := func(storageKey string) ([]byte, error) {
+		if data := secrets.get(storageKey); data != nil {
+			return data, nil
+		}
+		return cfg.Storage.Get(storageKey)
+	}
+
+	return func(c *fiber.Ctx) error {
+		key := c.Get(IdempotencyKeyHeader)
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return c.Status(fiber.StatusBadRequest).JSON(entity.Error{
+				Code:    entity.ErrCodeInvalidRequest,
+				Message: "Idempotency-Key must be at most 255 characters",
+			})
+		}
+
+		storageKey := idempotencyStorageKey(c, key)
+		fingerprint := hashHex(c.Body())
+
+		if replayed, err := replayIdempotent(c, load, cfg.Cipher, storageKey, fingerprint); replayed || err != nil {
+			return err
+		}
+
+		mu.Lock()
+		if inFlight[storageKey] {
+			mu.Unlock()
+			return c.Status(fiber.StatusConflict).JSON(entity.Error{
+				Code:    entity.ErrCodeIdempotencyInProgress,
+				Message: "a request with this Idempotency-Key is still in progress",
+			})
+		}
+		inFlight[storageKey] = true
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			delete(inFlight, storageKey)
+			mu.Unlock()
+		}()
+
+		// The request that held the key may have finished since the first lookup
+		if replayed, err := replayIdempotent(c, load, cfg.Cipher, storageKey, fingerprint); replayed || err != nil {
+			return err
+		}
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		status := c.Response().StatusCode()
+		if status < fiber.StatusOK || status >= fiber.StatusMultipleChoices {
+			return nil
+		}
+
+		res := idempotentResponse{
+			Fingerprint: fingerprint,
+			StatusCode:  status,
+			ContentType: string(c.Response().Header.ContentType()),
+			Body:        c.Response().Body(),
+		}
+		secret := hasSecrets(res.ContentType, res.Body)
+		if secret && cfg.Cipher != nil {
+			res.Body = []byte(cfg.Cipher.Seal(string(res.Body)))
+			res.Sealed = true
+		}
+
+		data, err := json.Marshal(res)
+		if err == nil {
+			if secret && cfg.Cipher == nil {
+				// Keys are lost on restart rather than written in plaintext
+				secrets.set(storageKey, data, cfg.Lifetime)
+			} else {
+				err = cfg.Storage.Set(storageKey, data, cfg.Lifetime)
+			}
+		}
+		if err != nil {
+			// The request succeeded; a retry just won't be replayed
+			log.Printf("Failed to store idempotent response: %v", err)
+		}
+		return nil
+	}
+}
+
+// secretFields are the JSON fields holding private and preshared keys.
+var secretFields = map[string]bool{
+	"private_key":   true,
+	"preshared_key": true,
+}
+
+// configSecretRe matches key lines in wg-quick configs and diffs of them, as
+// found in dry run results.
+var configSecretRe = regexp.MustCompile(`(?m)^[ +-]?(?:PrivateKey|PresharedKey)\s*=\s*(\S.*)$`)
+
+// hasSecrets reports whether a JSON response body carries private or
+// preshared keys.
+func hasSecrets(contentType string, body []byte) bool {
+	if !strings.HasPrefix(contentType, fiber.MIMEApplicationJSON) {
+		return false
+	}
+
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		// Be safe with bodies that can't be checked
+		return true
+	}
+	return hasSecretValue(v)
+}
+
+func hasSecretValue(v any) bool {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, field := range v {
+			if value, ok := field.(string); ok && secretFields[k] && value != "" {
+				return true
+			}
+			if hasSecretValue(field) {
+				return true
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if hasSecretValue(item) {
+				return true
+			}
+		}
+	case string:
+		for _, m := range configSecretRe.FindAllStringSubmatch(v, -1) {
+			if m[1] != "(redacted)" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// memoryResponses keeps responses that mustn't be written to disk until they
+// expire.
+type memoryResponses struct {
+	mu      sync.Mutex
+	entries map[string]memoryResponse
+	now     func() time.Time
+}
+
+type memoryResponse struct {
+	data    []byte
+	expires time.Time
+}
+
+func newMemoryResponses() *memoryResponses {
+	return &memoryResponses{entries: make(map[string]memoryResponse), now: time.Now}
+}
+
+func (m *memoryResponses) get(key string) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok || (!e.expires.IsZero() && m.now().After(e.expires)) {
+		return nil
+	}
+	return e.data
+}
+
+func (m *memoryResponses) set(key string, data []byte, exp time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for k, e := range m.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(m.entries, k)
+		}
+	}
+
+	e := memoryResponse{data: data}
+	if exp > 0 {
+		e.expires = now.Add(exp)
+	}
+	m.entries[key] = e
+}
+
+// replayIdempotent writes the response stored for storageKey, if any. A key
+// reused with a different request body is rejected with 422.
+func replayIdempotent(c *fiber.Ctx, load func(string) ([]byte, error), cipher ResponseCipher, storageKey, fingerprint string) (bool, error) {
+	data, err := load(storageKey)
+	if err != nil || data == nil {
+		return false, err
+	}
+
+	var res idempotentResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		return false, err
+	}
+
+	if res.Fingerprint != fingerprint {
+		return true, c.Status(fiber.StatusUnprocessableEntity).JSON(entity.Error{
+			Code:    entity.ErrCodeIdempotencyKeyReused,
+			Message: "Idempotency-Key was already used for a different request",
+		})
+	}
+
+	if res.Sealed {
+		body, err := openResponse(cipher, res.Body)
+		if err != nil {
+			// Creating the resource again would be worse than failing
+			return true, c.Status(fiber.StatusServiceUnavailable).JSON(entity.Error{
+				Code:    entity.ErrCodeEncryptedKey,
+				Message: "stored response for this Idempotency-Key can't be decrypted: " + err.Error(),
+			})
+		}
+		res.Body = body
+	}
+
+	c.Set(IdempotentReplayedHeader, "true")
+	if res.ContentType != "" {
+		c.Set(fiber.HeaderContentType, res.ContentType)
+	}
+	return true, c.Status(res.StatusCode).Send(res.Body)
+}
+
+// idempotencyStorageKey scopes key to the caller and the request target,
+// including the query so dry runs don't share keys with real requests.
+func idempotencyStorageKey(c *fiber.Ctx, key string) string {
+	caller := c.Get(fiber.HeaderAuthorization)
+	if caller == "" {
+		caller = ClientKey(c)
+	}
+	return "idempotency:" + hashHex([]byte(caller+"\n"+c.Method()+" "+c.OriginalURL()+"\n"+key))
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// openResponse decrypts a sealed response body.
+func openResponse(cipher ResponseCipher, body []byte) ([]byte, error) {
+	if cipher == nil {
+		return nil, errors.New("no master key configured")
+	}
+	opened, err := cipher.Open(string(body))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(opened), nil
+}
@@ -0,0 +1,288 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/storage"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+)
+
+func setupIdempotencyApp(t *testing.T, status int) (*fiber.App, *int) {
+	t.Helper()
+
+	store, err := storage.NewDirStorage("")
+	require.NoError(t, err)
+
+	calls := 0
+	app := fiber.New()
+	app.Post("/peers/", Idempotency(IdempotencyConfig{Storage: store, Lifetime: time.Hour}), func(c *fiber.Ctx) error {
+		calls++
+		return c.Status(status).JSON(fiber.Map{"call": calls})
+	})
+	return app, &calls
+}
+
+func postIdempotent(t *testing.T, app *fiber.App, key, auth, body string) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/peers/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestIdempotency_ReplaysResponse(t *testing.T) {
+	app, calls := setupIdempotencyApp(t, fiber.StatusCreated)
+
+	first := postIdempotent(t, app, "key-1", "Bearer a", `{}`)
+	assert.Equal(t, http.StatusCreated, first.StatusCode)
+	firstBody, _ := io.ReadAll(first.Body)
+
+	second := postIdempotent(t, app, "key-1", "Bearer a", `{}`)
+	assert.Equal(t, http.StatusCreated, second.StatusCode)
+	assert.Equal(t, "true", second.Header.Get(IdempotentReplayedHeader))
+	assert.Equal(t, "application/json", second.Header.Get("Content-Type"))
+	secondBody, _ := io.ReadAll(second.Body)
+
+	assert.Equal(t, firstBody, secondBody)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestIdempotency_ScopedPerKeyAndCaller(t *testing.T) {
+	app, calls := setupIdempotencyApp(t, fiber.StatusCreated)
+
+	postIdempotent(t, app, "key-1", "Bearer a", `{}`)
+	postIdempotent(t, app, "key-2", "Bearer a", `{}`)
+	postIdempotent(t, app, "key-1", "Bearer b", `{}`)
+	postIdempotent(t, app, "", "Bearer a", `{}`)
+	postIdempotent(t, app, "", "Bearer a", `{}`)
+
+	assert.Equal(t, 5, *calls)
+}
+
+func TestIdempotency_RejectsReusedKeyWithDifferentBody(t *testing.T) {
+	app, calls := setupIdempotencyApp(t, fiber.StatusCreated)
+
+	postIdempotent(t, app, "key-1", "Bearer a", `{"allowed_ips":["10.0.0.2/32"]}`)
+	resp := postIdempotent(t, app, "key-1", "Bearer a", `{"allowed_ips":["10.0.0.3/32"]}`)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	var errResp entity.Error
+	body, _ := io.ReadAll(resp.Body)
+	require.NoError(t, json.Unmarshal(body, &errResp))
+	assert.Equal(t, entity.ErrCodeIdempotencyKeyReused, errResp.Code)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestIdempotency_DoesNotStoreFailures(t *testing.T) {
+	app, calls := setupIdempotencyApp(t, fiber.StatusServiceUnavailable)
+
+	postIdempotent(t, app, "key-1", "Bearer a", `{}`)
+	resp := postIdempotent(t, app, "key-1", "Bearer a", `{}`)
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get(IdempotentReplayedHeader))
+	assert.Equal(t, 2, *calls)
+}
+
+func TestIdempotency_KeyTooLong(t *testing.T) {
+	app, calls := setupIdempotencyApp(t, fiber.StatusCreated)
+
+	resp := postIdempotent(t, app, strings.Repeat("k", 256), "Bearer a", `{}`)
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, 0, *calls)
+}
+
+func TestIdempotency_PersistedStorage(t *testing.T) {
+	dir := t.TempDir()
+
+	newApp := func(calls *int) *fiber.App {
+		store, err := storage.NewDirStorage(dir)
+		require.NoError(t, err)
+		app := fiber.New()
+		app.Post("/peers/", Idempotency(IdempotencyConfig{Storage: store, Lifetime: time.Hour}), func(c *fiber.Ctx) error {
+			*calls++
+			return c.Status(fiber.StatusCreated).SendString(fmt.Sprintf("created %d", *calls))
+		})
+		return app
+	}
+
+	calls := 0
+	postIdempotent(t, newApp(&calls), "key-1", "Bearer a", `{}`)
+
+	// A new app with the same store file simulates a restart
+	resp := postIdempotent(t, newApp(&calls), "key-1", "Bearer a", `{}`)
+	body, _ := io.ReadAll(resp.Body)
+
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "created 1", string(body))
+	assert.Equal(t, 1, calls)
+}
+
+// newSecretApp returns an app creating a peer with a generated private key,
+// with idempotency keys stored in dir.
+func newSecretApp(t *testing.T, dir string, cipher ResponseCipher, calls *int) *fiber.App {
+	t.Helper()
+
+	store, err := storage.NewDirStorage(dir)
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Post("/peers/", Idempotency(IdempotencyConfig{Storage: store, Cipher: cipher, Lifetime: time.Hour}), func(c *fiber.Ctx) error {
+		*calls++
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"public_key":  "pub",
+			"private_key": fmt.Sprintf("private-secret-%d", *calls),
+		})
+	})
+	return app
+}
+
+// assertNoSecretsStored fails if any file in dir contains secret.
+func assertNoSecretsStored(t *testing.T, dir, secret string) {
+	t.Helper()
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, f := range files {
+		data, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), secret)
+	}
+}
+
+func TestIdempotency_EncryptsStoredSecrets(t *testing.T) {
+	dir := t.TempDir()
+	masterKey, err := wgquick.ParseMasterKey([]byte("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="))
+	require.NoError(t, err)
+	cipher, err := wgquick.NewKeyCipher(masterKey)
+	require.NoError(t, err)
+
+	calls := 0
+	first := postIdempotent(t, newSecretApp(t, dir, cipher, &calls), "key-1", "Bearer a", `{}`)
+	firstBody, _ := io.ReadAll(first.Body)
+	assertNoSecretsStored(t, dir, "private-secret")
+
+	// The generated key survives a restart
+	second := postIdempotent(t, newSecretApp(t, dir, cipher, &calls), "key-1", "Bearer a", `{}`)
+	secondBody, _ := io.ReadAll(second.Body)
+	assert.Equal(t, "true", second.Header.Get(IdempotentReplayedHeader))
+	assert.Equal(t, firstBody, secondBody)
+	assert.Contains(t, string(secondBody), "private-secret-1")
+	assert.Equal(t, 1, calls)
+
+	// Without the master key the response isn't replayed, nor created again
+	third := postIdempotent(t, newSecretApp(t, dir, nil, &calls), "key-1", "Bearer a", `{}`)
+	assert.Equal(t, http.StatusServiceUnavailable, third.StatusCode)
+	assert.Equal(t, 1, calls)
+}
+
+func TestIdempotency_KeepsSecretsInMemory(t *testing.T) {
+	dir := t.TempDir()
+
+	calls := 0
+	app := newSecretApp(t, dir, nil, &calls)
+	first := postIdempotent(t, app, "key-1", "Bearer a", `{}`)
+	firstBody, _ := io.ReadAll(first.Body)
+	assertNoSecretsStored(t, dir, "private-secret")
+
+	second := postIdempotent(t, app, "key-1", "Bearer a", `{}`)
+	secondBody, _ := io.ReadAll(second.Body)
+	assert.Equal(t, "true", second.Header.Get(IdempotentReplayedHeader))
+	assert.Equal(t, firstBody, secondBody)
+	assert.Equal(t, 1, calls)
+}
+
+func TestHasSecrets(t *testing.T) {
+	json := fiber.MIMEApplicationJSON
+
+	assert.True(t, hasSecrets(json, []byte(`{"private_key":"k"}`)))
+	assert.True(t, hasSecrets(json, []byte(`{"results":[{"peer":{"preshared_key":"k"}}]}`)))
+	assert.True(t, hasSecrets(json, []byte(`{"config_diff":"+PrivateKey = k\n"}`)))
+	assert.False(t, hasSecrets(json, []byte(`{"public_key":"k","private_key":""}`)))
+	assert.False(t, hasSecrets(json, []byte(`{"config_diff":"+PrivateKey = (redacted)\n"}`)))
+	assert.False(t, hasSecrets(fiber.MIMETextPlain, []byte(`private_key`)))
+}
+
+// missFirstStorage hides stored responses from the first lookup, like a
+// request that finished between a retry's first lookup and its key lock.
+type missFirstStorage struct {
+	fiber.Storage
+	missed bool
+}
+
+func (s *missFirstStorage) Get(key string) ([]byte, error) {
+	if !s.missed {
+		s.missed = true
+		return nil, nil
+	}
+	return s.Storage.Get(key)
+}
+
+func TestIdempotency_RereadsAfterLock(t *testing.T) {
+	inner, err := storage.NewDirStorage("")
+	require.NoError(t, err)
+	store := &missFirstStorage{Storage: inner, missed: true}
+
+	calls := 0
+	app := fiber.New()
+	app.Post("/peers/", Idempotency(IdempotencyConfig{Storage: store, Lifetime: time.Hour}), func(c *fiber.Ctx) error {
+		calls++
+		return c.SendStatus(fiber.StatusCreated)
+	})
+
+	postIdempotent(t, app, "key-1", "Bearer a", `{}`)
+	store.missed = false
+	resp := postIdempotent(t, app, "key-1", "Bearer a", `{}`)
+
+	assert.Equal(t, "true", resp.Header.Get(IdempotentReplayedHeader))
+	assert.Equal(t, 1, calls)
+}
+
+// failingStorage fails every write.
+type failingStorage struct {
+	fiber.Storage
+}
+
+func (failingStorage) Set(string, []byte, time.Duration) error {
+	return errors.New("disk full")
+}
+
+func TestIdempotency_StorageFailureKeepsResponse(t *testing.T) {
+	inner, err := storage.NewDirStorage("")
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Post("/peers/", Idempotency(IdempotencyConfig{Storage: failingStorage{inner}, Lifetime: time.Hour}), func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusCreated).SendString("created")
+	})
+
+	resp := postIdempotent(t, app, "key-1", "Bearer a", `{}`)
+	body, _ := io.ReadAll(resp.Body)
+
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "created", string(body))
+}
@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+// clientIPKey is the fiber.Ctx locals key holding the resolved client IP.
+const clientIPKey = "wgrest.client_ip"
+
+// ParseCIDRs parses a list of CIDRs. Bare IP addresses are treated as
+// single host networks.
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", value, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ClientIP returns the client IP resolved by RealIP, or the peer address
+// if RealIP is not in use.
+func ClientIP(c *fiber.Ctx) string {
+	if ip, ok := c.Locals(clientIPKey).(string); ok && ip != "" {
+		return ip
+	}
+	return c.IP()
+}
+
+// ClientKey identifies a caller for rate limits and lockouts. Callers on a
+// Unix socket all have the same address, so they are told apart by process
+// UID instead of client IP.
+func ClientKey(c *fiber.Ctx) string {
+	if !UnixSocket(c) {
+		return "ip:" + ClientIP(c)
+	}
+	cred, err := callerPeerCred(c)
+	if err != nil {
+		return "unix"
+	}
+	return "uid:" + strconv.FormatUint(uint64(cred.UID), 10)
+}
+
+// RealIP creates a middleware that resolves the client IP from
+// X-Forwarded-For when the request comes from a trusted proxy. The header is
+// walked from right to left and the first address that is not a trusted proxy
+// is used, so clients can't spoof their address by prepending entries.
+func RealIP(trustedProxies []*net.IPNet) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ip := c.IP()
+
+		if containsIP(trustedProxies, net.ParseIP(ip)) {
+			hops := strings.Split(c.Get(fiber.HeaderXForwardedFor), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := net.ParseIP(strings.TrimSpace(hops[i]))
+				if hop == nil {
+					break
+				}
+				ip = hop.String()
+				if !containsIP(trustedProxies, hop) {
+					break
+				}
+			}
+		}
+
+		c.Locals(clientIPKey, ip)
+		return c.Next()
+	}
+}
+
+// IPAllowList creates a middleware that rejects clients outside the allowed
+// networks. Callers on a Unix socket have no network address and are let
+// through.
+func IPAllowList(allowed []*net.IPNet) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if UnixSocket(c) {
+			return c.Next()
+		}
+
+		ip := ClientIP(c)
+		if !containsIP(allowed, net.ParseIP(ip)) {
+			return c.Status(fiber.StatusForbidden).JSON(entity.Error{
+				Code:    entity.ErrCodeForbidden,
+				Message: fmt.Sprintf("access from %s is not allowed", ip),
+			})
+		}
+		return c.Next()
+	}
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// app.Test connections come from 0.0.0.0
+const testPeerIP = "0.0.0.0"
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.10", "fd00::/64", " ", "::1"})
+	require.NoError(t, err)
+	require.Len(t, nets, 4)
+
+	assert.Equal(t, "10.0.0.0/8", nets[0].String())
+	assert.Equal(t, "192.168.1.10/32", nets[1].String())
+	assert.Equal(t, "fd00::/64", nets[2].String())
+	assert.Equal(t, "::1/128", nets[3].String())
+
+	_, err = ParseCIDRs([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+
+	_, err = ParseCIDRs([]string{"not-an-ip"})
+	assert.Error(t, err)
+}
+
+func TestRealIP(t *testing.T) {
+	trusted, err := ParseCIDRs([]string{testPeerIP, "10.0.0.0/8"})
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Use(RealIP(trusted))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(ClientIP(c))
+	})
+
+	testCases := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{"no header", "", testPeerIP},
+		{"single client", "203.0.113.7", "203.0.113.7"},
+		{"spoofed entry is ignored", "1.2.3.4, 203.0.113.7", "203.0.113.7"},
+		{"chained trusted proxies", "203.0.113.7, 10.0.0.2, 10.0.0.1", "203.0.113.7"},
+		{"only trusted proxies", "10.0.0.2", "10.0.0.2"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				req.Header.Set("X-Forwarded-For", tc.header)
+			}
+
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, tc.expected, string(body))
+		})
+	}
+}
+
+func TestRealIP_UntrustedPeer(t *testing.T) {
+	trusted, err := ParseCIDRs([]string{"10.0.0.1"})
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Use(RealIP(trusted))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(ClientIP(c))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, testPeerIP, string(body))
+}
+
+func TestIPAllowList(t *testing.T) {
+	trusted, err := ParseCIDRs([]string{testPeerIP})
+	require.NoError(t, err)
+	allowed, err := ParseCIDRs([]string{"192.168.0.0/16"})
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Use(RealIP(trusted))
+	app.Use(IPAllowList(allowed))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	testCases := []struct {
+		name     string
+		clientIP string
+		expected int
+	}{
+		{"allowed network", "192.168.1.20", http.StatusOK},
+		{"outside network", "203.0.113.7", http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Forwarded-For", tc.clientIP)
+
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// lockoutPruneSize is the number of tracked clients above which expired
+// entries are pruned on every failure.
+const lockoutPruneSize = 1024
+
+// AuthLockout tracks failed authentication attempts per client and
+// temporarily locks out clients exceeding the threshold.
+type AuthLockout struct {
+	threshold int
+	duration  time.Duration
+	now       func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*lockoutEntry
+}
+
+type lockoutEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// NewAuthLockout creates a lockout tracker that blocks a client for duration
+// after threshold consecutive failures. Failures older than duration are forgotten.
+func NewAuthLockout(threshold int, duration time.Duration) *AuthLockout {
+	return &AuthLockout{
+		threshold: threshold,
+		duration:  duration,
+		now:       time.Now,
+		clients:   make(map[string]*lockoutEntry),
+	}
+}
+
+// Locked reports whether the client is locked out and for how long.
+func (l *AuthLockout) Locked(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.clients[key]
+	if !ok {
+		return 0, false
+	}
+
+	remaining := entry.lockedUntil.Sub(l.now())
+	if remaining <= 0 {
+		return 0, false
+	}
+
+	return remaining, true
+}
+
+// Fail records a failed attempt and reports whether the client is now locked out.
+func (l *AuthLockout) Fail(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	if len(l.clients) > lockoutPruneSize {
+		l.prune(now)
+	}
+
+	entry, ok := l.clients[key]
+	if !ok || now.Sub(entry.lastFailure) > l.duration {
+		entry = &lockoutEntry{}
+		l.clients[key] = entry
+	}
+
+	entry.failures++
+	entry.lastFailure = now
+
+	if entry.failures >= l.threshold {
+		entry.lockedUntil = now.Add(l.duration)
+		entry.failures = 0
+		return true
+	}
+
+	return false
+}
+
+// Reset forgets failures recorded for the client.
+func (l *AuthLockout) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.clients, key)
+}
+
+func (l *AuthLockout) prune(now time.Time) {
+	for key, entry := range l.clients {
+		if now.After(entry.lockedUntil) && now.Sub(entry.lastFailure) > l.duration {
+			delete(l.clients, key)
+		}
+	}
+}
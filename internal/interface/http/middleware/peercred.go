@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"errors"
+	"net"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+// authenticatedKey is the fiber.Ctx locals key set once a caller is authorized.
+const authenticatedKey = "wgrest.authenticated"
+
+// peerCredKey is the fiber.Ctx locals key holding the peer credentials of a
+// Unix socket caller.
+const peerCredKey = "wgrest.peer_cred"
+
+// errPeerCredUnsupported is returned on platforms without SO_PEERCRED.
+var errPeerCredUnsupported = errors.New("peer credentials are not supported on this platform")
+
+// errNotUnixSocket is returned for peer credentials of callers not connected
+// over a Unix socket.
+var errNotUnixSocket = errors.New("caller is not connected over a Unix socket")
+
+// PeerCred contains the credentials of the process on the other end of a Unix socket.
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// PeerCredConfig contains configuration for the Unix socket peer credential middleware.
+type PeerCredConfig struct {
+	// UIDs are the user IDs allowed without a token
+	UIDs []uint32
+
+	// GIDs are the group IDs allowed without a token
+	GIDs []uint32
+
+	// Permissions are granted to matching processes
+	Permissions []string
+}
+
+// PeerCredAuth creates a middleware that authorizes callers connected over a
+// Unix socket whose process UID or GID is allowed. Other callers fall through
+// to the next middleware (usually BearerAuth).
+func PeerCredAuth(cfg PeerCredConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cred, err := callerPeerCred(c)
+		if err != nil {
+			return c.Next()
+		}
+
+		if containsID(cfg.UIDs, cred.UID) || containsID(cfg.GIDs, cred.GID) {
+			c.Locals(authenticatedKey, true)
+			SetPermissions(c, cfg.Permissions)
+		}
+
+		return c.Next()
+	}
+}
+
+// RequirePeerCred creates a middleware rejecting callers PeerCredAuth didn't
+// authorize. It takes the place of BearerAuth when no token is configured.
+func RequirePeerCred() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if Authenticated(c) {
+			return c.Next()
+		}
+		return c.Status(fiber.StatusForbidden).JSON(entity.Error{
+			Code:    entity.ErrCodePermissionDenied,
+			Message: "caller is not allowed by Unix socket peer credentials",
+		})
+	}
+}
+
+// UnixSocket reports whether the caller is connected over a Unix socket.
+func UnixSocket(c *fiber.Ctx) bool {
+	_, ok := c.Context().Conn().(*net.UnixConn)
+	return ok
+}
+
+// callerPeerCred returns the peer credentials of a caller connected over a
+// Unix socket, reading them once per request.
+func callerPeerCred(c *fiber.Ctx) (*PeerCred, error) {
+	if cred, ok := c.Locals(peerCredKey).(*PeerCred); ok {
+		return cred, nil
+	}
+
+	conn, ok := c.Context().Conn().(*net.UnixConn)
+	if !ok {
+		return nil, errNotUnixSocket
+	}
+	cred, err := peerCredentials(conn)
+	if err != nil {
+		return nil, err
+	}
+	c.Locals(peerCredKey, cred)
+	return cred, nil
+}
+
+// Authenticated reports whether a previous middleware already authorized the caller.
+func Authenticated(c *fiber.Ctx) bool {
+	authenticated, _ := c.Locals(authenticatedKey).(bool)
+	return authenticated
+}
+
+func containsID(ids []uint32, id uint32) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+//go:build linux
+
+package middleware
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveUnix(t *testing.T, cfg PeerCredConfig) *http.Client {
+	return serveUnixWith(t, cfg, BearerAuth("test-token"))
+}
+
+// serveUnixWith serves over a Unix socket with auth run after PeerCredAuth.
+func serveUnixWith(t *testing.T, cfg PeerCredConfig, auth fiber.Handler) *http.Client {
+	t.Helper()
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use(PeerCredAuth(cfg))
+	app.Use(auth)
+	app.Get("/", func(c *fiber.Ctx) error {
+		if HasPermission(c, PermissionSecretsRead) {
+			return c.SendString("secrets")
+		}
+		return c.SendString("OK")
+	})
+
+	return serveUnixApp(t, app)
+}
+
+// serveUnixApp serves app over a Unix socket and returns a client for it.
+func serveUnixApp(t *testing.T, app *fiber.App) *http.Client {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "wgrest.sock")
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+func TestPeerCredAuth_AllowedUID(t *testing.T) {
+	client := serveUnix(t, PeerCredConfig{
+		UIDs:        []uint32{uint32(os.Getuid())},
+		Permissions: []string{PermissionSecretsRead},
+	})
+
+	resp, err := client.Get("http://unix/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "secrets", string(body))
+}
+
+func TestPeerCredAuth_AllowedGID(t *testing.T) {
+	client := serveUnix(t, PeerCredConfig{GIDs: []uint32{uint32(os.Getgid())}})
+
+	resp, err := client.Get("http://unix/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "OK", string(body))
+}
+
+func TestPeerCredAuth_OtherUIDRequiresToken(t *testing.T) {
+	client := serveUnix(t, PeerCredConfig{UIDs: []uint32{uint32(os.Getuid()) + 1}})
+
+	resp, err := client.Get("http://unix/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://unix/", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRequirePeerCred(t *testing.T) {
+	// Without a token other UIDs are rejected
+	client := serveUnixWith(t, PeerCredConfig{UIDs: []uint32{uint32(os.Getuid()) + 1}}, RequirePeerCred())
+
+	resp, err := client.Get("http://unix/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// And allowed ones only get their permissions
+	client = serveUnixWith(t, PeerCredConfig{UIDs: []uint32{uint32(os.Getuid())}}, RequirePeerCred())
+
+	resp, err = client.Get("http://unix/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "OK", string(body))
+}
+
+func TestGrantPermissions_KeepsPeerCredPermissions(t *testing.T) {
+	client := serveUnixWith(t, PeerCredConfig{UIDs: []uint32{uint32(os.Getuid())}}, GrantPermissions(AllPermissions...))
+
+	resp, err := client.Get("http://unix/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "OK", string(body))
+}
+
+func TestClientKey_UnixSocket(t *testing.T) {
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use(IPAllowList([]*net.IPNet{{IP: net.IPv4(192, 0, 2, 0), Mask: net.CIDRMask(24, 32)}}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(ClientKey(c))
+	})
+	client := serveUnixApp(t, app)
+
+	// Socket callers get past the allow-list and are keyed by UID
+	resp, err := client.Get("http://unix/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "uid:"+strconv.Itoa(os.Getuid()), string(body))
+}
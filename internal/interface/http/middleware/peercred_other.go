@@ -0,0 +1,12 @@
+//go:build !linux
+
+package middleware
+
+import (
+	"net"
+)
+
+// peerCredentials is not implemented outside Linux.
+func peerCredentials(conn *net.UnixConn) (*PeerCred, error) {
+	return nil, errPeerCredUnsupported
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// permissionsKey is the fiber.Ctx locals key holding the caller's permissions.
+const permissionsKey = "wgrest.permissions"
+
+// Permissions that can be granted to API callers in addition to regular API access.
+const (
+	// PermissionSecretsRead allows reading private and preshared keys outside of create responses
+	PermissionSecretsRead = "secrets:read"
+)
+
+// AllPermissions lists every known permission.
+var AllPermissions = []string{PermissionSecretsRead}
+
+// SetPermissions stores the permissions granted to the current caller.
+func SetPermissions(c *fiber.Ctx, permissions []string) {
+	c.Locals(permissionsKey, permissions)
+}
+
+// HasPermission reports whether the current caller was granted the permission.
+func HasPermission(c *fiber.Ctx, permission string) bool {
+	permissions, _ := c.Locals(permissionsKey).([]string)
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// GrantPermissions creates a middleware that grants permissions to every caller
+// not already authorized by a previous middleware. It is used when
+// authorization is disabled.
+func GrantPermissions(permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !Authenticated(c) {
+			SetPermissions(c, permissions)
+		}
+		return c.Next()
+	}
+}
+
+// ValidatePermissions returns an error if any permission is unknown.
+func ValidatePermissions(permissions []string) error {
+	for _, p := range permissions {
+		known := false
+		for _, k := range AllPermissions {
+			if p == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown permission %q", p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+// IPRateLimit creates a middleware that limits requests per source IP, or per
+// process UID on a Unix socket, to max requests within the sliding window.
+func IPRateLimit(max int, window time.Duration) fiber.Handler {
+	return limiter.New(limiter.Config{
+		Max:        max,
+		Expiration: window,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return ClientKey(c)
+		},
+		LimitReached:      rateLimitReached,
+		LimiterMiddleware: limiter.SlidingWindow{},
+	})
+}
+
+// TokenRateLimit creates a middleware that limits requests per bearer token
+// to max requests within the sliding window. Requests without an
+// Authorization header are not counted.
+func TokenRateLimit(max int, window time.Duration) fiber.Handler {
+	return limiter.New(limiter.Config{
+		Next: func(c *fiber.Ctx) bool {
+			return c.Get(fiber.HeaderAuthorization) == ""
+		},
+		Max:        max,
+		Expiration: window,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			// Don't keep raw tokens in the limiter storage
+			sum := sha256.Sum256([]byte(c.Get(fiber.HeaderAuthorization)))
+			return "token:" + hex.EncodeToString(sum[:])
+		},
+		LimitReached:      rateLimitReached,
+		LimiterMiddleware: limiter.SlidingWindow{},
+	})
+}
+
+// rateLimitReached responds with 429; the limiter has already set Retry-After.
+func rateLimitReached(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusTooManyRequests).JSON(entity.Error{
+		Code:    entity.ErrCodeTooManyRequests,
+		Message: "rate limit exceeded",
+	})
+}
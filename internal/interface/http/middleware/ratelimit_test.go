@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPRateLimit(t *testing.T) {
+	app := fiber.New()
+	app.Use(IPRateLimit(2, time.Minute))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	for i := 0; i < 2; i++ {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+}
+
+func TestTokenRateLimit(t *testing.T) {
+	app := fiber.New()
+	app.Use(TokenRateLimit(1, time.Minute))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	send := func(auth string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, send("Bearer token-a"))
+	assert.Equal(t, http.StatusTooManyRequests, send("Bearer token-a"))
+
+	// Other tokens have their own budget
+	assert.Equal(t, http.StatusOK, send("Bearer token-b"))
+
+	// Requests without a token are not limited here
+	assert.Equal(t, http.StatusOK, send(""))
+	assert.Equal(t, http.StatusOK, send(""))
+}
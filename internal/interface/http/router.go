@@ -1,9 +1,11 @@
 package http
 
 import (
+	"net"
 	"net/http"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -19,11 +21,71 @@ import (
 type RouterConfig struct {
 	DeviceHandler *handler.DeviceHandler
 	PeerHandler   *handler.PeerHandler
+	StateHandler  *handler.StateHandler
+	BackupHandler *handler.BackupHandler
+	DumpHandler   *handler.DumpHandler
 	AuthToken     string
 	Version       string
 	OpenAPISpec   []byte
+
+	// WireGuardModuleVersion returns the kernel module version reported by
+	// /version ("unknown" if nil)
+	WireGuardModuleVersion func() string
+
+	// AuthTokenPermissions are extra permissions granted to AuthToken holders
+	AuthTokenPermissions []string
+
+	// PeerCred authorizes Unix socket clients by process UID/GID (disabled if nil)
+	PeerCred *middleware.PeerCredConfig
+
+	// IPRateLimit is the max requests per source IP within RateLimitWindow (0 disables)
+	IPRateLimit int
+
+	// TokenRateLimit is the max requests per bearer token within RateLimitWindow (0 disables)
+	TokenRateLimit int
+
+	// RateLimitWindow is the sliding window for rate limits
+	RateLimitWindow time.Duration
+
+	// AuthLockoutThreshold is the number of failed authorizations before
+	// a source IP is locked out (0 disables)
+	AuthLockoutThreshold int
+
+	// AuthLockoutDuration is how long a source IP stays locked out
+	AuthLockoutDuration time.Duration
+
+	// CORSAllowOrigins are the origins allowed to call the API ("*" if empty)
+	CORSAllowOrigins []string
+
+	// CORSAllowMethods are the methods allowed for cross-origin requests
+	CORSAllowMethods []string
+
+	// CORSAllowCredentials allows cookies and Authorization in cross-origin requests
+	CORSAllowCredentials bool
+
+	// AllowedNets restricts which client networks may reach the server (all if empty)
+	AllowedNets []*net.IPNet
+
+	// TrustedProxies are proxies whose X-Forwarded-For header is honoured
+	TrustedProxies []*net.IPNet
+
+	// IdempotencyStorage keeps create responses by Idempotency-Key (disabled if nil)
+	IdempotencyStorage fiber.Storage
+
+	// IdempotencyCipher encrypts stored create responses carrying keys; without
+	// it they're only kept in memory
+	IdempotencyCipher middleware.ResponseCipher
+
+	// IdempotencyLifetime is how long an Idempotency-Key is remembered
+	IdempotencyLifetime time.Duration
+
+	// RequestTimeout cancels API requests that take longer (0 disables)
+	RequestTimeout time.Duration
 }
 
+// DefaultCORSAllowMethods are the methods allowed for cross-origin requests by default.
+var DefaultCORSAllowMethods = []string{"GET", "HEAD", "PUT", "PATCH", "POST", "DELETE"}
+
 // SetupRouter configures all routes for the Fiber application.
 func SetupRouter(app *fiber.App, cfg RouterConfig) {
 	// Global middleware
@@ -32,12 +94,28 @@ func SetupRouter(app *fiber.App, cfg RouterConfig) {
 	}))
 	app.Use(recover.New())
 
+	// Resolve client IP behind trusted proxies
+	if len(cfg.TrustedProxies) > 0 {
+		app.Use(middleware.RealIP(cfg.TrustedProxies))
+	}
+
+	// Source network allow-list, applied before authentication; Unix socket
+	// callers are let through
+	if len(cfg.AllowedNets) > 0 {
+		app.Use(middleware.IPAllowList(cfg.AllowedNets))
+	}
+
 	// Version endpoint (no auth required)
 	app.Get("/version", func(c *fiber.Ctx) error {
 		wgVersion := getWireGuardVersion()
+		moduleVersion := "unknown"
+		if cfg.WireGuardModuleVersion != nil {
+			moduleVersion = cfg.WireGuardModuleVersion()
+		}
 		return c.JSON(fiber.Map{
-			"wgrest":    cfg.Version,
-			"wireguard": wgVersion,
+			"wgrest":           cfg.Version,
+			"wireguard":        wgVersion,
+			"wireguard_module": moduleVersion,
 		})
 	})
 
@@ -68,23 +146,89 @@ func SetupRouter(app *fiber.App, cfg RouterConfig) {
 	v1 := app.Group("/v1")
 
 	// CORS middleware for v1
+	allowOrigins := "*"
+	if len(cfg.CORSAllowOrigins) > 0 {
+		allowOrigins = strings.Join(cfg.CORSAllowOrigins, ",")
+	}
+	allowMethods := DefaultCORSAllowMethods
+	if len(cfg.CORSAllowMethods) > 0 {
+		allowMethods = cfg.CORSAllowMethods
+	}
 	v1.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowMethods: "GET,HEAD,PUT,PATCH,POST,DELETE",
-		AllowHeaders: "Content-Type,Accept,Accept-Language,Link,Authorization",
+		AllowOrigins:     allowOrigins,
+		AllowMethods:     strings.Join(allowMethods, ","),
+		AllowHeaders:     "Content-Type,Accept,Accept-Language,Link,Authorization,If-Match,If-None-Match,Idempotency-Key",
+		ExposeHeaders:    "ETag,Idempotent-Replayed",
+		AllowCredentials: cfg.CORSAllowCredentials,
 	}))
 
-	// Auth middleware if token configured
+	// Per source IP (or Unix socket UID) rate limit, applied before auth to
+	// slow down brute force
+	if cfg.IPRateLimit > 0 {
+		v1.Use(middleware.IPRateLimit(cfg.IPRateLimit, cfg.RateLimitWindow))
+	}
+
+	// Unix socket peer credentials authorize local processes without a token
+	if cfg.PeerCred != nil {
+		v1.Use(middleware.PeerCredAuth(*cfg.PeerCred))
+	}
+
+	// Auth middleware if token configured, otherwise only allowed Unix socket
+	// callers get in if peer credentials are configured, and every caller
+	// has all permissions if not
 	if cfg.AuthToken != "" {
-		v1.Use(middleware.BearerAuth(cfg.AuthToken))
+		var lockout *middleware.AuthLockout
+		if cfg.AuthLockoutThreshold > 0 {
+			lockout = middleware.NewAuthLockout(cfg.AuthLockoutThreshold, cfg.AuthLockoutDuration)
+		}
+		v1.Use(middleware.BearerAuthWithConfig(middleware.BearerAuthConfig{
+			Token:       cfg.AuthToken,
+			Permissions: cfg.AuthTokenPermissions,
+			Lockout:     lockout,
+		}))
+	} else if cfg.PeerCred != nil {
+		v1.Use(middleware.RequirePeerCred())
+	} else {
+		v1.Use(middleware.GrantPermissions(middleware.AllPermissions...))
+	}
+
+	// Per token rate limit
+	if cfg.TokenRateLimit > 0 {
+		v1.Use(middleware.TokenRateLimit(cfg.TokenRateLimit, cfg.RateLimitWindow))
+	}
+
+	// Cancel long requests, such as device ups waiting on locks or wg-quick
+	if cfg.RequestTimeout > 0 {
+		v1.Use(middleware.Timeout(cfg.RequestTimeout))
+	}
+
+	// Create requests retried with the same Idempotency-Key replay the original response
+	idempotent := func(c *fiber.Ctx) error { return c.Next() }
+	if cfg.IdempotencyStorage != nil {
+		idempotent = middleware.Idempotency(middleware.IdempotencyConfig{
+			Storage:  cfg.IdempotencyStorage,
+			Cipher:   cfg.IdempotencyCipher,
+			Lifetime: cfg.IdempotencyLifetime,
+		})
 	}
 
 	// Device routes
 	v1.Get("/devices/", cfg.DeviceHandler.ListDevices)
-	v1.Post("/devices/", cfg.DeviceHandler.CreateDevice)
+	v1.Post("/devices/", idempotent, cfg.DeviceHandler.CreateDevice)
 	v1.Get("/devices/:name/", cfg.DeviceHandler.GetDevice)
 	v1.Patch("/devices/:name/", cfg.DeviceHandler.UpdateDevice)
 	v1.Delete("/devices/:name/", cfg.DeviceHandler.DeleteDevice)
+	v1.Get("/devices/:name/spec/", cfg.DeviceHandler.GetDeviceSpec)
+	v1.Put("/devices/:name/spec/", cfg.DeviceHandler.ApplyDeviceSpec)
+
+	// Config history
+	v1.Get("/devices/:name/revisions/", cfg.DeviceHandler.ListConfigRevisions)
+	v1.Get("/devices/:name/revisions/diff/", cfg.DeviceHandler.DiffConfigRevisions)
+	v1.Post("/devices/:name/revisions/:id/rollback/", cfg.DeviceHandler.RollbackConfig)
+
+	// Drift between config files and running interfaces
+	v1.Get("/devices/:name/drift/", cfg.DeviceHandler.GetDrift)
+	v1.Post("/devices/:name/sync/", cfg.DeviceHandler.SyncDevice)
 
 	// wg-quick operations
 	v1.Post("/devices/:name/up/", cfg.DeviceHandler.Up)
@@ -92,10 +236,22 @@ func SetupRouter(app *fiber.App, cfg RouterConfig) {
 
 	// Peer routes
 	v1.Get("/devices/:name/peers/", cfg.PeerHandler.ListPeers)
-	v1.Post("/devices/:name/peers/", cfg.PeerHandler.CreatePeer)
+	v1.Post("/devices/:name/peers/", idempotent, cfg.PeerHandler.CreatePeer)
+	v1.Post("/devices/:name/peers/batch/", idempotent, cfg.PeerHandler.BatchPeers)
 	v1.Get("/devices/:name/peers/:urlSafePubKey/", cfg.PeerHandler.GetPeer)
 	v1.Patch("/devices/:name/peers/:urlSafePubKey/", cfg.PeerHandler.UpdatePeer)
 	v1.Delete("/devices/:name/peers/:urlSafePubKey/", cfg.PeerHandler.DeletePeer)
+
+	// Server state export and import
+	v1.Get("/state/", cfg.StateHandler.ExportState)
+	v1.Put("/state/", cfg.StateHandler.ImportState)
+
+	// Config backups
+	v1.Get("/backup/", cfg.BackupHandler.Backup)
+	v1.Post("/restore/", cfg.BackupHandler.Restore)
+
+	// Config dump status
+	v1.Get("/dump/", cfg.DumpHandler.GetDumpStatus)
 }
 
 // getWireGuardVersion executes wg --version and returns the version string.
@@ -0,0 +1,175 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/backup"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+	"github.com/suquant/wgrest/internal/infrastructure/wireguard"
+)
+
+// BackupUseCase writes and restores archives of the config directories.
+type BackupUseCase struct {
+	wgClient   *wireguard.Client
+	wgquickSvc *wgquick.Service
+	devices    *DeviceUseCase
+	version    string
+}
+
+// NewBackupUseCase creates a new backup use case. version is recorded in archives.
+func NewBackupUseCase(
+	wgClient *wireguard.Client,
+	wgquickSvc *wgquick.Service,
+	version string,
+) *BackupUseCase {
+	return &BackupUseCase{
+		wgClient:   wgClient,
+		wgquickSvc: wgquickSvc,
+		devices:    NewDeviceUseCase(wgClient, wgquickSvc, nil),
+		version:    version,
+	}
+}
+
+// Backup writes a tar.gz archive of every config file in every config
+// directory to w. The archive is encrypted with passphrase or to the age
+// recipients if either is given. It returns the file name of the archive.
+func (uc *BackupUseCase) Backup(w io.Writer, passphrase string, recipients []string) (string, error) {
+	ageRecipients, err := backup.Recipients(passphrase, recipients)
+	if err != nil {
+		return "", err
+	}
+
+	var files []backup.File
+	for _, f := range uc.wgquickSvc.ConfigFiles() {
+		data, err := uc.wgquickSvc.ReadConfigIn(f.Dir, f.Name)
+		if err != nil {
+			return "", err
+		}
+		if data == "" {
+			continue // Removed since it was listed
+		}
+		files = append(files, backup.File{
+			BackupFile: entity.BackupFile{Device: f.Name, Dir: f.Dir},
+			Data:       []byte(data),
+		})
+	}
+
+	now := time.Now()
+	if _, err := backup.Write(w, files, uc.version, now, ageRecipients); err != nil {
+		return "", err
+	}
+	return backup.FileName(now, len(ageRecipients) > 0), nil
+}
+
+// Restore validates an archive written by Backup and writes its config
+// files back. Encrypted archives are decrypted with passphrase or the age
+// identities. Running devices whose config file changes are brought to the
+// restored config. Config files not in the archive are left alone. With
+// dryRun the changes are only computed.
+func (uc *BackupUseCase) Restore(ctx context.Context, r io.Reader, passphrase string, identities []string, dryRun bool) (*entity.RestoreResult, error) {
+	ageIdentities, err := backup.Identities(passphrase, identities)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := backup.Read(r, ageIdentities)
+	if err != nil {
+		return nil, err
+	}
+
+	specs := make([]entity.DeviceSpec, len(archive.Files))
+	for i, f := range archive.Files {
+		cfg, err := uc.wgquickSvc.DecodeConfig(f.Data)
+		if domain.Code(err) == entity.ErrCodeEncryptedKey {
+			return nil, err
+		}
+		if err != nil {
+			return nil, domain.Validation(entity.ErrCodeInvalidBackup, "failed to parse %s: %w", f.Path, err)
+		}
+		specs[i] = configSpec(f.Device, cfg)
+	}
+	if err := domain.ValidateBackup(archive.Manifest, specs); err != nil {
+		return nil, err
+	}
+
+	result := &entity.RestoreResult{
+		CreatedAt: archive.Manifest.CreatedAt,
+		Devices:   make([]entity.RestoreDevice, 0, len(archive.Files)),
+		DryRun:    dryRun,
+	}
+	for _, f := range archive.Files {
+		restored, err := uc.restoreFile(ctx, f, dryRun)
+		if err != nil {
+			return nil, fmt.Errorf("device %s: %w", f.Device, err)
+		}
+		result.Devices = append(result.Devices, *restored)
+	}
+
+	return result, nil
+}
+
+func (uc *BackupUseCase) restoreFile(ctx context.Context, f backup.File, dryRun bool) (*entity.RestoreDevice, error) {
+	unlock, err := deviceLocks.lock(ctx, f.Device)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Restore into the original directory if it's still configured
+	dir := f.Dir
+	if !slices.Contains(uc.wgquickSvc.ConfigDirs(), dir) {
+		dir = uc.wgquickSvc.GetConfigDir(f.Device)
+	}
+
+	current, err := uc.wgquickSvc.ReadConfigIn(dir, f.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	restored := &entity.RestoreDevice{Name: f.Device, Dir: dir, Action: entity.RestoreUnchanged}
+	switch {
+	case current == "":
+		restored.Action = entity.RestoreCreate
+	case current != string(f.Data):
+		restored.Action = entity.RestoreUpdate
+	default:
+		return restored, nil
+	}
+	restored.Diff = wgquick.Diff(f.Device, current, string(f.Data))
+
+	restored.Running, restored.Changes, err = uc.devices.restoreConfig(ctx, dir, f.Device, f.Data, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	return restored, nil
+}
+
+// configSpec returns the spec of a device described by a parsed config file.
+func configSpec(name string, cfg *wgquick.Config) entity.DeviceSpec {
+	return deviceSpec(configDevice(name, cfg), peersFromConfig(cfg))
+}
+
+// configDevice returns the device described by a parsed config file.
+func configDevice(name string, cfg *wgquick.Config) *entity.Device {
+	return &entity.Device{
+		Name:         name,
+		ListenPort:   int32(cfg.ListenPort),
+		PrivateKey:   cfg.PrivateKey,
+		FirewallMark: int32(cfg.FirewallMark),
+		Addresses:    cfg.Addresses,
+		DNS:          cfg.DNS,
+		MTU:          int32(cfg.MTU),
+		Table:        cfg.Table,
+		PreUp:        cfg.PreUp,
+		PostUp:       cfg.PostUp,
+		PreDown:      cfg.PreDown,
+		PostDown:     cfg.PostDown,
+	}
+}
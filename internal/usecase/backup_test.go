@@ -0,0 +1,87 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+)
+
+func TestBackupRestore_Unchanged(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	config := "[Interface]\nPrivateKey = " + key.String() + "\nListenPort = 51820\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "wg0.conf"), []byte(config), 0600))
+
+	svc, err := wgquick.NewService([]string{dir})
+	require.NoError(t, err)
+	uc := NewBackupUseCase(nil, svc, "1.2.3")
+
+	var buf bytes.Buffer
+	filename, err := uc.Backup(&buf, "", nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(filename, ".tar.gz"))
+
+	result, err := uc.Restore(context.Background(), &buf, "", nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, []entity.RestoreDevice{
+		{Name: "wg0", Dir: dir, Action: entity.RestoreUnchanged},
+	}, result.Devices)
+}
+
+func TestRestore_InvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "wg0.conf"), []byte("[Interface]\nMTU = 100\n"), 0600))
+
+	svc, err := wgquick.NewService([]string{dir})
+	require.NoError(t, err)
+	uc := NewBackupUseCase(nil, svc, "")
+
+	var buf bytes.Buffer
+	_, err = uc.Backup(&buf, "secret", nil)
+	require.NoError(t, err)
+
+	_, err = uc.Restore(context.Background(), bytes.NewReader(buf.Bytes()), "", nil, true)
+	assert.Equal(t, entity.ErrCodeInvalidBackup, domain.Code(err))
+
+	_, err = uc.Restore(context.Background(), bytes.NewReader(buf.Bytes()), "secret", nil, true)
+	require.Error(t, err)
+	fields := domain.Fields(err)
+	require.Len(t, fields, 1)
+	assert.Equal(t, "files[0].mtu", fields[0].Field)
+}
+
+func TestConfigSpec(t *testing.T) {
+	cfg := &wgquick.Config{
+		ListenPort:   51820,
+		FirewallMark: 42,
+		Addresses:    []string{"10.0.0.1/24"},
+		MTU:          1420,
+		Peers: []wgquick.PeerConfig{
+			{PublicKey: "b", AllowedIPs: []string{"10.0.0.3/32"}},
+			{PublicKey: "a", AllowedIPs: []string{"10.0.0.2/32"}, PersistentKeepaliveInterval: 25},
+		},
+	}
+
+	spec := configSpec("wg0", cfg)
+	assert.Equal(t, "wg0", spec.Name)
+	assert.Equal(t, int32(51820), spec.ListenPort)
+	assert.Equal(t, int32(42), spec.FirewallMark)
+	assert.Equal(t, int32(1420), spec.MTU)
+	assert.Equal(t, []entity.PeerSpec{
+		{PublicKey: "a", AllowedIPs: []string{"10.0.0.2/32"}, PersistentKeepaliveInterval: "25s"},
+		{PublicKey: "b", AllowedIPs: []string{"10.0.0.3/32"}},
+	}, spec.Peers)
+}
@@ -0,0 +1,77 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wireguard"
+)
+
+// BatchPeers applies create, update and delete operations to a device in a
+// single configuration change and saves the config once. In atomic mode
+// nothing is applied if any operation fails; in per_item mode the valid
+// operations are applied. Failures are reported in the item results.
+func (uc *PeerUseCase) BatchPeers(ctx context.Context, deviceName string, req entity.PeerBatchRequest) (*entity.PeerBatchResponse, error) {
+	if err := domain.ValidatePeerBatchRequest(req); err != nil {
+		return nil, err
+	}
+
+	mode := req.Mode
+	if mode == "" {
+		mode = entity.PeerBatchAtomic
+	}
+
+	items := make([]wireguard.PeerBatchItem, len(req.Operations))
+	for i, op := range req.Operations {
+		items[i] = wireguard.PeerBatchItem{
+			Op:  op,
+			Err: domain.ValidatePeerBatchOperation(op),
+		}
+	}
+
+	unlock, err := deviceLocks.lock(ctx, deviceName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := uc.wgClient.ApplyPeerBatch(ctx, deviceName, items, mode == entity.PeerBatchAtomic)
+	if err != nil {
+		return nil, err
+	}
+	if applied {
+		uc.saveDeviceConfig(ctx, deviceName)
+	}
+
+	return peerBatchResponse(mode, items, applied), nil
+}
+
+// peerBatchResponse reports the outcome of each batch item.
+func peerBatchResponse(mode string, items []wireguard.PeerBatchItem, applied bool) *entity.PeerBatchResponse {
+	resp := &entity.PeerBatchResponse{
+		Mode:    mode,
+		Results: make([]entity.PeerBatchResult, len(items)),
+	}
+	for i, item := range items {
+		result := entity.PeerBatchResult{
+			Index: i,
+			Op:    item.Op.Op,
+			Peer:  item.Peer,
+			Err:   item.Err,
+		}
+		switch {
+		case item.Err != nil:
+			result.Status = entity.PeerBatchFailed
+			resp.Failed++
+		case applied:
+			result.Status = entity.PeerBatchApplied
+			resp.Applied++
+		default:
+			result.Status = entity.PeerBatchSkipped
+			result.Peer = nil
+		}
+		resp.Results[i] = result
+	}
+	return resp
+}
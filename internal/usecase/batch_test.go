@@ -0,0 +1,41 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wireguard"
+)
+
+func TestPeerBatchResponse(t *testing.T) {
+	notFound := domain.NotFound(entity.ErrCodePeerNotFound, "peer not found")
+	items := []wireguard.PeerBatchItem{
+		{Op: entity.PeerBatchOperation{Op: entity.PeerBatchCreate}, Peer: &entity.Peer{PublicKey: "a"}},
+		{Op: entity.PeerBatchOperation{Op: entity.PeerBatchDelete}, Err: notFound},
+	}
+
+	resp := peerBatchResponse(entity.PeerBatchPerItem, items, true)
+	assert.Equal(t, 1, resp.Applied)
+	assert.Equal(t, 1, resp.Failed)
+	assert.Equal(t, entity.PeerBatchApplied, resp.Results[0].Status)
+	assert.Equal(t, "a", resp.Results[0].Peer.PublicKey)
+	assert.Equal(t, entity.PeerBatchFailed, resp.Results[1].Status)
+	assert.Equal(t, 1, resp.Results[1].Index)
+	assert.Equal(t, notFound, resp.Results[1].Err)
+
+	resp = peerBatchResponse(entity.PeerBatchAtomic, items, false)
+	assert.Equal(t, 0, resp.Applied)
+	assert.Equal(t, entity.PeerBatchSkipped, resp.Results[0].Status)
+	assert.Nil(t, resp.Results[0].Peer)
+}
+
+func TestBatchPeers_InvalidRequest(t *testing.T) {
+	uc := NewPeerUseCase(nil, nil, nil)
+
+	_, err := uc.BatchPeers(context.Background(), "wg0", entity.PeerBatchRequest{})
+	assert.ErrorIs(t, err, domain.ErrValidation)
+}
@@ -1,7 +1,11 @@
 package usecase
 
 import (
+	"context"
+
+	"github.com/suquant/wgrest/internal/domain"
 	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/dump"
 	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
 	"github.com/suquant/wgrest/internal/infrastructure/wireguard"
 )
@@ -10,23 +14,27 @@ import (
 type DeviceUseCase struct {
 	wgClient      *wireguard.Client
 	wgquickSvc    *wgquick.Service
+	dumpSvc       *dump.Service
 }
 
-// NewDeviceUseCase creates a new device use case.
+// NewDeviceUseCase creates a new device use case. Syncs clear the drifted
+// flag of dumpSvc when it is set.
 func NewDeviceUseCase(
 	wgClient *wireguard.Client,
 	wgquickSvc *wgquick.Service,
+	dumpSvc *dump.Service,
 ) *DeviceUseCase {
 	return &DeviceUseCase{
 		wgClient:   wgClient,
 		wgquickSvc: wgquickSvc,
+		dumpSvc:    dumpSvc,
 	}
 }
 
 // ListDevices returns all devices (running + config-only) with pagination.
-func (uc *DeviceUseCase) ListDevices(page, perPage int) ([]entity.Device, int, error) {
+func (uc *DeviceUseCase) ListDevices(ctx context.Context, page, perPage int) ([]entity.Device, int, error) {
 	// Get running devices
-	runningDevices, err := uc.wgClient.List()
+	runningDevices, err := uc.wgClient.List(ctx)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -40,7 +48,7 @@ func (uc *DeviceUseCase) ListDevices(page, perPage int) ([]entity.Device, int, e
 	// Enrich running devices with wg-quick config
 	for i := range runningDevices {
 		runningDevices[i].Running = true
-		uc.enrichDeviceWithConfig(&runningDevices[i])
+		enrichDeviceWithConfig(uc.wgquickSvc, &runningDevices[i])
 	}
 
 	// Get devices from config files that aren't running
@@ -52,7 +60,7 @@ func (uc *DeviceUseCase) ListDevices(page, perPage int) ([]entity.Device, int, e
 				Name:    name,
 				Running: false,
 			}
-			uc.enrichDeviceWithConfig(&device)
+			enrichDeviceWithConfig(uc.wgquickSvc, &device)
 			configOnlyDevices = append(configOnlyDevices, device)
 		}
 	}
@@ -83,12 +91,12 @@ func (uc *DeviceUseCase) ListDevices(page, perPage int) ([]entity.Device, int, e
 }
 
 // GetDevice returns a device by name (running or config-only).
-func (uc *DeviceUseCase) GetDevice(name string) (*entity.Device, error) {
+func (uc *DeviceUseCase) GetDevice(ctx context.Context, name string) (*entity.Device, error) {
 	// Try to get running device first
-	device, err := uc.wgClient.Get(name)
+	device, err := uc.wgClient.Get(ctx, name)
 	if err == nil {
 		device.Running = true
-		uc.enrichDeviceWithConfig(device)
+		enrichDeviceWithConfig(uc.wgquickSvc, device)
 		return device, nil
 	}
 
@@ -119,8 +127,18 @@ func (uc *DeviceUseCase) GetDevice(name string) (*entity.Device, error) {
 }
 
 // CreateDevice creates a new device and writes wg-quick config.
-func (uc *DeviceUseCase) CreateDevice(req entity.DeviceCreateOrUpdateRequest) (*entity.Device, error) {
-	device, err := uc.wgClient.Create(req)
+func (uc *DeviceUseCase) CreateDevice(ctx context.Context, req entity.DeviceCreateOrUpdateRequest) (*entity.Device, error) {
+	if err := domain.ValidateDeviceRequest(req, true); err != nil {
+		return nil, err
+	}
+
+	unlock, err := deviceLocks.lock(ctx, *req.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	device, err := uc.wgClient.Create(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -140,80 +158,133 @@ func (uc *DeviceUseCase) CreateDevice(req entity.DeviceCreateOrUpdateRequest) (*
 	device.PostDown = req.PostDown
 
 	// Save wg-quick config
-	peers, _ := uc.wgClient.ListPeers(device.Name)
-	if err := uc.wgquickSvc.SaveConfig(device, peers); err != nil {
-		// Log but don't fail - device was created
+	peers, err := uc.wgClient.ListPeers(ctx, device.Name)
+	if err != nil {
+		return nil, err
+	}
+	err = uc.wgquickSvc.EditConfig(device.Name, func(*wgquick.Config) (string, error) {
+		return uc.wgquickSvc.RenderConfig(device, peers), nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return device, nil
 }
 
-// UpdateDevice updates a device and writes wg-quick config.
-func (uc *DeviceUseCase) UpdateDevice(name string, req entity.DeviceCreateOrUpdateRequest) (*entity.Device, error) {
-	device, err := uc.wgClient.Update(name, req)
-	if err != nil {
+// UpdateDevice updates a device and writes wg-quick config. A non-empty
+// ifMatch must match the current ETag of the device.
+func (uc *DeviceUseCase) UpdateDevice(ctx context.Context, name string, req entity.DeviceCreateOrUpdateRequest, ifMatch string) (*entity.Device, error) {
+	if err := domain.ValidateDeviceRequest(req, false); err != nil {
 		return nil, err
 	}
 
-	// Apply wg-quick options
-	if len(req.Addresses) > 0 {
-		device.Addresses = req.Addresses
-	}
-	if len(req.DNS) > 0 {
-		device.DNS = req.DNS
-	}
-	if req.MTU != nil {
-		device.MTU = *req.MTU
-	}
-	if req.Table != nil {
-		device.Table = *req.Table
-	}
-	if len(req.PreUp) > 0 {
-		device.PreUp = req.PreUp
-	}
-	if len(req.PostUp) > 0 {
-		device.PostUp = req.PostUp
+	unlock, err := deviceLocks.lock(ctx, name)
+	if err != nil {
+		return nil, err
 	}
-	if len(req.PreDown) > 0 {
-		device.PreDown = req.PreDown
+	defer unlock()
+
+	if err := checkIfMatch(ifMatch, uc.deviceETag(ctx, name)); err != nil {
+		return nil, err
 	}
-	if len(req.PostDown) > 0 {
-		device.PostDown = req.PostDown
+
+	// The config is read, updated and written under one config lock, so
+	// changes made by other processes in the meantime aren't overwritten
+	err = uc.wgquickSvc.EditConfig(name, func(cfg *wgquick.Config) (string, error) {
+		device, err := uc.wgClient.Update(ctx, name, req)
+		if err != nil {
+			return "", err
+		}
+
+		// Start from the existing config, then apply the update on top
+		if cfg != nil {
+			applyConfigOptions(device, cfg)
+		}
+		applyDeviceOptions(device, req)
+
+		peers, err := uc.wgClient.ListPeers(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		return uc.wgquickSvc.RenderConfig(device, peers), nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Enrich with existing config values
-	uc.enrichDeviceWithConfig(device)
+	// Read back so the result carries the same ETag as a later GET
+	return uc.GetDevice(ctx, name)
+}
 
-	// Save wg-quick config
-	peers, _ := uc.wgClient.ListPeers(device.Name)
-	if err := uc.wgquickSvc.SaveConfig(device, peers); err != nil {
-		// Log but don't fail
+// DeleteDevice deletes a device. A non-empty ifMatch must match the current
+// ETag of the device.
+func (uc *DeviceUseCase) DeleteDevice(ctx context.Context, name string, ifMatch string) error {
+	unlock, err := deviceLocks.lock(ctx, name)
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	return device, nil
+	if err := checkIfMatch(ifMatch, uc.deviceETag(ctx, name)); err != nil {
+		return err
+	}
+
+	return uc.wgClient.Delete(ctx, name)
 }
 
-// DeleteDevice deletes a device.
-func (uc *DeviceUseCase) DeleteDevice(name string) error {
-	return uc.wgClient.Delete(name)
+// deviceETag returns a function computing the current entity tag of a device.
+func (uc *DeviceUseCase) deviceETag(ctx context.Context, name string) func() (string, error) {
+	return func() (string, error) {
+		device, err := uc.GetDevice(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		return DeviceETag(device), nil
+	}
 }
 
-// Up brings up a WireGuard interface using wg-quick.
-func (uc *DeviceUseCase) Up(name string) error {
-	return uc.wgquickSvc.Up(name)
+// Up brings up a WireGuard interface using wg-quick. wg-quick is killed if
+// ctx is done first.
+func (uc *DeviceUseCase) Up(ctx context.Context, name string) error {
+	unlock, err := deviceLocks.lock(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Cached peers of the device are outdated
+	defer uc.wgClient.Invalidate(name)
+
+	return uc.wgquickSvc.Up(ctx, name)
 }
 
-// Down brings down a WireGuard interface using wg-quick.
-func (uc *DeviceUseCase) Down(name string) error {
-	return uc.wgquickSvc.Down(name)
+// Down brings down a WireGuard interface using wg-quick. wg-quick is killed
+// if ctx is done first.
+func (uc *DeviceUseCase) Down(ctx context.Context, name string) error {
+	unlock, err := deviceLocks.lock(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Cached peers of the device are outdated
+	defer uc.wgClient.Invalidate(name)
+
+	return uc.wgquickSvc.Down(ctx, name)
 }
 
-func (uc *DeviceUseCase) enrichDeviceWithConfig(device *entity.Device) {
-	cfg, err := uc.wgquickSvc.LoadConfig(device.Name)
+// enrichDeviceWithConfig fills the wg-quick options of a device from its config file.
+func enrichDeviceWithConfig(wgquickSvc *wgquick.Service, device *entity.Device) {
+	cfg, err := wgquickSvc.LoadConfig(device.Name)
 	if err != nil {
 		return
 	}
+	applyConfigOptions(device, cfg)
+}
 
+// applyConfigOptions fills the wg-quick options of a device from its config.
+func applyConfigOptions(device *entity.Device, cfg *wgquick.Config) {
 	device.Addresses = cfg.Addresses
 	device.DNS = cfg.DNS
 	device.MTU = int32(cfg.MTU)
@@ -223,3 +294,36 @@ func (uc *DeviceUseCase) enrichDeviceWithConfig(device *entity.Device) {
 	device.PreDown = cfg.PreDown
 	device.PostDown = cfg.PostDown
 }
+
+// applyDeviceOptions applies the wg-quick options of an update request.
+// Omitted fields are unchanged; fields set to null in a merge patch are cleared.
+func applyDeviceOptions(device *entity.Device, req entity.DeviceCreateOrUpdateRequest) {
+	if len(req.Addresses) > 0 || req.IsNull("addresses") {
+		device.Addresses = req.Addresses
+	}
+	if len(req.DNS) > 0 || req.IsNull("dns") {
+		device.DNS = req.DNS
+	}
+	if req.MTU != nil {
+		device.MTU = *req.MTU
+	} else if req.IsNull("mtu") {
+		device.MTU = 0
+	}
+	if req.Table != nil {
+		device.Table = *req.Table
+	} else if req.IsNull("table") {
+		device.Table = ""
+	}
+	if len(req.PreUp) > 0 || req.IsNull("pre_up") {
+		device.PreUp = req.PreUp
+	}
+	if len(req.PostUp) > 0 || req.IsNull("post_up") {
+		device.PostUp = req.PostUp
+	}
+	if len(req.PreDown) > 0 || req.IsNull("pre_down") {
+		device.PreDown = req.PreDown
+	}
+	if len(req.PostDown) > 0 || req.IsNull("post_down") {
+		device.PostDown = req.PostDown
+	}
+}
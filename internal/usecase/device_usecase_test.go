@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
 )
 
 // Tests for pagination logic
@@ -162,3 +164,39 @@ func TestDeduplicateDevices_NoneRunning(t *testing.T) {
 
 	assert.Len(t, configOnlyNames, 3)
 }
+
+func TestApplyDeviceOptions(t *testing.T) {
+	mtu := int32(1380)
+	device := &entity.Device{
+		Name:      "wg0",
+		Addresses: []string{"10.0.0.1/24"},
+		DNS:       []string{"1.1.1.1"},
+		MTU:       1420,
+		Table:     "off",
+		PostUp:    []string{"echo up"},
+		PostDown:  []string{"echo down"},
+	}
+
+	applyDeviceOptions(device, entity.DeviceCreateOrUpdateRequest{
+		MTU:        &mtu,
+		PreUp:      []string{"echo pre"},
+		NullFields: []string{"dns", "table", "post_down"},
+	})
+
+	assert.Equal(t, []string{"10.0.0.1/24"}, device.Addresses)
+	assert.Empty(t, device.DNS)
+	assert.Equal(t, int32(1380), device.MTU)
+	assert.Empty(t, device.Table)
+	assert.Equal(t, []string{"echo pre"}, device.PreUp)
+	assert.Equal(t, []string{"echo up"}, device.PostUp)
+	assert.Empty(t, device.PostDown)
+}
+
+func TestApplyDeviceOptions_EmptyListsAreUnchanged(t *testing.T) {
+	device := &entity.Device{DNS: []string{"1.1.1.1"}, MTU: 1420}
+
+	applyDeviceOptions(device, entity.DeviceCreateOrUpdateRequest{DNS: []string{}})
+
+	assert.Equal(t, []string{"1.1.1.1"}, device.DNS)
+	assert.Equal(t, int32(1420), device.MTU)
+}
@@ -0,0 +1,165 @@
+package usecase
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+)
+
+// GetDrift compares the config file of a running device with the interface.
+func (uc *DeviceUseCase) GetDrift(ctx context.Context, name string) (*entity.DeviceDrift, error) {
+	device, peers, err := uc.wgClient.GetWithPeers(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := uc.wgquickSvc.LoadConfig(name)
+	if err != nil {
+		return nil, err
+	}
+
+	drift := deviceDrift(name, cfg, device, peers)
+	return &drift, nil
+}
+
+// SyncDevice brings the running interface to its config file (to kernel) or
+// writes the running interface to the config file (to file). With dryRun the
+// drift and changes are only computed.
+func (uc *DeviceUseCase) SyncDevice(ctx context.Context, name, to string, dryRun bool) (*entity.DeviceSyncResult, error) {
+	if to != entity.SyncToKernel && to != entity.SyncToFile {
+		return nil, domain.Validation(entity.ErrCodeInvalidRequest, "to must be %s or %s", entity.SyncToKernel, entity.SyncToFile)
+	}
+
+	unlock, err := deviceLocks.lock(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	device, peers, err := uc.wgClient.GetWithPeers(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := uc.wgquickSvc.LoadConfig(name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &entity.DeviceSyncResult{
+		To:     to,
+		Drift:  deviceDrift(name, cfg, device, peers),
+		DryRun: dryRun,
+	}
+
+	if to == entity.SyncToKernel {
+		data, err := uc.wgquickSvc.ReadConfig(name)
+		if err != nil {
+			return nil, err
+		}
+		_, result.Changes, err = uc.restoreConfig(ctx, uc.wgquickSvc.GetConfigDir(name), name, []byte(data), dryRun)
+		if err != nil {
+			return nil, err
+		}
+	} else if !dryRun && !result.Drift.InSync {
+		if err := uc.wgquickSvc.SaveRunningConfig(device, peers); err != nil {
+			return nil, err
+		}
+	}
+
+	if !dryRun && uc.dumpSvc != nil {
+		uc.dumpSvc.SetDrifted(name, false)
+	}
+
+	return result, nil
+}
+
+// deviceDrift compares a parsed config file with a running device and its
+// peers. Settings the file leaves to the kernel (an omitted listen port, a
+// peer without an endpoint or with a hostname endpoint) aren't drift.
+func deviceDrift(name string, cfg *wgquick.Config, device *entity.Device, peers []entity.Peer) entity.DeviceDrift {
+	drift := entity.DeviceDrift{Device: name}
+
+	if normalizeKey(cfg.PrivateKey) != normalizeKey(device.PrivateKey) {
+		drift.Fields = append(drift.Fields, entity.DriftField{Field: "private_key", File: cfg.PrivateKey, Running: device.PrivateKey})
+	}
+	if cfg.ListenPort != 0 && cfg.ListenPort != int(device.ListenPort) {
+		drift.Fields = append(drift.Fields, entity.DriftField{
+			Field:   "listen_port",
+			File:    strconv.Itoa(cfg.ListenPort),
+			Running: strconv.Itoa(int(device.ListenPort)),
+		})
+	}
+	if cfg.FirewallMark != int(device.FirewallMark) {
+		drift.Fields = append(drift.Fields, entity.DriftField{
+			Field:   "firewall_mark",
+			File:    strconv.Itoa(cfg.FirewallMark),
+			Running: strconv.Itoa(int(device.FirewallMark)),
+		})
+	}
+
+	running := make(map[string]entity.Peer, len(peers))
+	for _, p := range peers {
+		running[normalizeKey(p.PublicKey)] = p
+	}
+
+	for _, want := range peersFromConfig(cfg) {
+		key := normalizeKey(want.PublicKey)
+		have, ok := running[key]
+		if !ok {
+			drift.Peers = append(drift.Peers, entity.PeerDrift{PublicKey: key, State: entity.PeerDriftFileOnly})
+			continue
+		}
+		delete(running, key)
+
+		if fields := peerDrift(want, have); len(fields) > 0 {
+			drift.Peers = append(drift.Peers, entity.PeerDrift{PublicKey: key, State: entity.PeerDriftChanged, Fields: fields})
+		}
+	}
+	for key := range running {
+		drift.Peers = append(drift.Peers, entity.PeerDrift{PublicKey: key, State: entity.PeerDriftRunningOnly})
+	}
+	sort.Slice(drift.Peers, func(i, j int) bool {
+		return drift.Peers[i].PublicKey < drift.Peers[j].PublicKey
+	})
+
+	drift.InSync = len(drift.Fields) == 0 && len(drift.Peers) == 0
+	return drift
+}
+
+// peerDrift returns the settings of a peer that differ between the config
+// file and the running interface.
+func peerDrift(file, running entity.Peer) []entity.DriftField {
+	var fields []entity.DriftField
+
+	if normalizeKey(file.PresharedKey) != normalizeKey(running.PresharedKey) {
+		fields = append(fields, entity.DriftField{Field: "preshared_key", File: file.PresharedKey, Running: running.PresharedKey})
+	}
+	fileIPs, runningIPs := normalizePrefixes(file.AllowedIPs), normalizePrefixes(running.AllowedIPs)
+	if !slices.Equal(fileIPs, runningIPs) {
+		fields = append(fields, entity.DriftField{
+			Field:   "allowed_ips",
+			File:    strings.Join(fileIPs, ", "),
+			Running: strings.Join(runningIPs, ", "),
+		})
+	}
+	// Hostnames are resolved by the kernel and can't be compared
+	if _, err := netip.ParseAddrPort(file.Endpoint); err == nil && !sameEndpoint(file.Endpoint, running.Endpoint) {
+		fields = append(fields, entity.DriftField{Field: "endpoint", File: file.Endpoint, Running: running.Endpoint})
+	}
+	if keepaliveOf(file.PersistentKeepaliveInterval) != keepaliveOf(running.PersistentKeepaliveInterval) {
+		fields = append(fields, entity.DriftField{
+			Field:   "persistent_keepalive_interval",
+			File:    keepaliveOf(file.PersistentKeepaliveInterval).String(),
+			Running: keepaliveOf(running.PersistentKeepaliveInterval).String(),
+		})
+	}
+
+	return fields
+}
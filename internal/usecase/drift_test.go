@@ -0,0 +1,94 @@
+package usecase
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+)
+
+func TestDeviceDrift(t *testing.T) {
+	privateKey, _ := wgtypes.GeneratePrivateKey()
+	otherKey, _ := wgtypes.GeneratePrivateKey()
+	peer1, _ := wgtypes.GeneratePrivateKey()
+	peer2, _ := wgtypes.GeneratePrivateKey()
+	peer3, _ := wgtypes.GeneratePrivateKey()
+	peer4, _ := wgtypes.GeneratePrivateKey()
+
+	cfg, err := wgquick.ParseConfig(strings.NewReader("[Interface]\n" +
+		"PrivateKey = " + privateKey.String() + "\n" +
+		"Address = 10.0.0.1/24\n" +
+		"\n[Peer]\n" +
+		"PublicKey = " + peer1.PublicKey().String() + "\n" +
+		"AllowedIPs = 10.0.0.3/32, 10.0.0.2/32\n" +
+		"Endpoint = vpn.example.com:51820\n" +
+		"\n[Peer]\n" +
+		"PublicKey = " + peer2.PublicKey().String() + "\n" +
+		"AllowedIPs = 10.0.0.4/32\n" +
+		"Endpoint = 192.0.2.1:51820\n" +
+		"PersistentKeepalive = 25\n" +
+		"\n[Peer]\n" +
+		"PublicKey = " + peer3.PublicKey().String() + "\n" +
+		"AllowedIPs = 10.0.0.5/32\n"))
+	require.NoError(t, err)
+
+	device := &entity.Device{Name: "wg0", PrivateKey: privateKey.String(), ListenPort: 51820}
+	peers := []entity.Peer{
+		// Matches: allowed IP order and the hostname endpoint don't count
+		{PublicKey: peer1.PublicKey().String(), AllowedIPs: []string{"10.0.0.2/32", "10.0.0.3/32"}, Endpoint: "198.51.100.1:51820", PersistentKeepaliveInterval: "0s"},
+		{PublicKey: peer2.PublicKey().String(), AllowedIPs: []string{"10.0.0.4/32"}, Endpoint: "192.0.2.2:51820", PersistentKeepaliveInterval: "0s"},
+		{PublicKey: peer4.PublicKey().String(), AllowedIPs: []string{"10.0.0.6/32"}, PersistentKeepaliveInterval: "0s"},
+	}
+
+	// The file leaves the listen port to the kernel
+	drift := deviceDrift("wg0", cfg, device, peers)
+	assert.False(t, drift.InSync)
+	assert.Empty(t, drift.Fields)
+
+	expected := map[string]entity.PeerDrift{
+		peer2.PublicKey().String(): {
+			PublicKey: peer2.PublicKey().String(),
+			State:     entity.PeerDriftChanged,
+			Fields: []entity.DriftField{
+				{Field: "endpoint", File: "192.0.2.1:51820", Running: "192.0.2.2:51820"},
+				{Field: "persistent_keepalive_interval", File: "25s", Running: "0s"},
+			},
+		},
+		peer3.PublicKey().String(): {PublicKey: peer3.PublicKey().String(), State: entity.PeerDriftFileOnly},
+		peer4.PublicKey().String(): {PublicKey: peer4.PublicKey().String(), State: entity.PeerDriftRunningOnly},
+	}
+	require.Len(t, drift.Peers, 3)
+	for _, p := range drift.Peers {
+		assert.Equal(t, expected[p.PublicKey], p)
+	}
+
+	device.PrivateKey = otherKey.String()
+	device.FirewallMark = 7
+	drift = deviceDrift("wg0", cfg, device, peers[:1])
+	assert.Equal(t, []entity.DriftField{
+		{Field: "private_key", File: privateKey.String(), Running: otherKey.String()},
+		{Field: "firewall_mark", File: "0", Running: "7"},
+	}, drift.Fields)
+}
+
+func TestDeviceDrift_InSync(t *testing.T) {
+	cfg, err := wgquick.ParseConfig(strings.NewReader("[Interface]\nListenPort = 51820\n"))
+	require.NoError(t, err)
+
+	drift := deviceDrift("wg0", cfg, &entity.Device{Name: "wg0", ListenPort: 51820}, nil)
+	assert.True(t, drift.InSync)
+	assert.Equal(t, "wg0", drift.Device)
+}
+
+func TestSyncDevice_InvalidTarget(t *testing.T) {
+	_, err := NewDeviceUseCase(nil, nil, nil).SyncDevice(context.Background(), "wg0", "disk", false)
+	require.Error(t, err)
+	assert.Equal(t, entity.ErrCodeInvalidRequest, domain.Code(err))
+}
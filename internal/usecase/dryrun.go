@@ -0,0 +1,325 @@
+package usecase
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+	"github.com/suquant/wgrest/internal/infrastructure/wireguard"
+)
+
+// PlanCreateDevice runs the checks of CreateDevice and returns the resulting
+// device and config diff without touching the kernel or disk.
+func (uc *DeviceUseCase) PlanCreateDevice(ctx context.Context, req entity.DeviceCreateOrUpdateRequest) (*entity.DryRunResult, error) {
+	if err := domain.ValidateDeviceRequest(req, true); err != nil {
+		return nil, err
+	}
+
+	name := *req.Name
+	if _, err := uc.wgClient.Get(ctx, name); err == nil {
+		return nil, domain.AlreadyExists(entity.ErrCodeDeviceExists, "device %s already exists", name)
+	}
+
+	device := &entity.Device{Name: name, Running: true}
+	if err := applyDeviceKeys(device, req); err != nil {
+		return nil, err
+	}
+	applyDeviceOptions(device, req)
+
+	return planDevice(uc.wgquickSvc, device, nil)
+}
+
+// PlanUpdateDevice runs the checks of UpdateDevice and returns the resulting
+// device and config diff without touching the kernel or disk.
+func (uc *DeviceUseCase) PlanUpdateDevice(ctx context.Context, name string, req entity.DeviceCreateOrUpdateRequest, ifMatch string) (*entity.DryRunResult, error) {
+	if err := domain.ValidateDeviceRequest(req, false); err != nil {
+		return nil, err
+	}
+	if err := checkIfMatch(ifMatch, uc.deviceETag(ctx, name)); err != nil {
+		return nil, err
+	}
+
+	device, err := uc.wgClient.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	device.Running = true
+	enrichDeviceWithConfig(uc.wgquickSvc, device)
+
+	if err := applyDeviceKeys(device, req); err != nil {
+		return nil, err
+	}
+	applyDeviceOptions(device, req)
+
+	peers, err := uc.wgClient.ListPeers(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return planDevice(uc.wgquickSvc, device, peers)
+}
+
+// PlanDeleteDevice runs the checks of DeleteDevice.
+func (uc *DeviceUseCase) PlanDeleteDevice(ctx context.Context, name string, ifMatch string) (*entity.DryRunResult, error) {
+	if err := checkIfMatch(ifMatch, uc.deviceETag(ctx, name)); err != nil {
+		return nil, err
+	}
+	if _, err := uc.wgClient.Get(ctx, name); err != nil {
+		return nil, err
+	}
+	return nil, domain.NotSupported(entity.ErrCodeNotSupported, "device deletion not supported via wgctrl")
+}
+
+// PlanCreatePeer runs the checks of CreatePeer and returns the resulting peer
+// and config diff without touching the kernel or disk.
+func (uc *PeerUseCase) PlanCreatePeer(ctx context.Context, deviceName string, req entity.PeerCreateOrUpdateRequest) (*entity.DryRunResult, error) {
+	if err := domain.ValidatePeerRequest(req); err != nil {
+		return nil, err
+	}
+
+	device, peers, err := uc.loadDevice(ctx, deviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := newPeer(peers, req)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := planDevice(uc.wgquickSvc, device, append(peers, peer))
+	if err != nil {
+		return nil, err
+	}
+	result.Device = nil
+	result.Peer = &peer
+	result.Warnings = allowedIPWarnings(peers, peer)
+	return result, nil
+}
+
+// PlanUpdatePeer runs the checks of UpdatePeer and returns the resulting peer
+// and config diff without touching the kernel or disk.
+func (uc *PeerUseCase) PlanUpdatePeer(ctx context.Context, deviceName, urlSafePubKey string, req entity.PeerCreateOrUpdateRequest, ifMatch string) (*entity.DryRunResult, error) {
+	if err := domain.ValidatePeerRequest(req); err != nil {
+		return nil, err
+	}
+	if err := checkIfMatch(ifMatch, uc.peerETag(ctx, deviceName, urlSafePubKey)); err != nil {
+		return nil, err
+	}
+
+	device, peers, err := uc.loadDevice(ctx, deviceName)
+	if err != nil {
+		return nil, err
+	}
+	i, err := findPeer(peers, urlSafePubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	peer := updatedPeer(peers[i], req)
+	others := slices.Delete(slices.Clone(peers), i, i+1)
+	peers[i] = peer
+
+	result, err := planDevice(uc.wgquickSvc, device, peers)
+	if err != nil {
+		return nil, err
+	}
+	result.Device = nil
+	result.Peer = &peer
+	result.Warnings = allowedIPWarnings(others, peer)
+	return result, nil
+}
+
+// PlanDeletePeer runs the checks of DeletePeer and returns the peer and config
+// diff without touching the kernel or disk.
+func (uc *PeerUseCase) PlanDeletePeer(ctx context.Context, deviceName, urlSafePubKey string, ifMatch string) (*entity.DryRunResult, error) {
+	if err := checkIfMatch(ifMatch, uc.peerETag(ctx, deviceName, urlSafePubKey)); err != nil {
+		return nil, err
+	}
+	device, peers, err := uc.loadDevice(ctx, deviceName)
+	if err != nil {
+		return nil, err
+	}
+	i, err := findPeer(peers, urlSafePubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := peers[i]
+	peers = slices.Delete(peers, i, i+1)
+
+	result, err := planDevice(uc.wgquickSvc, device, peers)
+	if err != nil {
+		return nil, err
+	}
+	result.Device = nil
+	result.Peer = &existing
+	return result, nil
+}
+
+// loadDevice returns a device with its wg-quick options and peers, from the
+// config file if it isn't running.
+func (uc *PeerUseCase) loadDevice(ctx context.Context, name string) (*entity.Device, []entity.Peer, error) {
+	device, err := uc.wgClient.Get(ctx, name)
+	if err != nil {
+		return uc.offlineDevice(name, err)
+	}
+	device.Running = true
+	enrichDeviceWithConfig(uc.wgquickSvc, device)
+
+	peers, err := uc.wgClient.ListPeers(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return device, peers, nil
+}
+
+// planDevice renders the config for device and peers and diffs it against the
+// config file on disk.
+func planDevice(wgquickSvc *wgquick.Service, device *entity.Device, peers []entity.Peer) (*entity.DryRunResult, error) {
+	before, err := wgquickSvc.ReadConfig(device.Name)
+	if err != nil {
+		return nil, err
+	}
+	after := wgquickSvc.RenderConfig(device, peers)
+
+	device.PeersCount = int32(len(peers))
+	return &entity.DryRunResult{
+		Device:     device,
+		ConfigDiff: wgquick.Diff(device.Name, before, after),
+	}, nil
+}
+
+// applyDeviceKeys applies the kernel settings of a device request.
+func applyDeviceKeys(device *entity.Device, req entity.DeviceCreateOrUpdateRequest) error {
+	switch {
+	case req.PrivateKey != nil:
+		key, err := wgtypes.ParseKey(*req.PrivateKey)
+		if err != nil {
+			return domain.Validation(entity.ErrCodeInvalidKey, "invalid private key: %w", err)
+		}
+		device.PrivateKey = key.String()
+		device.PublicKey = key.PublicKey().String()
+	case device.PrivateKey == "":
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			return err
+		}
+		device.PrivateKey = key.String()
+		device.PublicKey = key.PublicKey().String()
+	}
+
+	if req.ListenPort != nil {
+		device.ListenPort = *req.ListenPort
+	} else if req.IsNull("listen_port") {
+		device.ListenPort = 0
+	}
+	if req.FirewallMark != nil {
+		device.FirewallMark = *req.FirewallMark
+	} else if req.IsNull("firewall_mark") {
+		device.FirewallMark = 0
+	}
+	return nil
+}
+
+// newPeer returns the peer a create request adds to peers, generating a key
+// pair if the request has no public key.
+func newPeer(peers []entity.Peer, req entity.PeerCreateOrUpdateRequest) (entity.Peer, error) {
+	var peer entity.Peer
+	if req.PublicKey != nil {
+		key, _ := wgtypes.ParseKey(*req.PublicKey)
+		if slices.ContainsFunc(peers, func(p entity.Peer) bool { return normalizeKey(p.PublicKey) == key.String() }) {
+			return peer, domain.AlreadyExists(entity.ErrCodePeerExists, "peer %s already exists", key.String())
+		}
+		peer.PublicKey = key.String()
+		peer.URLSafePublicKey = base64.URLEncoding.EncodeToString(key[:])
+	} else {
+		privateKey, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			return peer, err
+		}
+		publicKey := privateKey.PublicKey()
+		peer.PublicKey = publicKey.String()
+		peer.URLSafePublicKey = base64.URLEncoding.EncodeToString(publicKey[:])
+		peer.PrivateKey = privateKey.String()
+	}
+
+	peer.AllowedIPs = req.AllowedIPs
+	peer.PersistentKeepaliveInterval = "0s"
+	applyPeerOptions(&peer, req)
+	return peer, nil
+}
+
+// updatedPeer returns peer with an update request applied.
+func updatedPeer(peer entity.Peer, req entity.PeerCreateOrUpdateRequest) entity.Peer {
+	if len(req.AllowedIPs) > 0 || req.IsNull("allowed_ips") {
+		peer.AllowedIPs = req.AllowedIPs
+	}
+	applyPeerOptions(&peer, req)
+	return peer
+}
+
+// findPeer returns the index of the peer with a URL-safe public key.
+func findPeer(peers []entity.Peer, urlSafePubKey string) (int, error) {
+	key, err := wireguard.DecodeURLSafeKey(urlSafePubKey)
+	if err != nil {
+		return -1, err
+	}
+	i := slices.IndexFunc(peers, func(p entity.Peer) bool { return normalizeKey(p.PublicKey) == key.String() })
+	if i < 0 {
+		return -1, domain.NotFound(entity.ErrCodePeerNotFound, "peer %s not found", key.String())
+	}
+	return i, nil
+}
+
+// applyPeerOptions applies the optional settings of a peer request.
+func applyPeerOptions(peer *entity.Peer, req entity.PeerCreateOrUpdateRequest) {
+	if req.PresharedKey != nil {
+		peer.PresharedKey = *req.PresharedKey
+	} else if req.IsNull("preshared_key") {
+		peer.PresharedKey = ""
+	}
+	if req.PersistentKeepaliveInterval != nil {
+		peer.PersistentKeepaliveInterval = *req.PersistentKeepaliveInterval
+	} else if req.IsNull("persistent_keepalive_interval") {
+		peer.PersistentKeepaliveInterval = "0s"
+	}
+	if req.Endpoint != nil && *req.Endpoint != "" {
+		peer.Endpoint = *req.Endpoint
+	} else if req.IsNull("endpoint") {
+		peer.Endpoint = ""
+	}
+}
+
+// allowedIPWarnings reports allowed IPs of peer that WireGuard would take
+// away from other peers.
+func allowedIPWarnings(others []entity.Peer, peer entity.Peer) []string {
+	var warnings []string
+	for _, ip := range peer.AllowedIPs {
+		prefix, err := netip.ParsePrefix(ip)
+		if err != nil {
+			continue
+		}
+		prefix = prefix.Masked()
+
+		for _, other := range others {
+			if other.PublicKey == peer.PublicKey {
+				continue
+			}
+			for _, otherIP := range other.AllowedIPs {
+				otherPrefix, err := netip.ParsePrefix(otherIP)
+				if err == nil && otherPrefix.Masked() == prefix {
+					warnings = append(warnings, fmt.Sprintf("allowed IP %s would move from peer %s", prefix, other.PublicKey))
+				}
+			}
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,98 @@
+package usecase
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+)
+
+func TestPlanDevice_DiffsAgainstConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "wg0.conf"), []byte("[Interface]\nListenPort = 51820\n"), 0600))
+
+	svc, err := wgquick.NewService([]string{dir})
+	require.NoError(t, err)
+
+	device := &entity.Device{Name: "wg0", ListenPort: 51821}
+	result, err := planDevice(svc, device, []entity.Peer{{PublicKey: "peer"}})
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(1), result.Device.PeersCount)
+	assert.Contains(t, result.ConfigDiff, "-ListenPort = 51820\n+ListenPort = 51821\n")
+	assert.Contains(t, result.ConfigDiff, "+PublicKey = peer\n")
+
+	// Nothing was written
+	data, err := os.ReadFile(filepath.Join(dir, "wg0.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "[Interface]\nListenPort = 51820\n", string(data))
+}
+
+func TestApplyDeviceKeys(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	port := int32(51821)
+
+	device := &entity.Device{ListenPort: 51820, FirewallMark: 42}
+	require.NoError(t, applyDeviceKeys(device, entity.DeviceCreateOrUpdateRequest{
+		PrivateKey: ptr(key.String()),
+		ListenPort: &port,
+		NullFields: []string{"firewall_mark"},
+	}))
+
+	assert.Equal(t, key.String(), device.PrivateKey)
+	assert.Equal(t, key.PublicKey().String(), device.PublicKey)
+	assert.Equal(t, int32(51821), device.ListenPort)
+	assert.Equal(t, int32(0), device.FirewallMark)
+}
+
+func TestApplyDeviceKeys_GeneratesMissingKey(t *testing.T) {
+	device := &entity.Device{}
+	require.NoError(t, applyDeviceKeys(device, entity.DeviceCreateOrUpdateRequest{}))
+
+	key, err := wgtypes.ParseKey(device.PrivateKey)
+	require.NoError(t, err)
+	assert.Equal(t, key.PublicKey().String(), device.PublicKey)
+}
+
+func TestApplyPeerOptions(t *testing.T) {
+	peer := &entity.Peer{
+		PresharedKey:                "psk",
+		PersistentKeepaliveInterval: "25s",
+		Endpoint:                    "203.0.113.1:51820",
+	}
+
+	applyPeerOptions(peer, entity.PeerCreateOrUpdateRequest{
+		PersistentKeepaliveInterval: ptr("15s"),
+		NullFields:                  []string{"endpoint", "preshared_key"},
+	})
+
+	assert.Empty(t, peer.PresharedKey)
+	assert.Equal(t, "15s", peer.PersistentKeepaliveInterval)
+	assert.Empty(t, peer.Endpoint)
+}
+
+func TestAllowedIPWarnings(t *testing.T) {
+	others := []entity.Peer{
+		{PublicKey: "a", AllowedIPs: []string{"10.0.0.2/32", "192.168.1.0/24"}},
+		{PublicKey: "b", AllowedIPs: []string{"10.0.0.3/32"}},
+	}
+	peer := entity.Peer{PublicKey: "c", AllowedIPs: []string{"10.0.0.2/32", "192.168.1.7/24", "10.0.0.4/32"}}
+
+	assert.Equal(t, []string{
+		"allowed IP 10.0.0.2/32 would move from peer a",
+		"allowed IP 192.168.1.0/24 would move from peer a",
+	}, allowedIPWarnings(others, peer))
+
+	assert.Empty(t, allowedIPWarnings(others, entity.Peer{PublicKey: "a", AllowedIPs: []string{"10.0.0.2/32"}}))
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
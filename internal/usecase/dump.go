@@ -0,0 +1,21 @@
+package usecase
+
+import (
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/dump"
+)
+
+// DumpUseCase reports the state of config dumps.
+type DumpUseCase struct {
+	dumpSvc *dump.Service
+}
+
+// NewDumpUseCase creates a new dump use case.
+func NewDumpUseCase(dumpSvc *dump.Service) *DumpUseCase {
+	return &DumpUseCase{dumpSvc: dumpSvc}
+}
+
+// Status returns the dump state of every known device.
+func (uc *DumpUseCase) Status() []entity.DumpStatus {
+	return uc.dumpSvc.Status()
+}
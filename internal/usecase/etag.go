@@ -0,0 +1,71 @@
+package usecase
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+// DeviceETag returns the entity tag of a device. Traffic counters are left
+// out so the tag only changes when the device itself changes.
+func DeviceETag(d *entity.Device) string {
+	state := *d
+	state.TotalReceiveBytes = 0
+	state.TotalTransmitBytes = 0
+	return etagOf(state)
+}
+
+// PeerETag returns the entity tag of a peer, ignoring traffic counters and
+// handshakes.
+func PeerETag(p *entity.Peer) string {
+	state := *p
+	state.ReceiveBytes = 0
+	state.TransmitBytes = 0
+	state.LastHandshakeTime = time.Time{}
+	return etagOf(state)
+}
+
+func etagOf(v any) string {
+	b, _ := json.Marshal(v)
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ETagMatches reports whether an If-Match or If-None-Match header value
+// matches etag. Weak validators only match when weak is true (If-None-Match).
+func ETagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch compares an If-Match header value with the entity tag
+// returned by current. Empty values always pass. Callers hold the device
+// lock, so the resource can't change between the check and the update.
+func checkIfMatch(ifMatch string, current func() (string, error)) error {
+	if ifMatch == "" {
+		return nil
+	}
+
+	etag, err := current()
+	if err != nil {
+		return err
+	}
+	if !ETagMatches(ifMatch, etag, false) {
+		return domain.PreconditionFailed(entity.ErrCodePreconditionFailed,
+			"resource has changed (current ETag %s)", etag)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package usecase
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+func TestDeviceETag_IgnoresCounters(t *testing.T) {
+	device := &entity.Device{Name: "wg0", ListenPort: 51820, TotalReceiveBytes: 10}
+	tag := DeviceETag(device)
+
+	device.TotalReceiveBytes = 2000
+	device.TotalTransmitBytes = 3000
+	assert.Equal(t, tag, DeviceETag(device))
+
+	device.ListenPort = 51821
+	assert.NotEqual(t, tag, DeviceETag(device))
+}
+
+func TestPeerETag_IgnoresCounters(t *testing.T) {
+	peer := &entity.Peer{PublicKey: "key", AllowedIPs: []string{"10.0.0.2/32"}}
+	tag := PeerETag(peer)
+
+	peer.ReceiveBytes = 100
+	peer.TransmitBytes = 200
+	peer.LastHandshakeTime = time.Now()
+	assert.Equal(t, tag, PeerETag(peer))
+
+	peer.Endpoint = "203.0.113.1:51820"
+	assert.NotEqual(t, tag, PeerETag(peer))
+}
+
+func TestETagMatches(t *testing.T) {
+	etag := `"abc"`
+
+	assert.True(t, ETagMatches(`"abc"`, etag, false))
+	assert.True(t, ETagMatches(`"x", "abc"`, etag, false))
+	assert.True(t, ETagMatches("*", etag, false))
+	assert.False(t, ETagMatches(`"x"`, etag, false))
+	assert.False(t, ETagMatches(`W/"abc"`, etag, false))
+	assert.True(t, ETagMatches(`W/"abc"`, etag, true))
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	boom := errors.New("boom")
+
+	testCases := []struct {
+		name         string
+		ifMatch      string
+		current      func() (string, error)
+		expectedCode string
+		expectedErr  error
+	}{
+		{"no header", "", nil, "", nil},
+		{"match", `"abc"`, func() (string, error) { return `"abc"`, nil }, "", nil},
+		{"mismatch", `"old"`, func() (string, error) { return `"abc"`, nil }, entity.ErrCodePreconditionFailed, nil},
+		{"wildcard", "*", func() (string, error) { return `"abc"`, nil }, "", nil},
+		{"missing resource", `"abc"`, func() (string, error) {
+			return "", domain.NotFound(entity.ErrCodePeerNotFound, "peer not found")
+		}, entity.ErrCodePeerNotFound, nil},
+		{"lookup failure", `"abc"`, func() (string, error) { return "", boom }, "", boom},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkIfMatch(tc.ifMatch, tc.current)
+			switch {
+			case tc.expectedCode != "":
+				assert.Equal(t, tc.expectedCode, domain.Code(err))
+			case tc.expectedErr != nil:
+				assert.ErrorIs(t, err, tc.expectedErr)
+			default:
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+)
+
+// ListConfigRevisions returns the config revisions of a device, newest first.
+func (uc *DeviceUseCase) ListConfigRevisions(ctx context.Context, name string) ([]entity.ConfigRevision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return uc.wgquickSvc.Revisions(name)
+}
+
+// DiffConfigRevisions returns a unified diff between two config revisions
+// of a device. An empty to compares with the current config file.
+func (uc *DeviceUseCase) DiffConfigRevisions(ctx context.Context, name, from, to string) (*entity.ConfigRevisionDiff, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	before, err := uc.wgquickSvc.ReadRevision(name, from)
+	if err != nil {
+		return nil, err
+	}
+
+	var after string
+	if to == "" {
+		after, err = uc.wgquickSvc.ReadConfig(name)
+	} else {
+		after, err = uc.wgquickSvc.ReadRevision(name, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &entity.ConfigRevisionDiff{
+		From: from,
+		To:   to,
+		Diff: wgquick.Diff(name, before, after),
+	}, nil
+}
+
+// RollbackConfig restores the config file of a device to a revision and, if
+// the device is running, brings it to that config. With dryRun the changes
+// are only computed.
+func (uc *DeviceUseCase) RollbackConfig(ctx context.Context, name, id string, dryRun bool) (*entity.ConfigRollbackResult, error) {
+	unlock, err := deviceLocks.lock(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	data, err := uc.wgquickSvc.ReadRevision(name, id)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := uc.wgquickSvc.ReadConfig(name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &entity.ConfigRollbackResult{
+		Revision: id,
+		Diff:     wgquick.Diff(name, current, data),
+		DryRun:   dryRun,
+	}
+	result.Running, result.Changes, err = uc.restoreConfig(ctx, uc.wgquickSvc.GetConfigDir(name), name, []byte(data), dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// restoreConfig writes the config file of a device in dir and, if the device
+// is running and uses that file, brings it to the config. It reports whether
+// the device was running and the changes made to it. With dryRun the changes
+// are only computed. Callers must hold the device lock.
+func (uc *DeviceUseCase) restoreConfig(ctx context.Context, dir, name string, data []byte, dryRun bool) (bool, []entity.SpecChange, error) {
+	cfg, err := uc.wgquickSvc.DecodeConfig(data)
+	if domain.Code(err) == entity.ErrCodeEncryptedKey {
+		return false, nil, err
+	}
+	if err != nil {
+		return false, nil, domain.Validation(entity.ErrCodeInvalidRequest, "failed to parse config for %s: %w", name, err)
+	}
+	spec := configSpec(name, cfg)
+	if err := domain.ValidateDeviceSpec(name, spec); err != nil {
+		return false, nil, err
+	}
+
+	// The periodic dump would overwrite the file with the running config,
+	// so a running device using it is brought to the restored config
+	_, err = uc.wgClient.Get(ctx, name)
+	running := err == nil && uc.wgquickSvc.GetConfigDir(name) == dir
+
+	var changes []entity.SpecChange
+	if running {
+		applied, err := uc.applyDeviceSpec(ctx, name, spec, dryRun)
+		if err != nil {
+			return false, nil, err
+		}
+		changes = applied.Changes
+	}
+
+	// Written last, as applying the spec saves a rendered config
+	if !dryRun {
+		if err := uc.wgquickSvc.WriteConfigIn(dir, name, data); err != nil {
+			return false, nil, err
+		}
+	}
+
+	return running, changes, nil
+}
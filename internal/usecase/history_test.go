@@ -0,0 +1,43 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+)
+
+func TestDiffConfigRevisions(t *testing.T) {
+	configDir := t.TempDir()
+	svc, err := wgquick.NewService([]string{configDir})
+	require.NoError(t, err)
+	svc.SetHistory(wgquick.NewHistory(t.TempDir(), 10))
+
+	require.NoError(t, svc.WriteConfigIn(configDir, "wg0", []byte("[Interface]\nMTU = 1280\n")))
+	require.NoError(t, svc.WriteConfigIn(configDir, "wg0", []byte("[Interface]\nMTU = 1420\n")))
+
+	ctx := context.Background()
+	uc := NewDeviceUseCase(nil, svc, nil)
+	revisions, err := uc.ListConfigRevisions(ctx, "wg0")
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+
+	diff, err := uc.DiffConfigRevisions(ctx, "wg0", revisions[1].ID, revisions[0].ID)
+	require.NoError(t, err)
+	assert.Contains(t, diff.Diff, "-MTU = 1280\n+MTU = 1420\n")
+
+	// Without to the revision is compared with the config file
+	diff, err = uc.DiffConfigRevisions(ctx, "wg0", revisions[0].ID, "")
+	require.NoError(t, err)
+	assert.Empty(t, diff.Diff)
+
+	_, err = uc.DiffConfigRevisions(ctx, "wg0", revisions[0].ID, "20261019T120000.000000000Z")
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+
+	_, err = uc.RollbackConfig(ctx, "wg0", "20261019T120000.000000000Z", true)
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+}
@@ -0,0 +1,65 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+)
+
+// deviceLocks serializes the mutations of each device. All use cases in a
+// process act on the same interfaces and config files, so they share one.
+var deviceLocks = newDeviceLockManager()
+
+// deviceLockManager hands out one lock per device name. Locks are dropped
+// once nobody holds or waits for them.
+type deviceLockManager struct {
+	mu    sync.Mutex
+	locks map[string]*deviceLock
+}
+
+type deviceLock struct {
+	// sem holds a token while the lock is held, so waiting can be given up
+	sem  chan struct{}
+	refs int
+}
+
+func newDeviceLockManager() *deviceLockManager {
+	return &deviceLockManager{locks: make(map[string]*deviceLock)}
+}
+
+// lock waits until the device is no longer locked, or ctx is done, and locks
+// it. The returned function unlocks it.
+func (m *deviceLockManager) lock(ctx context.Context, name string) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	l, ok := m.locks[name]
+	if !ok {
+		l = &deviceLock{sem: make(chan struct{}, 1)}
+		m.locks[name] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	select {
+	case l.sem <- struct{}{}:
+		return func() {
+			<-l.sem
+			m.release(name, l)
+		}, nil
+	case <-ctx.Done():
+		m.release(name, l)
+		return nil, ctx.Err()
+	}
+}
+
+func (m *deviceLockManager) release(name string, l *deviceLock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l.refs--
+	if l.refs == 0 {
+		delete(m.locks, name)
+	}
+}
@@ -0,0 +1,89 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceLockManager(t *testing.T) {
+	m := newDeviceLockManager()
+
+	unlock, err := m.lock(context.Background(), "wg0")
+	require.NoError(t, err)
+
+	// Other devices aren't blocked
+	unlockOther, err := m.lock(context.Background(), "wg1")
+	require.NoError(t, err)
+	unlockOther()
+
+	// The same device is, until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = m.lock(ctx, "wg0")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Or the lock is released
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		unlock, err := m.lock(context.Background(), "wg0")
+		if assert.NoError(t, err) {
+			unlock()
+		}
+	}()
+	select {
+	case <-done:
+		t.Fatal("locked while held")
+	case <-time.After(10 * time.Millisecond):
+	}
+	unlock()
+	<-done
+
+	// Done contexts don't lock at all
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = m.lock(ctx, "wg2")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Released locks are dropped
+	assert.Empty(t, m.locks)
+}
+
+func TestDeviceLockManager_Serializes(t *testing.T) {
+	m := newDeviceLockManager()
+
+	var wg sync.WaitGroup
+	running, maxRunning := 0, 0
+	var mu sync.Mutex
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := m.lock(context.Background(), "wg0")
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer unlock()
+
+			mu.Lock()
+			running++
+			maxRunning = max(maxRunning, running)
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, maxRunning)
+	assert.Empty(t, m.locks)
+}
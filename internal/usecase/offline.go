@@ -0,0 +1,112 @@
+package usecase
+
+import (
+	"encoding/base64"
+	"errors"
+	"slices"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+)
+
+// offlineDevice returns a device that isn't running and the peers in its
+// config file, so peers can be staged for the next Up. err is what the kernel
+// returned for the device; it is returned unless the device isn't running and
+// has a config file.
+func (uc *PeerUseCase) offlineDevice(name string, err error) (*entity.Device, []entity.Peer, error) {
+	if domain.Code(err) != entity.ErrCodeDeviceNotFound {
+		return nil, nil, err
+	}
+
+	cfg, cfgErr := uc.wgquickSvc.LoadConfig(name)
+	if cfgErr != nil {
+		if errors.Is(cfgErr, domain.ErrNotFound) {
+			return nil, nil, err
+		}
+		return nil, nil, cfgErr
+	}
+
+	return configDevice(name, cfg), configFilePeers(cfg), nil
+}
+
+// configFilePeers returns the peers of a parsed config file with their
+// URL-safe public keys.
+func configFilePeers(cfg *wgquick.Config) []entity.Peer {
+	peers := peersFromConfig(cfg)
+	for i := range peers {
+		if key, err := base64.StdEncoding.DecodeString(peers[i].PublicKey); err == nil {
+			peers[i].URLSafePublicKey = base64.URLEncoding.EncodeToString(key)
+		}
+	}
+	return peers
+}
+
+// editConfigPeers replaces the peers in the config file of a device that
+// isn't running with those fn returns, holding the config lock throughout.
+func (uc *PeerUseCase) editConfigPeers(name string, fn func(peers []entity.Peer) ([]entity.Peer, error)) error {
+	return uc.wgquickSvc.EditConfig(name, func(cfg *wgquick.Config) (string, error) {
+		if cfg == nil {
+			return "", domain.NotFound(entity.ErrCodeConfigNotFound, "config for %s not found", name)
+		}
+		peers, err := fn(configFilePeers(cfg))
+		if err != nil {
+			return "", err
+		}
+		return uc.wgquickSvc.RenderConfig(configDevice(name, cfg), peers), nil
+	})
+}
+
+// createConfigPeer adds a peer to the config file of a device that isn't
+// running.
+func (uc *PeerUseCase) createConfigPeer(name string, req entity.PeerCreateOrUpdateRequest) (*entity.Peer, error) {
+	var peer entity.Peer
+	err := uc.editConfigPeers(name, func(peers []entity.Peer) ([]entity.Peer, error) {
+		var err error
+		if peer, err = newPeer(peers, req); err != nil {
+			return nil, err
+		}
+		return append(peers, peer), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &peer, nil
+}
+
+// updateConfigPeer updates a peer in the config file of a device that isn't
+// running.
+func (uc *PeerUseCase) updateConfigPeer(name, urlSafePubKey string, req entity.PeerCreateOrUpdateRequest) (*entity.Peer, error) {
+	var peer entity.Peer
+	err := uc.editConfigPeers(name, func(peers []entity.Peer) ([]entity.Peer, error) {
+		i, err := findPeer(peers, urlSafePubKey)
+		if err != nil {
+			return nil, err
+		}
+		peer = updatedPeer(peers[i], req)
+		peers[i] = peer
+		return peers, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &peer, nil
+}
+
+// deleteConfigPeer removes a peer from the config file of a device that isn't
+// running.
+func (uc *PeerUseCase) deleteConfigPeer(name, urlSafePubKey string) (*entity.Peer, error) {
+	var peer entity.Peer
+	err := uc.editConfigPeers(name, func(peers []entity.Peer) ([]entity.Peer, error) {
+		i, err := findPeer(peers, urlSafePubKey)
+		if err != nil {
+			return nil, err
+		}
+		peer = peers[i]
+		return slices.Delete(peers, i, i+1), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &peer, nil
+}
@@ -0,0 +1,95 @@
+package usecase
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+)
+
+func TestOfflinePeers(t *testing.T) {
+	configDir := t.TempDir()
+	svc, err := wgquick.NewService([]string{configDir})
+	require.NoError(t, err)
+
+	peerKey, _ := wgtypes.GeneratePrivateKey()
+	publicKey := peerKey.PublicKey()
+	require.NoError(t, svc.WriteConfigIn(configDir, "wg0", []byte("[Interface]\n"+
+		"PrivateKey = aGVsbG8=\nAddress = 10.0.0.1/24\nPostUp = echo up\n")))
+
+	uc := NewPeerUseCase(nil, svc, nil)
+	notRunning := domain.NotFound(entity.ErrCodeDeviceNotFound, "device wg0 not found")
+
+	// Stage a peer in the config file
+	device, peers, err := uc.offlineDevice("wg0", notRunning)
+	require.NoError(t, err)
+	assert.False(t, device.Running)
+	assert.Empty(t, peers)
+
+	pub := publicKey.String()
+	peer, err := uc.createConfigPeer("wg0", entity.PeerCreateOrUpdateRequest{
+		PublicKey:  &pub,
+		AllowedIPs: []string{"10.0.0.2/32"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, pub, peer.PublicKey)
+	assert.NotEmpty(t, peer.URLSafePublicKey)
+
+	_, err = uc.createConfigPeer("wg0", entity.PeerCreateOrUpdateRequest{PublicKey: &pub})
+	assert.ErrorIs(t, err, domain.ErrAlreadyExists)
+
+	// Update it, keeping the other settings of the file
+	keepalive := "25s"
+	_, peers, err = uc.offlineDevice("wg0", notRunning)
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	peer, err = uc.updateConfigPeer("wg0", peers[0].URLSafePublicKey, entity.PeerCreateOrUpdateRequest{
+		PersistentKeepaliveInterval: &keepalive,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.2/32"}, peer.AllowedIPs)
+
+	data, err := svc.ReadConfig("wg0")
+	require.NoError(t, err)
+	assert.Contains(t, data, "Address = 10.0.0.1/24\n")
+	assert.Contains(t, data, "PostUp = echo up\n")
+	assert.Contains(t, data, "PublicKey = "+pub+"\nAllowedIPs = 10.0.0.2/32\nPersistentKeepalive = 25\n")
+
+	// And remove it
+	_, err = uc.deleteConfigPeer("wg0", peer.URLSafePublicKey)
+	require.NoError(t, err)
+
+	_, peers, err = uc.offlineDevice("wg0", notRunning)
+	require.NoError(t, err)
+	assert.Empty(t, peers)
+	_, err = uc.deleteConfigPeer("wg0", peer.URLSafePublicKey)
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+
+	// A config file removed in the meantime isn't recreated
+	require.NoError(t, os.Remove(filepath.Join(configDir, "wg0.conf")))
+	_, err = uc.createConfigPeer("wg0", entity.PeerCreateOrUpdateRequest{PublicKey: &pub})
+	assert.Equal(t, entity.ErrCodeConfigNotFound, domain.Code(err))
+}
+
+func TestOfflineDevice_NoConfig(t *testing.T) {
+	svc, err := wgquick.NewService([]string{t.TempDir()})
+	require.NoError(t, err)
+	uc := NewPeerUseCase(nil, svc, nil)
+
+	// Without a config file the kernel error is kept
+	notRunning := domain.NotFound(entity.ErrCodeDeviceNotFound, "device wg0 not found")
+	_, _, err = uc.offlineDevice("wg0", notRunning)
+	assert.Equal(t, entity.ErrCodeDeviceNotFound, domain.Code(err))
+
+	// Other errors are never handled offline
+	unavailable := domain.BackendUnavailable(entity.ErrCodeBackendUnavailable, "netlink down")
+	_, _, err = uc.offlineDevice("wg0", unavailable)
+	assert.Equal(t, unavailable, err)
+}
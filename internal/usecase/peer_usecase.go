@@ -1,10 +1,13 @@
 package usecase
 
 import (
+	"context"
 	"sort"
 	"strings"
 
+	"github.com/suquant/wgrest/internal/domain"
 	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/dump"
 	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
 	"github.com/suquant/wgrest/internal/infrastructure/wireguard"
 )
@@ -13,24 +16,31 @@ import (
 type PeerUseCase struct {
 	wgClient   *wireguard.Client
 	wgquickSvc *wgquick.Service
+	dumpSvc    *dump.Service
 }
 
-// NewPeerUseCase creates a new peer use case.
+// NewPeerUseCase creates a new peer use case. Config saves go through dumpSvc
+// when it is set, so bursts of mutations are written once.
 func NewPeerUseCase(
 	wgClient *wireguard.Client,
 	wgquickSvc *wgquick.Service,
+	dumpSvc *dump.Service,
 ) *PeerUseCase {
 	return &PeerUseCase{
 		wgClient:   wgClient,
 		wgquickSvc: wgquickSvc,
+		dumpSvc:    dumpSvc,
 	}
 }
 
 // ListPeers returns all peers for a device with pagination, filtering, and sorting.
-func (uc *PeerUseCase) ListPeers(deviceName string, page, perPage int, query, sortField string) ([]entity.Peer, int, error) {
-	peers, err := uc.wgClient.ListPeers(deviceName)
+// Peers of a device that isn't running are read from its config file.
+func (uc *PeerUseCase) ListPeers(ctx context.Context, deviceName string, page, perPage int, query, sortField string) ([]entity.Peer, int, error) {
+	peers, err := uc.wgClient.ListPeers(ctx, deviceName)
 	if err != nil {
-		return nil, 0, err
+		if _, peers, err = uc.offlineDevice(deviceName, err); err != nil {
+			return nil, 0, err
+		}
 	}
 
 	// Apply search filter
@@ -74,56 +84,140 @@ func (uc *PeerUseCase) ListPeers(deviceName string, page, perPage int, query, so
 }
 
 // GetPeer returns a specific peer.
-func (uc *PeerUseCase) GetPeer(deviceName string, urlSafePubKey string) (*entity.Peer, error) {
-	return uc.wgClient.GetPeer(deviceName, urlSafePubKey)
+func (uc *PeerUseCase) GetPeer(ctx context.Context, deviceName string, urlSafePubKey string) (*entity.Peer, error) {
+	return uc.getPeer(ctx, deviceName, urlSafePubKey, uc.wgClient.GetPeer)
+}
+
+// getPeer returns a peer looked up with get, or from the config file if the
+// device isn't running.
+func (uc *PeerUseCase) getPeer(ctx context.Context, deviceName, urlSafePubKey string, get func(context.Context, string, string) (*entity.Peer, error)) (*entity.Peer, error) {
+	peer, err := get(ctx, deviceName, urlSafePubKey)
+	if err == nil {
+		return peer, nil
+	}
+
+	_, peers, err := uc.offlineDevice(deviceName, err)
+	if err != nil {
+		return nil, err
+	}
+	i, err := findPeer(peers, urlSafePubKey)
+	if err != nil {
+		return nil, err
+	}
+	return &peers[i], nil
 }
 
 // CreatePeer creates a new peer.
-func (uc *PeerUseCase) CreatePeer(deviceName string, req entity.PeerCreateOrUpdateRequest) (*entity.Peer, error) {
-	peer, err := uc.wgClient.CreatePeer(deviceName, req)
+func (uc *PeerUseCase) CreatePeer(ctx context.Context, deviceName string, req entity.PeerCreateOrUpdateRequest) (*entity.Peer, error) {
+	if err := domain.ValidatePeerRequest(req); err != nil {
+		return nil, err
+	}
+
+	unlock, err := deviceLocks.lock(ctx, deviceName)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
+
+	peer, err := uc.wgClient.CreatePeer(ctx, deviceName, req)
+	if err != nil {
+		// Peers of a device that isn't running are staged in its config file
+		if _, _, err := uc.offlineDevice(deviceName, err); err != nil {
+			return nil, err
+		}
+		return uc.createConfigPeer(deviceName, req)
+	}
 
 	// Trigger config save
-	uc.saveDeviceConfig(deviceName)
+	uc.saveDeviceConfig(ctx, deviceName)
 
 	return peer, nil
 }
 
-// UpdatePeer updates a peer.
-func (uc *PeerUseCase) UpdatePeer(deviceName string, urlSafePubKey string, req entity.PeerCreateOrUpdateRequest) (*entity.Peer, error) {
-	peer, err := uc.wgClient.UpdatePeer(deviceName, urlSafePubKey, req)
+// UpdatePeer updates a peer. A non-empty ifMatch must match the current ETag
+// of the peer.
+func (uc *PeerUseCase) UpdatePeer(ctx context.Context, deviceName string, urlSafePubKey string, req entity.PeerCreateOrUpdateRequest, ifMatch string) (*entity.Peer, error) {
+	if err := domain.ValidatePeerRequest(req); err != nil {
+		return nil, err
+	}
+
+	unlock, err := deviceLocks.lock(ctx, deviceName)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
+
+	if err := checkIfMatch(ifMatch, uc.peerETag(ctx, deviceName, urlSafePubKey)); err != nil {
+		return nil, err
+	}
+
+	peer, err := uc.wgClient.UpdatePeer(ctx, deviceName, urlSafePubKey, req)
+	if err != nil {
+		if _, _, err := uc.offlineDevice(deviceName, err); err != nil {
+			return nil, err
+		}
+		return uc.updateConfigPeer(deviceName, urlSafePubKey, req)
+	}
 
 	// Trigger config save
-	uc.saveDeviceConfig(deviceName)
+	uc.saveDeviceConfig(ctx, deviceName)
 
 	return peer, nil
 }
 
-// DeletePeer deletes a peer.
-func (uc *PeerUseCase) DeletePeer(deviceName string, urlSafePubKey string) (*entity.Peer, error) {
-	peer, err := uc.wgClient.DeletePeer(deviceName, urlSafePubKey)
+// DeletePeer deletes a peer. A non-empty ifMatch must match the current ETag
+// of the peer.
+func (uc *PeerUseCase) DeletePeer(ctx context.Context, deviceName string, urlSafePubKey string, ifMatch string) (*entity.Peer, error) {
+	unlock, err := deviceLocks.lock(ctx, deviceName)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
+
+	if err := checkIfMatch(ifMatch, uc.peerETag(ctx, deviceName, urlSafePubKey)); err != nil {
+		return nil, err
+	}
+
+	peer, err := uc.wgClient.DeletePeer(ctx, deviceName, urlSafePubKey)
+	if err != nil {
+		if _, _, err := uc.offlineDevice(deviceName, err); err != nil {
+			return nil, err
+		}
+		return uc.deleteConfigPeer(deviceName, urlSafePubKey)
+	}
 
 	// Trigger config save
-	uc.saveDeviceConfig(deviceName)
+	uc.saveDeviceConfig(ctx, deviceName)
 
 	return peer, nil
 }
 
-func (uc *PeerUseCase) saveDeviceConfig(deviceName string) {
-	// Use wg showconf to save current state
-	if err := uc.wgquickSvc.SaveFromShowconf(deviceName); err != nil {
-		// Log but don't fail
+// peerETag returns a function computing the current entity tag of a peer
+// from the kernel, bypassing the peer cache.
+func (uc *PeerUseCase) peerETag(ctx context.Context, deviceName, urlSafePubKey string) func() (string, error) {
+	return func() (string, error) {
+		peer, err := uc.getPeer(ctx, deviceName, urlSafePubKey, uc.wgClient.GetPeerUncached)
+		if err != nil {
+			return "", err
+		}
+		return PeerETag(peer), nil
 	}
 }
 
+func (uc *PeerUseCase) saveDeviceConfig(ctx context.Context, deviceName string) {
+	if uc.dumpSvc != nil {
+		uc.dumpSvc.Request(deviceName)
+		return
+	}
+
+	device, peers, err := uc.wgClient.GetWithPeers(ctx, deviceName)
+	if err != nil {
+		return
+	}
+	if err := uc.wgquickSvc.SaveRunningConfig(device, peers); err != nil {
+		// Log but don't fail
+	}
+}
 
 func containsIP(ips []string, query string) bool {
 	for _, ip := range ips {
@@ -0,0 +1,417 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"sort"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+	"github.com/suquant/wgrest/internal/infrastructure/wireguard"
+)
+
+// deviceSpecPlan is what it takes to bring a device to a spec.
+type deviceSpecPlan struct {
+	changes []entity.SpecChange
+
+	// device holds the changed device settings; kernel is set if any of
+	// them must be applied to the interface
+	device entity.DeviceCreateOrUpdateRequest
+	kernel bool
+
+	ops []entity.PeerBatchOperation
+}
+
+// GetDeviceSpec returns the current spec of a running or config-only device.
+func (uc *DeviceUseCase) GetDeviceSpec(ctx context.Context, name string) (*entity.DeviceSpec, error) {
+	device, err := uc.GetDevice(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var peers []entity.Peer
+	if device.Running {
+		peers, err = uc.wgClient.ListPeers(ctx, name)
+	} else {
+		peers, err = configPeers(uc.wgquickSvc, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	spec := deviceSpec(device, peers)
+	return &spec, nil
+}
+
+// ApplyDeviceSpec brings a device to spec, changing only what differs, and
+// saves its config. The config file of a device that isn't running is brought
+// to spec instead. With dryRun the changes are only computed.
+func (uc *DeviceUseCase) ApplyDeviceSpec(ctx context.Context, name string, spec entity.DeviceSpec, dryRun bool) (*entity.DeviceSpecResult, error) {
+	unlock, err := deviceLocks.lock(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	result, err := uc.applyDeviceSpec(ctx, name, spec, dryRun)
+	if domain.Code(err) == entity.ErrCodeDeviceNotFound {
+		return uc.applyConfigSpec(name, spec, dryRun, err)
+	}
+	return result, err
+}
+
+// applyDeviceSpec brings a running device to spec, for callers holding the
+// device lock.
+func (uc *DeviceUseCase) applyDeviceSpec(ctx context.Context, name string, spec entity.DeviceSpec, dryRun bool) (*entity.DeviceSpecResult, error) {
+	if err := domain.ValidateDeviceSpec(name, spec); err != nil {
+		return nil, err
+	}
+
+	device, err := uc.wgClient.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	device.Running = true
+	enrichDeviceWithConfig(uc.wgquickSvc, device)
+
+	peers, err := uc.wgClient.ListPeers(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := planDeviceSpec(deviceSpec(device, peers), spec)
+	result := &entity.DeviceSpecResult{Changes: plan.changes, DryRun: dryRun}
+	if dryRun || len(plan.changes) == 0 {
+		return result, nil
+	}
+
+	// The device is changed and its config written under one config lock, so
+	// changes made by other processes in the meantime aren't overwritten
+	err = uc.wgquickSvc.EditConfig(name, func(cfg *wgquick.Config) (string, error) {
+		if plan.kernel {
+			if device, err = uc.wgClient.Update(ctx, name, plan.device); err != nil {
+				return "", err
+			}
+		}
+		if cfg != nil {
+			applyConfigOptions(device, cfg)
+		}
+		applyDeviceOptions(device, plan.device)
+
+		if len(plan.ops) > 0 {
+			items := make([]wireguard.PeerBatchItem, len(plan.ops))
+			for i, op := range plan.ops {
+				items[i].Op = op
+			}
+			if _, err := uc.wgClient.ApplyPeerBatch(ctx, name, items, true); err != nil {
+				return "", err
+			}
+			for _, item := range items {
+				if item.Err != nil {
+					return "", item.Err
+				}
+			}
+		}
+
+		peers, err := uc.wgClient.ListPeers(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		return uc.wgquickSvc.RenderConfig(device, peers), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// applyConfigSpec brings the config file of a device that isn't running to
+// spec. err is what the kernel returned for the device; it is returned if
+// the device has no config file either.
+func (uc *DeviceUseCase) applyConfigSpec(name string, spec entity.DeviceSpec, dryRun bool, err error) (*entity.DeviceSpecResult, error) {
+	if _, cfgErr := uc.wgquickSvc.LoadConfig(name); cfgErr != nil {
+		if errors.Is(cfgErr, domain.ErrNotFound) {
+			return nil, err
+		}
+		return nil, cfgErr
+	}
+
+	result := &entity.DeviceSpecResult{DryRun: dryRun}
+	err = uc.wgquickSvc.EditConfig(name, func(cfg *wgquick.Config) (string, error) {
+		if cfg == nil {
+			return "", err
+		}
+
+		device, peers := configDevice(name, cfg), configFilePeers(cfg)
+		plan := planDeviceSpec(deviceSpec(device, peers), spec)
+		result.Changes = plan.changes
+		if dryRun || len(plan.changes) == 0 {
+			return "", nil
+		}
+
+		if err := applyDeviceKeys(device, plan.device); err != nil {
+			return "", err
+		}
+		applyDeviceOptions(device, plan.device)
+		peers, err := applyPeerOps(peers, plan.ops)
+		if err != nil {
+			return "", err
+		}
+		return uc.wgquickSvc.RenderConfig(device, peers), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// applyPeerOps applies the peer operations of a spec plan to peers.
+func applyPeerOps(peers []entity.Peer, ops []entity.PeerBatchOperation) ([]entity.Peer, error) {
+	for _, op := range ops {
+		i := slices.IndexFunc(peers, func(p entity.Peer) bool { return normalizeKey(p.PublicKey) == op.PublicKey })
+
+		switch op.Op {
+		case entity.PeerBatchCreate:
+			peer, err := newPeer(peers, op.Peer)
+			if err != nil {
+				return nil, err
+			}
+			peers = append(peers, peer)
+		case entity.PeerBatchUpdate:
+			peers[i] = updatedPeer(peers[i], op.Peer)
+		case entity.PeerBatchDelete:
+			peers = slices.Delete(peers, i, i+1)
+		}
+	}
+	return peers, nil
+}
+
+// configPeers returns the peers listed in the config file of a device.
+func configPeers(wgquickSvc *wgquick.Service, name string) ([]entity.Peer, error) {
+	cfg, err := wgquickSvc.LoadConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	return peersFromConfig(cfg), nil
+}
+
+// peersFromConfig returns the peers of a parsed config file.
+func peersFromConfig(cfg *wgquick.Config) []entity.Peer {
+	peers := make([]entity.Peer, len(cfg.Peers))
+	for i, p := range cfg.Peers {
+		peers[i] = entity.Peer{
+			PublicKey:                   p.PublicKey,
+			PresharedKey:                p.PresharedKey,
+			AllowedIPs:                  p.AllowedIPs,
+			Endpoint:                    p.Endpoint,
+			PersistentKeepaliveInterval: (time.Duration(p.PersistentKeepaliveInterval) * time.Second).String(),
+		}
+	}
+	return peers
+}
+
+// deviceSpec returns the spec of a device and its peers, sorted by public key.
+func deviceSpec(device *entity.Device, peers []entity.Peer) entity.DeviceSpec {
+	spec := entity.DeviceSpec{
+		Name:         device.Name,
+		ListenPort:   device.ListenPort,
+		PrivateKey:   device.PrivateKey,
+		FirewallMark: device.FirewallMark,
+		Addresses:    device.Addresses,
+		DNS:          device.DNS,
+		MTU:          device.MTU,
+		Table:        device.Table,
+		PreUp:        device.PreUp,
+		PostUp:       device.PostUp,
+		PreDown:      device.PreDown,
+		PostDown:     device.PostDown,
+		Peers:        make([]entity.PeerSpec, len(peers)),
+	}
+
+	for i, p := range peers {
+		spec.Peers[i] = entity.PeerSpec{
+			PublicKey:    p.PublicKey,
+			PresharedKey: p.PresharedKey,
+			AllowedIPs:   p.AllowedIPs,
+			Endpoint:     p.Endpoint,
+		}
+		if keepaliveOf(p.PersistentKeepaliveInterval) > 0 {
+			spec.Peers[i].PersistentKeepaliveInterval = p.PersistentKeepaliveInterval
+		}
+	}
+	sort.Slice(spec.Peers, func(i, j int) bool {
+		return spec.Peers[i].PublicKey < spec.Peers[j].PublicKey
+	})
+
+	return spec
+}
+
+// planDeviceSpec compares the current spec of a device with the desired one.
+// Omitted secrets, an omitted listen port and omitted peer endpoints keep their
+// current values.
+func planDeviceSpec(current, desired entity.DeviceSpec) deviceSpecPlan {
+	var plan deviceSpecPlan
+	var fields []string
+	req := &plan.device
+
+	if desired.PrivateKey != "" && normalizeKey(desired.PrivateKey) != normalizeKey(current.PrivateKey) {
+		fields = append(fields, "private_key")
+		req.PrivateKey = &desired.PrivateKey
+		plan.kernel = true
+	}
+	if desired.ListenPort != 0 && desired.ListenPort != current.ListenPort {
+		fields = append(fields, "listen_port")
+		req.ListenPort = &desired.ListenPort
+		plan.kernel = true
+	}
+	if desired.FirewallMark != current.FirewallMark {
+		fields = append(fields, "firewall_mark")
+		req.FirewallMark = &desired.FirewallMark
+		plan.kernel = true
+	}
+
+	diffList := func(field string, have, want []string, set *[]string) {
+		if !slices.Equal(have, want) {
+			fields = append(fields, field)
+			*set = want
+			if len(want) == 0 {
+				req.NullFields = append(req.NullFields, field)
+			}
+		}
+	}
+	diffList("addresses", current.Addresses, desired.Addresses, &req.Addresses)
+	diffList("dns", current.DNS, desired.DNS, &req.DNS)
+	if desired.MTU != current.MTU {
+		fields = append(fields, "mtu")
+		if desired.MTU != 0 {
+			req.MTU = &desired.MTU
+		} else {
+			req.NullFields = append(req.NullFields, "mtu")
+		}
+	}
+	if desired.Table != current.Table {
+		fields = append(fields, "table")
+		if desired.Table != "" {
+			req.Table = &desired.Table
+		} else {
+			req.NullFields = append(req.NullFields, "table")
+		}
+	}
+	diffList("pre_up", current.PreUp, desired.PreUp, &req.PreUp)
+	diffList("post_up", current.PostUp, desired.PostUp, &req.PostUp)
+	diffList("pre_down", current.PreDown, desired.PreDown, &req.PreDown)
+	diffList("post_down", current.PostDown, desired.PostDown, &req.PostDown)
+
+	if len(fields) > 0 {
+		plan.changes = append(plan.changes, entity.SpecChange{Action: entity.SpecChangeUpdate, Fields: fields})
+	}
+
+	existing := make(map[string]entity.PeerSpec, len(current.Peers))
+	for _, p := range current.Peers {
+		existing[normalizeKey(p.PublicKey)] = p
+	}
+
+	for _, want := range desired.Peers {
+		key := normalizeKey(want.PublicKey)
+		have, ok := existing[key]
+		if !ok {
+			plan.changes = append(plan.changes, entity.SpecChange{Action: entity.SpecChangeAdd, Peer: key})
+			plan.ops = append(plan.ops, entity.PeerBatchOperation{Op: entity.PeerBatchCreate, Peer: want.PeerRequest()})
+			continue
+		}
+		delete(existing, key)
+
+		if fields, peerReq := diffPeerSpec(have, want); len(fields) > 0 {
+			plan.changes = append(plan.changes, entity.SpecChange{Action: entity.SpecChangeUpdate, Peer: key, Fields: fields})
+			plan.ops = append(plan.ops, entity.PeerBatchOperation{Op: entity.PeerBatchUpdate, PublicKey: key, Peer: peerReq})
+		}
+	}
+
+	for _, p := range current.Peers {
+		key := normalizeKey(p.PublicKey)
+		if _, ok := existing[key]; ok {
+			plan.changes = append(plan.changes, entity.SpecChange{Action: entity.SpecChangeRemove, Peer: key})
+			plan.ops = append(plan.ops, entity.PeerBatchOperation{Op: entity.PeerBatchDelete, PublicKey: key})
+		}
+	}
+
+	return plan
+}
+
+// diffPeerSpec returns the changed fields of a peer and the update request
+// applying them.
+func diffPeerSpec(have, want entity.PeerSpec) ([]string, entity.PeerCreateOrUpdateRequest) {
+	var fields []string
+	var req entity.PeerCreateOrUpdateRequest
+
+	if want.PresharedKey != "" && normalizeKey(want.PresharedKey) != normalizeKey(have.PresharedKey) {
+		fields = append(fields, "preshared_key")
+		req.PresharedKey = &want.PresharedKey
+	}
+	if !slices.Equal(normalizePrefixes(have.AllowedIPs), normalizePrefixes(want.AllowedIPs)) {
+		fields = append(fields, "allowed_ips")
+		req.AllowedIPs = want.AllowedIPs
+		if len(want.AllowedIPs) == 0 {
+			req.NullFields = append(req.NullFields, "allowed_ips")
+		}
+	}
+	if want.Endpoint != "" && !sameEndpoint(have.Endpoint, want.Endpoint) {
+		fields = append(fields, "endpoint")
+		req.Endpoint = &want.Endpoint
+	}
+	if keepaliveOf(have.PersistentKeepaliveInterval) != keepaliveOf(want.PersistentKeepaliveInterval) {
+		fields = append(fields, "persistent_keepalive_interval")
+		if keepaliveOf(want.PersistentKeepaliveInterval) > 0 {
+			req.PersistentKeepaliveInterval = &want.PersistentKeepaliveInterval
+		} else {
+			req.NullFields = append(req.NullFields, "persistent_keepalive_interval")
+		}
+	}
+
+	return fields, req
+}
+
+// normalizeKey returns a key in standard base64, or s if it isn't a key.
+func normalizeKey(s string) string {
+	key, err := wgtypes.ParseKey(s)
+	if err != nil {
+		return s
+	}
+	return key.String()
+}
+
+// normalizePrefixes returns masked prefixes in sorted order.
+func normalizePrefixes(ips []string) []string {
+	normalized := make([]string, len(ips))
+	for i, ip := range ips {
+		normalized[i] = ip
+		if prefix, err := netip.ParsePrefix(ip); err == nil {
+			normalized[i] = prefix.Masked().String()
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// sameEndpoint compares endpoints, treating equal addresses as equal.
+func sameEndpoint(a, b string) bool {
+	addrA, errA := netip.ParseAddrPort(a)
+	addrB, errB := netip.ParseAddrPort(b)
+	if errA == nil && errB == nil {
+		return addrA == addrB
+	}
+	return a == b
+}
+
+// keepaliveOf parses a keepalive interval, treating invalid values as disabled.
+func keepaliveOf(s string) time.Duration {
+	d, _ := time.ParseDuration(s)
+	return d
+}
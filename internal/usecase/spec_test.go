@@ -0,0 +1,204 @@
+package usecase
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+)
+
+func testKey(t *testing.T) string {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	return key.PublicKey().String()
+}
+
+func TestPlanDeviceSpec_NoChanges(t *testing.T) {
+	peer := testKey(t)
+	current := entity.DeviceSpec{
+		Name:       "wg0",
+		ListenPort: 51820,
+		PrivateKey: testKey(t),
+		Addresses:  []string{"10.0.0.1/24"},
+		Peers: []entity.PeerSpec{{
+			PublicKey:                   peer,
+			PresharedKey:                testKey(t),
+			AllowedIPs:                  []string{"10.0.0.2/32", "10.0.1.0/24"},
+			Endpoint:                    "203.0.113.1:51820",
+			PersistentKeepaliveInterval: "25s",
+		}},
+	}
+
+	// Secrets, listen port and endpoint are omitted; allowed IPs are reordered
+	desired := entity.DeviceSpec{
+		Addresses: []string{"10.0.0.1/24"},
+		Peers: []entity.PeerSpec{{
+			PublicKey:                   peer,
+			AllowedIPs:                  []string{"10.0.1.7/24", "10.0.0.2/32"},
+			PersistentKeepaliveInterval: "25s",
+		}},
+	}
+
+	plan := planDeviceSpec(current, desired)
+	assert.Empty(t, plan.changes)
+	assert.Empty(t, plan.ops)
+	assert.False(t, plan.kernel)
+}
+
+func TestPlanDeviceSpec_Device(t *testing.T) {
+	current := entity.DeviceSpec{
+		ListenPort:   51820,
+		FirewallMark: 42,
+		DNS:          []string{"1.1.1.1"},
+		MTU:          1420,
+		PostUp:       []string{"iptables -A FORWARD -i %i -j ACCEPT"},
+	}
+	desired := entity.DeviceSpec{
+		ListenPort: 51821,
+		DNS:        []string{"9.9.9.9"},
+		Table:      "off",
+	}
+
+	plan := planDeviceSpec(current, desired)
+	require.Len(t, plan.changes, 1)
+	assert.Equal(t, entity.SpecChange{
+		Action: entity.SpecChangeUpdate,
+		Fields: []string{"listen_port", "firewall_mark", "dns", "mtu", "table", "post_up"},
+	}, plan.changes[0])
+	assert.True(t, plan.kernel)
+	assert.Equal(t, int32(51821), *plan.device.ListenPort)
+	assert.Equal(t, int32(0), *plan.device.FirewallMark)
+	assert.Equal(t, []string{"mtu", "post_up"}, plan.device.NullFields)
+
+	device := &entity.Device{DNS: current.DNS, MTU: current.MTU, PostUp: current.PostUp}
+	applyDeviceOptions(device, plan.device)
+	assert.Equal(t, []string{"9.9.9.9"}, device.DNS)
+	assert.Equal(t, int32(0), device.MTU)
+	assert.Equal(t, "off", device.Table)
+	assert.Empty(t, device.PostUp)
+}
+
+func TestPlanDeviceSpec_Peers(t *testing.T) {
+	kept, changed, removed, added := testKey(t), testKey(t), testKey(t), testKey(t)
+	current := entity.DeviceSpec{Peers: []entity.PeerSpec{
+		{PublicKey: kept, AllowedIPs: []string{"10.0.0.2/32"}},
+		{PublicKey: changed, AllowedIPs: []string{"10.0.0.3/32"}, PersistentKeepaliveInterval: "25s"},
+		{PublicKey: removed, AllowedIPs: []string{"10.0.0.4/32"}},
+	}}
+	desired := entity.DeviceSpec{Peers: []entity.PeerSpec{
+		{PublicKey: added, AllowedIPs: []string{"10.0.0.5/32"}},
+		{PublicKey: changed, Endpoint: "203.0.113.1:51820"},
+		{PublicKey: kept, AllowedIPs: []string{"10.0.0.2/32"}},
+	}}
+
+	plan := planDeviceSpec(current, desired)
+	assert.Equal(t, []entity.SpecChange{
+		{Action: entity.SpecChangeAdd, Peer: added},
+		{Action: entity.SpecChangeUpdate, Peer: changed, Fields: []string{"allowed_ips", "endpoint", "persistent_keepalive_interval"}},
+		{Action: entity.SpecChangeRemove, Peer: removed},
+	}, plan.changes)
+
+	require.Len(t, plan.ops, 3)
+	assert.Equal(t, entity.PeerBatchCreate, plan.ops[0].Op)
+	assert.Equal(t, added, *plan.ops[0].Peer.PublicKey)
+
+	assert.Equal(t, entity.PeerBatchUpdate, plan.ops[1].Op)
+	assert.Equal(t, changed, plan.ops[1].PublicKey)
+	assert.Equal(t, []string{"allowed_ips", "persistent_keepalive_interval"}, plan.ops[1].Peer.NullFields)
+	assert.Equal(t, "203.0.113.1:51820", *plan.ops[1].Peer.Endpoint)
+
+	assert.Equal(t, entity.PeerBatchOperation{Op: entity.PeerBatchDelete, PublicKey: removed}, plan.ops[2])
+}
+
+func TestDeviceSpec(t *testing.T) {
+	a, b := "b-key", "a-key"
+	spec := deviceSpec(&entity.Device{Name: "wg0", ListenPort: 51820}, []entity.Peer{
+		{PublicKey: a, PersistentKeepaliveInterval: "0s", ReceiveBytes: 100},
+		{PublicKey: b, PersistentKeepaliveInterval: "25s"},
+	})
+
+	assert.Equal(t, "wg0", spec.Name)
+	assert.Equal(t, []entity.PeerSpec{
+		{PublicKey: b, PersistentKeepaliveInterval: "25s"},
+		{PublicKey: a},
+	}, spec.Peers)
+}
+
+func TestConfigPeers(t *testing.T) {
+	dir := t.TempDir()
+	config := "[Interface]\nListenPort = 51820\n\n[Peer]\nPublicKey = peer\nAllowedIPs = 10.0.0.2/32\nPersistentKeepalive = 25\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "wg0.conf"), []byte(config), 0600))
+
+	svc, err := wgquick.NewService([]string{dir})
+	require.NoError(t, err)
+
+	peers, err := configPeers(svc, "wg0")
+	require.NoError(t, err)
+	assert.Equal(t, []entity.Peer{{
+		PublicKey:                   "peer",
+		AllowedIPs:                  []string{"10.0.0.2/32"},
+		PersistentKeepaliveInterval: "25s",
+	}}, peers)
+}
+
+func TestApplyConfigSpec(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	kept, removed, added := testKey(t), testKey(t), testKey(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wg0.conf")
+	config := "[Interface]\nPrivateKey = " + key.String() + "\nListenPort = 51820\n\n" +
+		"[Peer]\nPublicKey = " + kept + "\nAllowedIPs = 10.0.0.2/32\n\n" +
+		"[Peer]\nPublicKey = " + removed + "\nAllowedIPs = 10.0.0.3/32\n"
+	require.NoError(t, os.WriteFile(path, []byte(config), 0600))
+
+	svc, err := wgquick.NewService([]string{dir})
+	require.NoError(t, err)
+	uc := NewDeviceUseCase(nil, svc, nil)
+
+	spec := entity.DeviceSpec{Name: "wg0", ListenPort: 51821, Peers: []entity.PeerSpec{
+		{PublicKey: kept, AllowedIPs: []string{"10.0.0.2/32"}, Endpoint: "203.0.113.1:51820"},
+		{PublicKey: added, AllowedIPs: []string{"10.0.0.4/32"}},
+	}}
+	notRunning := domain.NotFound(entity.ErrCodeDeviceNotFound, "device wg0 not found")
+
+	// A dry run leaves the config file alone
+	result, err := uc.applyConfigSpec("wg0", spec, true, notRunning)
+	require.NoError(t, err)
+	assert.Len(t, result.Changes, 4)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, config, string(data))
+
+	result, err = uc.applyConfigSpec("wg0", spec, false, notRunning)
+	require.NoError(t, err)
+	assert.Len(t, result.Changes, 4)
+
+	cfg, err := svc.LoadConfig("wg0")
+	require.NoError(t, err)
+	assert.Equal(t, key.String(), cfg.PrivateKey)
+	assert.Equal(t, 51821, cfg.ListenPort)
+	peers := configFilePeers(cfg)
+	require.Len(t, peers, 2)
+	assert.Equal(t, kept, peers[0].PublicKey)
+	assert.Equal(t, "203.0.113.1:51820", peers[0].Endpoint)
+	assert.Equal(t, added, peers[1].PublicKey)
+
+	// Applying it again changes nothing
+	result, err = uc.applyConfigSpec("wg0", spec, false, notRunning)
+	require.NoError(t, err)
+	assert.Empty(t, result.Changes)
+
+	// Without a config file the device is not found
+	_, err = uc.applyConfigSpec("wg1", spec, false, notRunning)
+	assert.ErrorIs(t, err, notRunning)
+}
@@ -0,0 +1,155 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+	"github.com/suquant/wgrest/internal/infrastructure/wireguard"
+)
+
+// StateUseCase exports and imports the state of all devices.
+type StateUseCase struct {
+	wgClient   *wireguard.Client
+	wgquickSvc *wgquick.Service
+	devices    *DeviceUseCase
+	version    string
+}
+
+// NewStateUseCase creates a new state use case. version is recorded in exports.
+func NewStateUseCase(
+	wgClient *wireguard.Client,
+	wgquickSvc *wgquick.Service,
+	version string,
+) *StateUseCase {
+	return &StateUseCase{
+		wgClient:   wgClient,
+		wgquickSvc: wgquickSvc,
+		devices:    NewDeviceUseCase(wgClient, wgquickSvc, nil),
+		version:    version,
+	}
+}
+
+// ExportState returns every running and config-only device with its peers,
+// including private and preshared keys.
+func (uc *StateUseCase) ExportState(ctx context.Context) (*entity.State, error) {
+	devices, _, err := uc.devices.ListDevices(ctx, 0, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &entity.State{
+		Version:       entity.StateVersion,
+		WgrestVersion: uc.version,
+		ExportedAt:    time.Now().UTC(),
+		Devices:       make([]entity.DeviceState, 0, len(devices)),
+	}
+	for _, device := range devices {
+		spec, err := uc.devices.GetDeviceSpec(ctx, device.Name)
+		if err != nil {
+			return nil, fmt.Errorf("device %s: %w", device.Name, err)
+		}
+		state.Devices = append(state.Devices, entity.DeviceState{
+			DeviceSpec: *spec,
+			Running:    device.Running,
+		})
+	}
+
+	return state, nil
+}
+
+// ImportState restores the devices of a state. Running devices are brought to
+// their spec; for other devices the config file is written and, if they were
+// running when exported, the interface is brought up. Devices not in the state
+// are left alone. Import stops at the first device that fails.
+func (uc *StateUseCase) ImportState(ctx context.Context, state entity.State) (*entity.StateImportResult, error) {
+	if err := domain.ValidateState(state); err != nil {
+		return nil, err
+	}
+
+	result := &entity.StateImportResult{
+		Devices: make([]entity.DeviceImportResult, 0, len(state.Devices)),
+	}
+	for _, device := range state.Devices {
+		imported, err := uc.importDevice(ctx, device)
+		if err != nil {
+			return nil, fmt.Errorf("device %s: %w", device.Name, err)
+		}
+		result.Devices = append(result.Devices, *imported)
+	}
+
+	return result, nil
+}
+
+func (uc *StateUseCase) importDevice(ctx context.Context, state entity.DeviceState) (*entity.DeviceImportResult, error) {
+	unlock, err := deviceLocks.lock(ctx, state.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	_, err = uc.wgClient.Get(ctx, state.Name)
+	if err == nil {
+		applied, err := uc.devices.applyDeviceSpec(ctx, state.Name, state.DeviceSpec, false)
+		if err != nil {
+			return nil, err
+		}
+		return &entity.DeviceImportResult{
+			Name:    state.Name,
+			Action:  entity.StateSynced,
+			Changes: applied.Changes,
+		}, nil
+	}
+	if !errors.Is(err, domain.ErrNotFound) {
+		return nil, err
+	}
+
+	device, peers := specDevice(state.DeviceSpec)
+	if err := uc.wgquickSvc.SaveConfig(device, peers); err != nil {
+		return nil, err
+	}
+	if !state.Running {
+		return &entity.DeviceImportResult{Name: state.Name, Action: entity.StateWritten}, nil
+	}
+
+	if err := uc.wgquickSvc.Up(ctx, state.Name); err != nil {
+		return nil, err
+	}
+	return &entity.DeviceImportResult{Name: state.Name, Action: entity.StateStarted}, nil
+}
+
+// specDevice returns the device and peers described by a spec.
+func specDevice(spec entity.DeviceSpec) (*entity.Device, []entity.Peer) {
+	device := &entity.Device{
+		Name:         spec.Name,
+		ListenPort:   spec.ListenPort,
+		PrivateKey:   spec.PrivateKey,
+		FirewallMark: spec.FirewallMark,
+		Addresses:    spec.Addresses,
+		DNS:          spec.DNS,
+		MTU:          spec.MTU,
+		Table:        spec.Table,
+		PreUp:        spec.PreUp,
+		PostUp:       spec.PostUp,
+		PreDown:      spec.PreDown,
+		PostDown:     spec.PostDown,
+	}
+
+	peers := make([]entity.Peer, len(spec.Peers))
+	for i, p := range spec.Peers {
+		peers[i] = entity.Peer{
+			PublicKey:                   p.PublicKey,
+			PresharedKey:                p.PresharedKey,
+			AllowedIPs:                  p.AllowedIPs,
+			Endpoint:                    p.Endpoint,
+			PersistentKeepaliveInterval: p.PersistentKeepaliveInterval,
+		}
+	}
+
+	return device, peers
+}
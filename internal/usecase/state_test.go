@@ -0,0 +1,55 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+)
+
+func TestSpecDevice(t *testing.T) {
+	spec := entity.DeviceSpec{
+		Name:       "wg0",
+		ListenPort: 51820,
+		PrivateKey: "priv",
+		Addresses:  []string{"10.0.0.1/24"},
+		MTU:        1420,
+		PostUp:     []string{"iptables -A FORWARD -i %i -j ACCEPT"},
+		Peers: []entity.PeerSpec{{
+			PublicKey:                   "pub",
+			PresharedKey:                "psk",
+			AllowedIPs:                  []string{"10.0.0.2/32"},
+			Endpoint:                    "192.0.2.1:51820",
+			PersistentKeepaliveInterval: "25s",
+		}},
+	}
+
+	device, peers := specDevice(spec)
+	assert.Equal(t, "wg0", device.Name)
+	assert.Equal(t, int32(51820), device.ListenPort)
+	assert.Equal(t, "priv", device.PrivateKey)
+	assert.Equal(t, []string{"10.0.0.1/24"}, device.Addresses)
+	assert.Equal(t, int32(1420), device.MTU)
+	assert.Equal(t, spec.PostUp, device.PostUp)
+
+	require.Len(t, peers, 1)
+	assert.Equal(t, entity.Peer{
+		PublicKey:                   "pub",
+		PresharedKey:                "psk",
+		AllowedIPs:                  []string{"10.0.0.2/32"},
+		Endpoint:                    "192.0.2.1:51820",
+		PersistentKeepaliveInterval: "25s",
+	}, peers[0])
+}
+
+func TestImportState_Validation(t *testing.T) {
+	uc := NewStateUseCase(nil, nil, "")
+
+	_, err := uc.ImportState(context.Background(), entity.State{Version: entity.StateVersion + 1})
+	require.Error(t, err)
+	assert.Equal(t, entity.ErrCodeValidationFailed, domain.Code(err))
+}
@@ -0,0 +1,111 @@
+package usecase
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+
+	"github.com/suquant/wgrest/internal/domain"
+	"github.com/suquant/wgrest/internal/domain/entity"
+	"github.com/suquant/wgrest/internal/infrastructure/dump"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+	"github.com/suquant/wgrest/internal/infrastructure/wireguard"
+)
+
+// Config watch policies decide what happens when the config file of a running
+// device is edited outside wgrest.
+const (
+	// ConfigWatchApply brings the running device to the edited file
+	ConfigWatchApply = "apply"
+
+	// ConfigWatchDrift flags the device as drifted and keeps the edit
+	ConfigWatchDrift = "drift"
+
+	// ConfigWatchIgnore does nothing; the next dump overwrites the edit
+	ConfigWatchIgnore = "ignore"
+)
+
+// ConfigWatchPolicies are the valid config watch policies.
+var ConfigWatchPolicies = []string{ConfigWatchApply, ConfigWatchDrift, ConfigWatchIgnore}
+
+// ConfigWatchUseCase reconciles config files edited outside wgrest.
+type ConfigWatchUseCase struct {
+	wgquickSvc *wgquick.Service
+	dumpSvc    *dump.Service
+	devices    *DeviceUseCase
+	policy     string
+}
+
+// NewConfigWatchUseCase creates a new config watch use case.
+func NewConfigWatchUseCase(
+	wgClient *wireguard.Client,
+	wgquickSvc *wgquick.Service,
+	dumpSvc *dump.Service,
+	policy string,
+) *ConfigWatchUseCase {
+	return &ConfigWatchUseCase{
+		wgquickSvc: wgquickSvc,
+		dumpSvc:    dumpSvc,
+		devices:    NewDeviceUseCase(wgClient, wgquickSvc, dumpSvc),
+		policy:     policy,
+	}
+}
+
+// Reconcile handles a change of the config file of a device in dir. Files
+// that match the running device, including the ones wgrest writes itself,
+// files of devices with a pending save and files of devices that aren't
+// running are left alone.
+func (uc *ConfigWatchUseCase) Reconcile(ctx context.Context, dir, name string) error {
+	if uc.policy == ConfigWatchIgnore {
+		return nil
+	}
+
+	// Only the file wg-quick would use for the device matters
+	if filepath.Clean(uc.wgquickSvc.GetConfigDir(name)) != filepath.Clean(dir) {
+		return nil
+	}
+
+	unlock, err := deviceLocks.lock(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// A file that differs because an API change hasn't been saved yet isn't
+	// an external edit; the save triggers another reconcile once written
+	if uc.dumpSvc.Pending(name) {
+		return nil
+	}
+
+	inSync, err := uc.dumpSvc.InSync(name)
+	if err != nil {
+		if domain.Code(err) == entity.ErrCodeDeviceNotFound {
+			return nil
+		}
+		return err
+	}
+	if inSync {
+		uc.dumpSvc.SetDrifted(name, false)
+		return nil
+	}
+
+	if uc.policy == ConfigWatchDrift {
+		uc.dumpSvc.SetDrifted(name, true)
+		log.Printf("Config for %s was edited outside wgrest and differs from the running device", name)
+		return nil
+	}
+
+	data, err := uc.wgquickSvc.ReadConfigIn(dir, name)
+	if err != nil {
+		return err
+	}
+	_, changes, err := uc.devices.restoreConfig(ctx, dir, name, []byte(data), false)
+	if err != nil {
+		// Keep the edit until someone fixes it
+		uc.dumpSvc.SetDrifted(name, true)
+		return err
+	}
+	uc.dumpSvc.SetDrifted(name, false)
+	log.Printf("Applied external edit of %s config (%d changes)", name, len(changes))
+	return nil
+}
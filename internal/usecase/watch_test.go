@@ -0,0 +1,51 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/suquant/wgrest/internal/infrastructure/dump"
+	"github.com/suquant/wgrest/internal/infrastructure/wgquick"
+)
+
+func TestConfigWatchReconcile_Skipped(t *testing.T) {
+	dir1 := t.TempDir()
+	dir2 := t.TempDir()
+	svc, err := wgquick.NewService([]string{dir1, dir2})
+	require.NoError(t, err)
+	require.NoError(t, svc.WriteConfigIn(dir1, "wg0", []byte("[Interface]\nMTU = 1420\n")))
+
+	// The ignore policy never looks at the device
+	uc := NewConfigWatchUseCase(nil, svc, nil, ConfigWatchIgnore)
+	assert.NoError(t, uc.Reconcile(context.Background(), dir1, "wg0"))
+
+	// A file shadowed by an earlier config directory isn't used by wg-quick
+	uc = NewConfigWatchUseCase(nil, svc, nil, ConfigWatchApply)
+	require.NoError(t, svc.WriteConfigIn(dir2, "wg0", []byte("[Interface]\nMTU = 1280\n")))
+	assert.NoError(t, uc.Reconcile(context.Background(), dir2, "wg0"))
+}
+
+func TestConfigWatchReconcile_PendingSave(t *testing.T) {
+	dir := t.TempDir()
+	svc, err := wgquick.NewService([]string{dir})
+	require.NoError(t, err)
+	require.NoError(t, svc.WriteConfigIn(dir, "wg0", []byte("[Interface]\nMTU = 1420\n")))
+
+	// An API change whose debounced save hasn't been written yet
+	dumpSvc := dump.NewService(time.Hour, time.Hour, nil, svc)
+	dumpSvc.Request("wg0")
+
+	for _, policy := range []string{ConfigWatchApply, ConfigWatchDrift} {
+		uc := NewConfigWatchUseCase(nil, svc, dumpSvc, policy)
+		require.NoError(t, uc.Reconcile(context.Background(), dir, "wg0"))
+
+		status := dumpSvc.Status()
+		require.Len(t, status, 1)
+		assert.False(t, status[0].Drifted)
+		assert.True(t, status[0].Pending)
+	}
+}